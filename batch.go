@@ -0,0 +1,71 @@
+package sparse
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SolveComplexBatch solves the complex matrix at every angular frequency of omegas. For each point the
+// matrix is cleared, stamped by stamp, factored and solved with the rhs and irhs stamp returns, laid out
+// as in SolveComplex. Points run concurrently, so stamp must not change vectors returned for other points.
+// The points are shared among Config.Workers goroutines, each with a copy of the ordered structure,
+// and the solutions are returned in the order of omegas, irhs solutions being nil unless
+// SeparatedComplexVectors. stamp must only use the matrix it is given, through GetElement or the Get
+// functions of templates, which need Config.Translate once the matrix is reordered.
+//
+// The matrix itself is only stamped and factored at the first frequency when it has not been ordered yet.
+func (m *Matrix) SolveComplexBatch(omegas []float64, stamp func(m *Matrix, omega float64) (rhs, irhs []float64)) ([][]float64, [][]float64, error) {
+	if !m.Complex {
+		return nil, nil, fmt.Errorf("matrix must be complex")
+	}
+	if len(omegas) == 0 {
+		return nil, nil, nil
+	}
+
+	if m.NeedsOrdering {
+		m.Clear()
+		stamp(m, omegas[0])
+		if err := m.Factor(); err != nil {
+			return nil, nil, fmt.Errorf("ordering at omega %g: %v", omegas[0], err)
+		}
+	}
+
+	solutions := make([][]float64, len(omegas))
+	isolutions := make([][]float64, len(omegas))
+	errs := make([]error, len(omegas))
+
+	workers := min(max(m.Config.Workers, 1), len(omegas))
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		// Points are factored one at a time on each copy
+		c := m.CloneStructure()
+		c.Config.Workers = 1
+		c.Config.PartialFactor = false // stamp may add to the elements directly
+		c.FactorLevels = nil
+		c.SolveLevels = nil
+
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for point := worker; point < len(omegas); point += workers {
+				c.Clear()
+				rhs, irhs := stamp(c, omegas[point])
+				if err := c.Factor(); err != nil {
+					errs[point] = err
+					continue
+				}
+				solutions[point], isolutions[point], errs[point] = c.SolveComplex(rhs, irhs)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for point, err := range errs {
+		if err != nil {
+			return nil, nil, fmt.Errorf("omega %g: %v", omegas[point], err)
+		}
+	}
+
+	return solutions, isolutions, nil
+}
@@ -0,0 +1,49 @@
+package sparse
+
+import "testing"
+
+func TestSolveComplexBatch(t *testing.T) {
+	const n = 30
+	deltas := testDeltas(n, 3, true, 7)
+	atOmega := func(omega float64) []ElementDelta {
+		changed := append([]ElementDelta(nil), deltas...)
+		for i := range changed {
+			if changed[i].Row == changed[i].Col {
+				changed[i].Imag += omega
+			}
+		}
+		return changed
+	}
+	rhsAt := func(omega float64) []complex128 {
+		b := testRHS(n)
+		b[1] += complex(omega, 0.0)
+		return b
+	}
+
+	m, err := Create(n, &Configuration{Real: true, Complex: true, Translate: true, Workers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamp := func(m *Matrix, omega float64) ([]float64, []float64) {
+		for _, delta := range atOmega(omega) {
+			element := m.GetElement(delta.Row, delta.Col)
+			element.Real += delta.Real
+			element.Imag += delta.Imag
+		}
+		return m.fromComplexVector(rhsAt(omega), 0)
+	}
+
+	omegas := []float64{0.0, 0.5, 1.0, 2.0, 4.0, 8.0, 16.0}
+	x, ix, err := m.SolveComplexBatch(omegas, stamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, omega := range omegas {
+		y, err := m.toComplexVector(x[i], ix[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := rhsAt(omega)
+		testClose(t, "batch", y[:len(b)], testReference(t, n, atOmega(omega), true, b, false), 1e-9)
+	}
+}
@@ -64,6 +64,32 @@ func (m *Matrix) complexReciprocal(e *Element) {
 	}
 }
 
+// multAssign sets a = a * b, as complexMultAssign
+func (a *ComplexNumber) multAssign(b ComplexNumber) {
+	aReal := a.Real
+	a.Real = aReal*b.Real - a.Imag*b.Imag
+	a.Imag = aReal*b.Imag + a.Imag*b.Real
+}
+
+// multSubtAssign sets result = result - (a * b), as complexMultSubtAssign
+func (result *ComplexNumber) multSubtAssign(a, b ComplexNumber) {
+	result.Real -= a.Real*b.Real - a.Imag*b.Imag
+	result.Imag -= a.Real*b.Imag + a.Imag*b.Real
+}
+
+// reciprocal sets e = 1 / e, as complexReciprocal
+func (e *ComplexNumber) reciprocal() {
+	if (e.Real >= e.Imag && e.Real > -e.Imag) || (e.Real < e.Imag && e.Real <= -e.Imag) {
+		r := e.Imag / e.Real
+		e.Real = 1.0 / (e.Real + r*e.Imag)
+		e.Imag = -r * e.Real
+	} else {
+		r := e.Real / e.Imag
+		e.Imag = -1.0 / (e.Imag + r*e.Real)
+		e.Real = -r * e.Imag
+	}
+}
+
 /* Element, Quad Template */
 
 func (e *Element) AddComplexElement(real, imag float64) {
@@ -71,21 +97,31 @@ func (e *Element) AddComplexElement(real, imag float64) {
 	e.Imag += imag
 }
 
+// AddRealQuad adds real to Element1 and Element2 and subtracts it from the negated elements of a
+// template from GetAdmittance, GetQuad or GetOnes
 func (t *Template) AddRealQuad(real float64) {
-	t.Element1.Real += real
-	t.Element2.Real += real
-	t.Element3Negated.Real -= real
-	t.Element4Negated.Real -= real
+	t.AddComplexQuad(real, 0.0)
 }
 
+// AddImagQuad is the imaginary version of AddRealQuad
 func (t *Template) AddImagQuad(imag float64) {
-	t.Element1.Imag += imag
-	t.Element2.Imag += imag
-	t.Element3Negated.Imag -= imag
-	t.Element4Negated.Imag -= imag
+	t.AddComplexQuad(0.0, imag)
 }
 
+// AddComplexQuad is the complex version of AddRealQuad
 func (t *Template) AddComplexQuad(real, imag float64) {
-	t.AddRealQuad(real)
-	t.AddImagQuad(imag)
+	t.add(t.Element1, real, imag)
+	t.add(t.Element2, real, imag)
+	t.add(t.Element3Negated, -real, -imag)
+	t.add(t.Element4Negated, -real, -imag)
+}
+
+// add adds to an element through the matrix that filled the template, see AddToElement
+func (t *Template) add(element *Element, real, imag float64) {
+	if t.matrix != nil {
+		t.matrix.AddToElement(element, real, imag)
+		return
+	}
+	element.Real += real
+	element.Imag += imag
 }
@@ -0,0 +1,110 @@
+package sparse
+
+import "slices"
+
+// Clone returns a deep copy of the matrix: elements and fill-ins with their values, the ordering,
+// translation maps, Markowitz arrays, partition flags, status and factors. The copy is independent,
+// stamping or factoring one does not affect the other.
+func (m *Matrix) Clone() *Matrix {
+	return m.clone(true)
+}
+
+// CloneStructure returns a copy of the pattern, ordering and partition of the matrix with zero values.
+// Once stamped, Factor reuses the pivots of the matrix.
+func (m *Matrix) CloneStructure() *Matrix {
+	return m.clone(false)
+}
+
+// clone returns a deep copy of the matrix. Without values the elements are zero and the copy is
+// not factored, but it keeps the pattern, ordering and partition so that Factor reuses the pivots.
+func (m *Matrix) clone(values bool) *Matrix {
+	m.syncFactors()
+	c := *m
+
+	copyOf := func(element *Element) Element {
+		e := Element{Row: element.Row, Col: element.Col, index: element.index}
+		if values {
+			e.Real = element.Real
+			e.Imag = element.Imag
+			if element.InitInfo != nil {
+				initInfo := *element.InitInfo
+				e.InitInfo = &initInfo
+			}
+		}
+		return e
+	}
+
+	// Elements, with their links translated to the copies
+	copies := make(map[*Element]*Element, m.Elements)
+	elements := make([]Element, 0, m.Elements)
+	for col := int64(1); col <= m.Size; col++ {
+		for element := m.FirstInCol[col]; element != nil; element = element.NextInCol {
+			elements = append(elements, copyOf(element))
+			copies[element] = &elements[len(elements)-1]
+		}
+	}
+	for original, e := range copies {
+		e.NextInRow = copies[original.NextInRow]
+		e.NextInCol = copies[original.NextInCol]
+	}
+
+	translate := func(list []*Element) []*Element {
+		if list == nil {
+			return nil
+		}
+		translated := make([]*Element, len(list))
+		for i, element := range list {
+			translated[i] = copies[element]
+		}
+		return translated
+	}
+	c.Diags = translate(m.Diags)
+	c.FirstInRow = translate(m.FirstInRow)
+	c.FirstInCol = translate(m.FirstInCol)
+
+	c.TrashCan = &Element{}
+	if m.GroundRow != nil {
+		c.GroundRow = make([]*Element, len(m.GroundRow))
+		for col, element := range m.GroundRow {
+			if element != nil {
+				e := copyOf(element)
+				c.GroundRow[col] = &e
+			}
+		}
+	}
+
+	if m.Intermediate != nil {
+		c.Intermediate = make([]float64, len(m.Intermediate))
+	}
+	c.MarkowitzRow = slices.Clone(m.MarkowitzRow)
+	c.MarkowitzCol = slices.Clone(m.MarkowitzCol)
+	c.MarkowitzProd = slices.Clone(m.MarkowitzProd)
+	c.DoRealDirect = slices.Clone(m.DoRealDirect)
+	c.DoComplexDirect = slices.Clone(m.DoComplexDirect)
+
+	c.IntToExtRowMap = slices.Clone(m.IntToExtRowMap)
+	c.IntToExtColMap = slices.Clone(m.IntToExtColMap)
+	c.ExtToIntRowMap = slices.Clone(m.ExtToIntRowMap)
+	c.ExtToIntColMap = slices.Clone(m.ExtToIntColMap)
+
+	c.ChangedCols = slices.Clone(m.ChangedCols)
+	c.OriginalStart = slices.Clone(m.OriginalStart)
+	c.OriginalValues = nil
+	c.previousValues = nil
+	if values {
+		c.OriginalValues = slices.Clone(m.OriginalValues)
+		c.previousValues = slices.Clone(m.previousValues)
+	} else {
+		c.Factored = false
+		c.cleared = false
+	}
+
+	// The level schedules are never modified once built and are shared, the goroutines are not
+	c.pool = nil
+	c.CSC = nil
+	if m.CSC != nil {
+		c.buildCompressed()
+	}
+
+	return &c
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/edp1096/sparse"
+)
+
+func main() {
+	var err error
+
+	annotate := 0
+	separatedComplexVectors := false
+	defaultPartition := sparse.AUTO_PARTITION
+
+	config := &sparse.Configuration{
+		Real:                    true,
+		Complex:                 true,
+		SeparatedComplexVectors: separatedComplexVectors,
+		Expandable:              true,
+		Translate:               true,
+		ModifiedNodal:           true,
+		TiesMultiplier:          5,
+		DefaultPartition:        defaultPartition,
+		PrinterWidth:            140,
+		Annotate:                annotate,
+		Workers:                 4,
+	}
+
+	A, err := sparse.Create(2, config)
+	if err != nil {
+		panic(err)
+	}
+
+	/* Drive the circuit at node 1. */
+	b := make([]float64, 3)
+	ib := make([]float64, 3)
+	b[1] = 1.0
+
+	sb := make([]float64, 6)
+	sb[2] = 1.0 // node1 real
+
+	/* Same circuit as ac1, stamped into each copy of the matrix. The drive does not change with frequency. */
+	stamp := func(m *sparse.Matrix, omega float64) ([]float64, []float64) {
+		stamps := make([]sparse.Template, 3)
+		m.GetAdmittance(1, 0, &stamps[0])
+		m.GetAdmittance(1, 2, &stamps[1])
+		m.GetAdmittance(2, 0, &stamps[2])
+
+		stamps[0].AddComplexQuad(1.0/50.0, 10e-6*omega)
+		stamps[1].AddRealQuad(1.0 / 200.0)
+		stamps[2].AddComplexQuad(1.0/50.0, 10e-6*omega)
+
+		if config.SeparatedComplexVectors {
+			return b, ib
+		}
+		return sb, nil
+	}
+
+	frequencies := []float64{}
+	omegas := []float64{}
+	for f := 0.0; f <= 2000.0; f += 100.0 {
+		frequencies = append(frequencies, f)
+		omegas = append(omegas, 2.0*math.Pi*f)
+	}
+
+	x, ix, err := A.SolveComplexBatch(omegas, stamp)
+	if err != nil {
+		panic(err)
+	}
+
+	for i, f := range frequencies {
+		var magnitude float64
+		if config.SeparatedComplexVectors {
+			magnitude = math.Sqrt(x[i][2]*x[i][2] + ix[i][2]*ix[i][2])
+		} else {
+			magnitude = math.Sqrt(x[i][4]*x[i][4] + x[i][5]*x[i][5])
+		}
+
+		db := 20 * math.Log10(magnitude)
+
+		fmt.Printf("f = %04.0f Hz, h = %.6f, Gain = %.2f dB\n", f, magnitude, db)
+	}
+
+	A.Destroy()
+}
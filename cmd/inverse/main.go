@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math/cmplx"
+	"math/rand"
+
+	"github.com/edp1096/sparse"
+)
+
+/* Diagonal and selected entries of the inverse of a resistive grid and of a complex matrix, by the Takahashi equations */
+
+const (
+	GRID = 20 // Nodes per side of the grid
+	SIZE = 50 // Size of the complex matrix
+)
+
+func main() {
+	config := &sparse.Configuration{
+		Real:           true,
+		Expandable:     true,
+		Translate:      true,
+		ModifiedNodal:  true,
+		TiesMultiplier: 5,
+		PrinterWidth:   140,
+	}
+
+	// Power grid of 1 ohm segments, each node tied to ground by 1 kohm: A⁻¹(i,i) is the IR drop at
+	// node i for 1 A drawn there
+	A, err := sparse.Create(GRID*GRID, config)
+	if err != nil {
+		panic(err)
+	}
+	defer A.Destroy()
+
+	node := func(x, y int) int64 { return int64(y*GRID + x + 1) }
+	conductance := func(a, b int64, g float64) {
+		A.GetElement(a, a).Real += g
+		if b != 0 {
+			A.GetElement(b, b).Real += g
+			A.GetElement(a, b).Real -= g
+			A.GetElement(b, a).Real -= g
+		}
+	}
+	for y := 0; y < GRID; y++ {
+		for x := 0; x < GRID; x++ {
+			conductance(node(x, y), 0, 1e-3)
+			if x+1 < GRID {
+				conductance(node(x, y), node(x+1, y), 1.0)
+			}
+			if y+1 < GRID {
+				conductance(node(x, y), node(x, y+1), 1.0)
+			}
+		}
+	}
+	if err := A.OrderAndFactor(nil, 0.0, -1.0, true); err != nil {
+		panic(err)
+	}
+
+	diagonal, _, err := A.InverseDiagonal()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Grid of %d nodes, %d fill-ins\n", GRID*GRID, A.FillinCount())
+	fmt.Printf("Drop at the corner %.6g V/A, at the center %.6g V/A\n", diagonal[node(0, 0)], diagonal[node(GRID/2, GRID/2)])
+
+	reference, err := A.InverseDense()
+	if err != nil {
+		panic(err)
+	}
+	pairs := []sparse.Entry{{Row: node(0, 0), Col: node(GRID-1, GRID-1)}, {Row: node(3, 4), Col: node(4, 4)}}
+	entries, _, err := A.InverseEntries(pairs)
+	if err != nil {
+		panic(err)
+	}
+	worst := 0.0
+	for i := int64(1); i <= GRID*GRID; i++ {
+		worst = max(worst, cmplx.Abs(complex(diagonal[i], 0)-reference[i][i]))
+	}
+	for i, pair := range pairs {
+		worst = max(worst, cmplx.Abs(complex(entries[i], 0)-reference[pair.Row][pair.Col]))
+	}
+	fmt.Printf("Largest difference to the dense inverse = %.3g, relative to its largest entry\n\n", worst/largest(reference))
+
+	// Unsymmetric complex matrix, with its pivots off the diagonal
+	config.Real, config.Complex = false, true
+	B, err := sparse.Create(SIZE, config)
+	if err != nil {
+		panic(err)
+	}
+	defer B.Destroy()
+
+	r := rand.New(rand.NewSource(1))
+	for i := int64(1); i <= SIZE; i++ {
+		for k := 0; k < 3; k++ {
+			element := B.GetElement(i, r.Int63n(SIZE)+1)
+			element.Real += r.Float64() - 0.5
+			element.Imag += r.Float64() - 0.5
+		}
+		element := B.GetElement(i, i%SIZE+1)
+		element.Real += 2.0
+	}
+	if err := B.OrderAndFactor(nil, 0.0, -1.0, false); err != nil {
+		panic(err)
+	}
+
+	cdiagonal, _, err := B.InverseDiagonal()
+	if err != nil {
+		panic(err)
+	}
+	creference, err := B.InverseDense()
+	if err != nil {
+		panic(err)
+	}
+	var cpairs []sparse.Entry
+	for i := int64(1); i <= SIZE; i += 7 {
+		cpairs = append(cpairs, sparse.Entry{Row: i, Col: SIZE + 1 - i})
+	}
+	centries, _, err := B.InverseEntries(cpairs)
+	if err != nil {
+		panic(err)
+	}
+	worst = 0.0
+	for i := int64(1); i <= SIZE; i++ {
+		value := complex(cdiagonal[2*i], cdiagonal[2*i+1])
+		worst = max(worst, cmplx.Abs(value-creference[i][i]))
+	}
+	for n, pair := range cpairs {
+		value := complex(centries[2*n], centries[2*n+1])
+		worst = max(worst, cmplx.Abs(value-creference[pair.Row][pair.Col]))
+	}
+	fmt.Printf("Complex matrix of size %d, %d fill-ins\n", SIZE, B.FillinCount())
+	fmt.Printf("Largest difference to the dense inverse = %.3g, relative to its largest entry\n", worst/largest(creference))
+}
+
+func largest(inverse [][]complex128) float64 {
+	norm := 0.0
+	for _, row := range inverse {
+		for _, value := range row {
+			norm = max(norm, cmplx.Abs(value))
+		}
+	}
+	return norm
+}
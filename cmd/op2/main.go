@@ -45,9 +45,11 @@ func main() {
 	A.GetElement(2, 1).Real += -G1
 	A.GetElement(2, 2).Real += G1 + G2
 
-	// Branch stamp
-	A.GetElement(3, 1).Real = 1.0 // v1 coefficient
-	A.GetElement(1, 3).Real = 1.0 // KCL current term
+	// Branch stamp: v1 coefficient and KCL current term
+	var source sparse.Template
+	if err := A.GetOnes(1, 0, 3, &source); err != nil {
+		log.Fatalf("Failed to get ones: %v", err)
+	}
 
 	fmt.Println("Matrix before factorization:")
 	A.Print(false, true, true)
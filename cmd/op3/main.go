@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/edp1096/sparse"
+)
+
+/* Modified nodal analysis with named nodes */
+
+func main() {
+	const (
+		R1  = 1000.0
+		R2  = 2000.0
+		Vin = 5.0
+	)
+
+	config := &sparse.Configuration{
+		Real:          true,
+		Complex:       false,
+		Expandable:    true,
+		Translate:     true,
+		ModifiedNodal: true,
+		GroundRow:     true,
+		PrinterWidth:  140,
+	}
+
+	A, err := sparse.Create(0, config)
+	if err != nil {
+		log.Fatalf("Failed to create matrix: %v", err)
+	}
+	defer A.Destroy()
+
+	nodes := sparse.NewNodeMap(A)
+
+	var r1, r2, vs sparse.Template
+	nodes.Admittance("in", "out", &r1)
+	nodes.Admittance("out", "gnd", &r2)
+	if err := nodes.Ones("in", "0", "I(V1)", &vs); err != nil {
+		log.Fatalf("Failed to get ones: %v", err)
+	}
+	r1.AddRealQuad(1.0 / R1)
+	r2.AddRealQuad(1.0 / R2)
+
+	b, _ := nodes.Vector()
+	b[nodes.Node("I(V1)")] = Vin
+
+	err = A.Factor()
+	if err != nil {
+		log.Fatalf("Failed to factor matrix: %v", err)
+	}
+
+	x, err := A.Solve(b)
+	if err != nil {
+		log.Fatalf("Failed to solve matrix: %v", err)
+	}
+
+	fmt.Printf("Results:\n")
+	for i := int64(1); i <= nodes.Size(); i++ {
+		value, _, _ := nodes.Value(nodes.Name(i), x, nil)
+		fmt.Printf("%-6s = %.4f\n", nodes.Name(i), value)
+	}
+
+	ground, _, _ := A.GroundRowProduct(x, nil)
+	fmt.Printf("KCL sum at ground = %.4e A\n", ground)
+}
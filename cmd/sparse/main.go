@@ -38,6 +38,10 @@ type App struct {
 	iterations     int
 	useColumnAsRHS bool
 	columnAsRHS    int64
+	partialChanges int
+	denseThreshold float64
+	compressed     bool
+	workers        int
 	rhs            []float64
 	irhs           []float64
 	solution       []float64
@@ -50,7 +54,7 @@ type App struct {
 	conditionNumber float64
 	psudoCondition  float64
 	determinant     float64
-	iDeterminant    *float64
+	iDeterminant    float64 // Imaginary part of the mantissa of a complex determinant
 	detExponent     int
 	detTime         float64
 
@@ -58,6 +62,11 @@ type App struct {
 	factorTime float64
 	solveTime  float64
 	startTime  time.Time
+
+	refactorTime   float64
+	partialTime    float64
+	partialColumns int
+	partialError   float64 // Largest difference from the full refactorization, relative to the solution
 }
 
 func InitApp() *App {
@@ -136,6 +145,9 @@ func (a *App) readMatrixFromFile(filename string) error {
 		PseudoCondition:         pseudoCondition,
 		Determinant:             determinant,
 		Multiplication:          multiplication,
+		DenseThreshold:          a.denseThreshold,
+		Compressed:              a.compressed,
+		Workers:                 a.workers,
 		DefaultPartition:        defaultPartition,
 		TiesMultiplier:          5,
 		PrinterWidth:            120,
@@ -365,19 +377,7 @@ func (a *App) solve() error {
 		a.factorTime += time.Since(factorStart).Seconds()
 
 		solveStart := time.Now()
-		if a.matrix.Complex {
-			if a.matrix.Config.Transpose {
-				a.solution, a.isolution, err = a.matrix.SolveComplexTransposed(a.rhs, a.irhs)
-			} else {
-				a.solution, a.isolution, err = a.matrix.SolveComplex(a.rhs, a.irhs)
-			}
-		} else {
-			if a.matrix.Config.Transpose {
-				a.solution, err = a.matrix.SolveTransposed(a.rhs)
-			} else {
-				a.solution, err = a.matrix.Solve(a.rhs)
-			}
-		}
+		a.solution, a.isolution, err = a.solveFactored()
 		a.solveTime += time.Since(solveStart).Seconds()
 		if err != nil {
 			return fmt.Errorf("solve failed: %v", err)
@@ -421,18 +421,29 @@ func (a *App) solve() error {
 	var det float64
 	if !a.solutionOnly && a.matrix.Config.Determinant {
 		startTime := time.Now()
-		a.determinant, a.detExponent, a.iDeterminant = a.matrix.Determinant()
+		logAbs, sign, err := a.matrix.LogDeterminant()
 		a.detTime = time.Since(startTime).Seconds()
+		if err != nil {
+			return fmt.Errorf("determinant failed: %v", err)
+		}
 
-		if a.matrix.Complex {
-			det = math.Hypot(a.determinant, *a.iDeterminant)
-			for det >= 10.0 {
-				det *= 0.1
-				a.detExponent++
+		// Mantissa and exponent, the sign or phase carried by the mantissa
+		if sign != 0.0 {
+			exponent := math.Floor(logAbs / math.Ln10)
+			mantissa := math.Pow(10.0, logAbs/math.Ln10-exponent)
+			a.determinant = mantissa * real(sign)
+			a.iDeterminant = mantissa * imag(sign)
+
+			// Parts within rounding of the phase are zero, as on the axes
+			if math.Abs(a.determinant) < 1e-12*mantissa {
+				a.determinant = 0.0
 			}
-		} else {
-			det = a.determinant
+			if math.Abs(a.iDeterminant) < 1e-12*mantissa {
+				a.iDeterminant = 0.0
+			}
+			a.detExponent = int(exponent)
 		}
+		det = a.determinant
 	}
 
 	var normalizedResidual, maxRHS float64
@@ -443,6 +454,12 @@ func (a *App) solve() error {
 		}
 	}
 
+	if !a.solutionOnly && a.partialChanges > 0 {
+		if err = a.partialRefactor(); err != nil {
+			return fmt.Errorf("partial refactor failed: %v", err)
+		}
+	}
+
 	if !a.solutionOnly {
 		additionalLines := ""
 
@@ -457,6 +474,18 @@ func (a *App) solve() error {
 				fmt.Printf("Condition time = %.3f.\n", conditionTime/float64(a.iterations))
 			}
 		}
+		if a.partialChanges > 0 {
+			fmt.Printf("Full refactor time = %.3f.\n", a.refactorTime)
+			if a.partialColumns > 0 {
+				fmt.Printf("Partial refactor time = %.3f (%d of %d columns).\n", a.partialTime, a.partialColumns, a.matrix.Size)
+			} else {
+				fmt.Printf("Partial refactor time = %.3f (full refactorization, changes reach too many columns).\n", a.partialTime)
+			}
+			if a.partialColumns == 0 || a.partialTime >= a.refactorTime {
+				fmt.Printf("Partial refactor gave no gain.\n")
+			}
+			fmt.Printf("Partial refactor difference = %.2g.\n", a.partialError)
+		}
 
 		if a.matrix.Config.Stability {
 			if a.largestBefore != 0.0 {
@@ -475,9 +504,14 @@ func (a *App) solve() error {
 		}
 		if a.matrix.Config.Determinant {
 			fmt.Printf("Determinant time = %.2f.\n", a.detTime)
-			if det != 0.0 && a.detExponent != 0 {
+			switch {
+			case a.matrix.Complex && a.detExponent != 0:
+				additionalLines += fmt.Sprintf("Determinant = (%.3g%+.3gj)e%d\n", det, a.iDeterminant, a.detExponent)
+			case a.matrix.Complex:
+				additionalLines += fmt.Sprintf("Determinant = %.3g%+.3gj\n", det, a.iDeterminant)
+			case det != 0.0 && a.detExponent != 0:
 				additionalLines += fmt.Sprintf("Determinant = %.3ge%d\n", det, a.detExponent)
-			} else {
+			default:
 				additionalLines += fmt.Sprintf("Determinant = %.3g\n", det)
 			}
 		}
@@ -488,6 +522,9 @@ func (a *App) solve() error {
 		fmt.Printf("\nTotal number of elements = %d\n", a.matrix.ElementCount())
 		fmt.Printf("Average number of elements per row initially = %.2f\n", float64(a.matrix.ElementCount()-a.matrix.FillinCount())/float64(a.matrix.GetSize(false)))
 		fmt.Printf("Total number of fill-ins = %d\n", a.matrix.Fillins)
+		if a.matrix.DenseStep > 0 {
+			fmt.Printf("Dense switch-over step = %d of %d\n", a.matrix.DenseStep, a.matrix.Size)
+		}
 		fmt.Println()
 
 		fmt.Print(additionalLines)
@@ -495,6 +532,98 @@ func (a *App) solve() error {
 	return nil
 }
 
+// partialRefactor times a full refactorization against a partial one after a change of a few elements,
+// and checks that both give the same solution
+func (a *App) partialRefactor() error {
+	m := a.matrix
+
+	// The unfactored values are kept from this factorization on
+	m.Config.PartialFactor = true
+	if err := m.Initialize(); err != nil {
+		return err
+	}
+	if err := m.Factor(); err != nil {
+		return err
+	}
+
+	// The changes are flagged as they are added, without restamping the matrix
+	a.changeElements()
+	partialStart := time.Now()
+	if err := m.Factor(); err != nil {
+		return err
+	}
+	a.partialTime = time.Since(partialStart).Seconds()
+	a.partialColumns = m.PartialColumns
+	partial, ipartial, err := a.solveFactored()
+	if err != nil {
+		return err
+	}
+
+	m.Config.PartialFactor = false
+	if err := m.Initialize(); err != nil {
+		return err
+	}
+	a.changeElements()
+	refactorStart := time.Now()
+	if err := m.Factor(); err != nil {
+		return err
+	}
+	a.refactorTime = time.Since(refactorStart).Seconds()
+	full, ifull, err := a.solveFactored()
+	if err != nil {
+		return err
+	}
+
+	largest, difference := 0.0, 0.0
+	for i := range full {
+		largest = max(largest, math.Abs(full[i]))
+		difference = max(difference, math.Abs(partial[i]-full[i]))
+	}
+	for i := range ifull {
+		largest = max(largest, math.Abs(ifull[i]))
+		difference = max(difference, math.Abs(ipartial[i]-ifull[i]))
+	}
+	if largest > 0.0 {
+		a.partialError = difference / largest
+	}
+
+	return nil
+}
+
+// changeElements scales by 1.01 one element read from the file in each of partialChanges columns spread
+// over the pivot order
+func (a *App) changeElements() {
+	m := a.matrix
+	stride := max(m.Size/int64(a.partialChanges), 1)
+	for col := stride; col <= m.Size; col += stride {
+		for element := m.FirstInCol[col]; element != nil; element = element.NextInCol {
+			if element.InitInfo != nil {
+				m.AddToElement(element, element.InitInfo.Real*0.01, element.InitInfo.Imag*0.01)
+				break
+			}
+		}
+	}
+}
+
+// solveFactored solves the factored matrix with the rhs
+func (a *App) solveFactored() ([]float64, []float64, error) {
+	if a.matrix.Complex {
+		if a.matrix.Config.Transpose {
+			return a.matrix.SolveComplexTransposed(a.rhs, a.irhs)
+		}
+		return a.matrix.SolveComplex(a.rhs, a.irhs)
+	}
+
+	var solution []float64
+	var err error
+	if a.matrix.Config.Transpose {
+		solution, err = a.matrix.SolveTransposed(a.rhs)
+	} else {
+		solution, err = a.matrix.Solve(a.rhs)
+	}
+	return solution, nil, err
+}
+
 func (a *App) printResourceUsage() {
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
@@ -576,6 +705,10 @@ func main() {
 	printLimit := flag.Int("n", 9, "Print first n terms of solution vector")
 	iterations := flag.Int("i", 1, "Repeat build/factor/solve n times")
 	columnAsRHS := flag.Int("b", -1, "Use n'th column of matrix as b in Ax=b")
+	partialChanges := flag.Int("p", 0, "Change n elements and time partial refactorization")
+	denseThreshold := flag.Float64("d", 0.0, "Switch to dense LU when the remaining submatrix reaches density x")
+	compressed := flag.Bool("c", false, "Refactor and solve on compressed columns")
+	workers := flag.Int("w", 1, "Factor with n goroutines")
 	flag.Parse()
 
 	args := flag.Args()
@@ -589,6 +722,10 @@ func main() {
 	a.solutionOnly = *solutionOnly
 	a.printLimit = *printLimit
 	a.iterations = *iterations
+	a.partialChanges = *partialChanges
+	a.denseThreshold = *denseThreshold
+	a.compressed = *compressed
+	a.workers = *workers
 
 	if *columnAsRHS > 0 {
 		a.useColumnAsRHS = true
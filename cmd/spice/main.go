@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/edp1096/sparse"
+	"github.com/edp1096/sparse/mna"
+	"github.com/edp1096/sparse/spice"
+)
+
+/* DC operating point of a SPICE netlist, then its .tran waveforms, .ac sweep, .sens derivatives, .tf transfer function, .pz poles and zeros and .noise spectra */
+
+func main() {
+	var probes, inputs []string
+	flag.Func("probe", "AC output, as V(out), V(a,b), I(v1) or V(out)/V(in). Repeatable", func(value string) error {
+		probes = append(probes, strings.ToLower(value))
+		return nil
+	})
+	flag.Func("input", "AC input source, driven alone. Repeatable", func(value string) error {
+		inputs = append(inputs, strings.ToLower(value))
+		return nil
+	})
+	format := flag.String("format", "csv", "AC and noise output format: csv or json")
+	check := flag.Bool("check", false, "Check the .sens derivatives by finite differences")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		fmt.Println("Usage: spice [-probe expr]... [-input source]... [-format csv|json] [-check] <netlist>")
+		os.Exit(1)
+	}
+
+	netlist, err := spice.ParseFile(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to read netlist: %v", err)
+	}
+	circuit := netlist.Circuit
+	for _, warning := range netlist.Warnings {
+		log.Printf("Warning: %s", warning)
+	}
+
+	A, err := circuit.NewMatrix(false)
+	if err != nil {
+		log.Fatalf("Failed to create matrix: %v", err)
+	}
+	defer A.Destroy()
+
+	newton, err := netlist.NewtonOptions()
+	if err != nil {
+		log.Fatalf("Failed to read options: %v", err)
+	}
+	op, err := circuit.OperatingPoint(A, mna.NewContext(mna.DC), newton)
+	if err != nil {
+		log.Fatalf("Failed to solve operating point: %v", err)
+	}
+	x := op.Solution
+
+	fmt.Println(netlist.Title)
+	fmt.Printf("\nOperating point: %d nodes and branches, %d devices\n", circuit.Nodes.Size(), len(circuit.Devices))
+	fmt.Printf("Converged by %v in %d iterations\n\n", op.Strategy, op.Iterations)
+	for i := int64(1); i <= circuit.Nodes.Size(); i++ {
+		fmt.Printf("%-20s = %14.6e\n", circuit.Label(i), x[i])
+	}
+
+	options, ok, err := netlist.TransientOptions()
+	if err != nil {
+		log.Fatalf("Failed to read transient options: %v", err)
+	}
+	if ok {
+		fmt.Printf("\nTransient: %g to %g\n\n", options.Start, options.Stop)
+		options.Writer = os.Stdout
+		result, err := circuit.Transient(A, options)
+		if err != nil {
+			log.Fatalf("Failed transient analysis: %v", err)
+		}
+		fmt.Printf("\n%d points accepted, %d rejected\n", result.Accepted, result.Rejected)
+	}
+
+	ac, ok, err := netlist.ACOptions()
+	if err != nil {
+		log.Fatalf("Failed to read AC options: %v", err)
+	}
+	if ok {
+		sweep(circuit, ac, x, probes, inputs, *format)
+	}
+
+	output, sens, ok, err := netlist.SensitivityOptions()
+	if err != nil {
+		log.Fatalf("Failed to read sensitivity options: %v", err)
+	}
+	if ok {
+		sensitivity(circuit, A, x, output, sens, *check)
+	}
+
+	tfOutput, tfInput, ok, err := netlist.TransferOptions()
+	if err != nil {
+		log.Fatalf("Failed to read transfer function options: %v", err)
+	}
+	if ok {
+		ctx := mna.NewContext(mna.DC)
+		ctx.Solution = x
+		result, err := circuit.TransferFunction(A, ctx, tfInput, tfOutput)
+		if err != nil {
+			log.Fatalf("Failed transfer function: %v", err)
+		}
+		fmt.Printf("\nTransfer function of %s from %s\n\n", tfOutput, tfInput)
+		fmt.Printf("%-20s = %14.6e\n", "Gain", result.Gain)
+		fmt.Printf("%-20s = %14.6e\n", "Input resistance", result.InputResistance)
+		fmt.Printf("%-20s = %14.6e\n", "Output resistance", result.OutputResistance)
+	}
+
+	pz, ok, err := netlist.PoleZeroOptions()
+	if err != nil {
+		log.Fatalf("Failed to read pole-zero options: %v", err)
+	}
+	if ok {
+		pz.Solution = x
+		result, err := circuit.PoleZero(pz)
+		if err != nil {
+			log.Fatalf("Failed pole-zero analysis: %v", err)
+		}
+		fmt.Printf("\nPoles and zeros of %s from %s, rad/s\n\n", pz.Output, pz.Input)
+		for _, pole := range result.Poles {
+			fmt.Printf("%-20s = %14.6e %+14.6ej\n", "Pole", real(pole), imag(pole))
+		}
+		for _, zero := range result.Zeros {
+			fmt.Printf("%-20s = %14.6e %+14.6ej\n", "Zero", real(zero), imag(zero))
+		}
+	}
+
+	noiseOptions, ok, err := netlist.NoiseOptions()
+	if err != nil {
+		log.Fatalf("Failed to read noise options: %v", err)
+	}
+	if ok {
+		noise(circuit, noiseOptions, x, *format)
+	}
+}
+
+func sweep(circuit *mna.Circuit, ac mna.ACOptions, x []float64, probes, inputs []string, format string) {
+	ac.Format = outputFormat(format)
+	ac.Probes, ac.Inputs = probes, inputs
+	ac.Solution = x
+	ac.Writer = os.Stdout
+
+	fmt.Printf("\nAC: %g to %g Hz\n\n", ac.Start, ac.Stop)
+	result, err := circuit.AC(nil, ac)
+	if err != nil {
+		log.Fatalf("Failed AC analysis: %v", err)
+	}
+	fmt.Printf("\n%d frequencies, %d reorders\n", len(result.Frequencies), result.Reorders)
+}
+
+func sensitivity(circuit *mna.Circuit, A *sparse.Matrix, x []float64, output string, ac mna.ACOptions, check bool) {
+	if ac.Points == 0 {
+		ctx := mna.NewContext(mna.DC)
+		ctx.Solution = x
+		result, err := circuit.Sensitivity(A, ctx, output, check)
+		if err != nil {
+			log.Fatalf("Failed sensitivity analysis: %v", err)
+		}
+		fmt.Printf("\nDC sensitivity of %s = %.6e\n\n", output, real(result.Value))
+		printSensitivity(result, check)
+		return
+	}
+
+	frequencies, err := ac.Frequencies()
+	if err != nil {
+		log.Fatalf("Failed to read sensitivity sweep: %v", err)
+	}
+	B, err := circuit.NewMatrix(true)
+	if err != nil {
+		log.Fatalf("Failed to create matrix: %v", err)
+	}
+	defer B.Destroy()
+
+	ctx := mna.NewContext(mna.AC)
+	ctx.Solution = x
+	for _, f := range frequencies {
+		ctx.S = complex(0.0, 2.0*math.Pi*f)
+		result, err := circuit.Sensitivity(B, ctx, output, check)
+		if err != nil {
+			log.Fatalf("Failed sensitivity analysis at %g Hz: %v", f, err)
+		}
+		fmt.Printf("\nAC sensitivity of %s at %g Hz = %.6e%+.6ej\n\n", output, f, real(result.Value), imag(result.Value))
+		printSensitivity(result, check)
+	}
+}
+
+func printSensitivity(result *mna.SensitivityResult, check bool) {
+	for i, name := range result.Parameters {
+		value := result.Values[i]
+		fmt.Printf("%-20s %14.6e %14.6e", name, real(value), imag(value))
+		if check {
+			difference := result.Differences[i]
+			fmt.Printf("   difference %14.6e %14.6e", real(difference), imag(difference))
+		}
+		fmt.Println()
+	}
+	if check {
+		fmt.Printf("Largest relative error %.3e\n", result.MaxError(1e-12))
+	}
+}
+
+func noise(circuit *mna.Circuit, options mna.NoiseOptions, x []float64, format string) {
+	options.Format = outputFormat(format)
+	options.Solution = x
+	options.Writer = os.Stdout
+
+	fmt.Printf("\nNoise of %s from %s: %g to %g Hz\n\n", options.Output, options.Input, options.Start, options.Stop)
+	result, err := circuit.Noise(nil, options)
+	if err != nil {
+		log.Fatalf("Failed noise analysis: %v", err)
+	}
+
+	fmt.Printf("\nTotal output noise = %.6e\n", result.OutputTotal)
+	fmt.Printf("Total input noise  = %.6e\n\n", result.InputTotal)
+	for i, name := range result.Devices {
+		fmt.Printf("%-20s %14.6e\n", name, result.DeviceTotals[i])
+	}
+}
+
+func outputFormat(format string) mna.Format {
+	switch format {
+	case "csv":
+		return mna.CSV
+	case "json":
+		return mna.JSON
+	}
+	log.Fatalf("Unknown format: %s", format)
+	return mna.CSV
+}
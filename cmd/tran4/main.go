@@ -167,6 +167,12 @@ func main() {
 	}
 	defer A.Destroy()
 
+	var resistor, source, inductor sparse.Template
+	A.GetAdmittance(1, 2, &resistor)
+	A.GetOnes(1, 0, 3, &source)
+	A.GetOnes(2, 0, 4, &inductor)
+	inductance := A.GetElement(4, 4)
+
 	t := StartTime
 	endTime := EndTime
 	timestep := TimeStep
@@ -192,19 +198,14 @@ func main() {
 		A.Clear()
 
 		// Resistor
-		A.GetElement(1, 1).Real += G
-		A.GetElement(2, 2).Real += G
-		A.GetElement(1, 2).Real += -G
-		A.GetElement(2, 1).Real += -G
+		resistor.AddRealQuad(G)
 
 		// Voltage source
-		A.GetElement(1, 3).Real += 1.0
-		A.GetElement(3, 1).Real += 1.0
+		source.AddRealQuad(1.0)
 
 		// Inductor
-		A.GetElement(2, 4).Real += 1.0
-		A.GetElement(4, 2).Real += 1.0
-		A.GetElement(4, 4).Real += -coeffs[0] * L
+		inductor.AddRealQuad(1.0)
+		inductance.Real += -coeffs[0] * L
 
 		vin := Vpeak * math.Sin(2.0*math.Pi*freq*t)
 
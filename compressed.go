@@ -0,0 +1,391 @@
+package sparse
+
+import "math"
+
+// Compressed column refactorization. With Config.Compressed the pivot order and fill pattern of the
+// last OrderAndFactor are frozen into arrays, and Factor runs a left-looking LU on them as KLU does:
+// each column is scattered into a work vector, reduced by the columns of L selected by its pattern
+// of U, and gathered back. Factor reads the unfactored values from OriginalValues, or from the
+// elements gathered once, and works on the arrays only, as Solve does. The factors are written back
+// into the elements by syncFactors, when a routine that reads them there is called.
+//
+// The arithmetic is the one of the linked Factor and Solve, in the same order, so the results are
+// bit-for-bit identical to the linked path on the same pivot order. Not used with a dense trailing
+// submatrix, whose pivots change on every factorization.
+
+// CompressedColumns holds the frozen structure and the factors in compressed column form
+type CompressedColumns struct {
+	ColStart      []int           // Start of each column [1...Size+1]
+	DiagPos       []int           // Position of the diagonal of each column [1...Size]
+	RowIndex      []int64         // Internal row of each entry
+	Values        []float64       // Real factors, stored as in the elements
+	ComplexValues []ComplexNumber // Complex factors
+	URowStart     []int           // Start of each row of U in URowPos [1...Size+1]
+	URowPos       []int           // Positions of the entries of U by rows
+	UColIndex     []int64         // Internal column of each entry of URowPos
+	Elements      []*Element      // Element of each entry
+
+	source      []ComplexNumber // Unfactored values gathered from the elements
+	stale       bool            // Factors not written back into the elements yet
+	work        []float64
+	complexWork []ComplexNumber
+}
+
+// buildCompressed freezes the factored structure, copying the factors from the elements
+func (m *Matrix) buildCompressed() {
+	if !m.Config.Compressed || m.DenseStep > 0 {
+		m.CSC = nil
+		return
+	}
+
+	size := m.Size
+	c := &CompressedColumns{
+		ColStart:  make([]int, size+2),
+		DiagPos:   make([]int, size+1),
+		RowIndex:  make([]int64, 0, m.Elements),
+		Elements:  make([]*Element, 0, m.Elements),
+		URowStart: make([]int, size+2),
+	}
+
+	for col := int64(1); col <= size; col++ {
+		c.ColStart[col] = len(c.RowIndex)
+		for element := m.FirstInCol[col]; element != nil; element = element.NextInCol {
+			if element.Row == col {
+				c.DiagPos[col] = len(c.RowIndex)
+			}
+			c.RowIndex = append(c.RowIndex, element.Row)
+			c.Elements = append(c.Elements, element)
+		}
+	}
+	c.ColStart[size+1] = len(c.RowIndex)
+
+	// Rows of U, in column order
+	for col := int64(1); col <= size; col++ {
+		for p := c.ColStart[col]; p < c.DiagPos[col]; p++ {
+			c.URowStart[c.RowIndex[p]+1]++
+		}
+	}
+	c.URowStart[1] = 0
+	for row := int64(1); row <= size; row++ {
+		c.URowStart[row+1] += c.URowStart[row]
+	}
+	c.URowPos = make([]int, c.URowStart[size+1])
+	c.UColIndex = make([]int64, c.URowStart[size+1])
+	next := make([]int, size+1)
+	copy(next, c.URowStart[:size+1])
+	for col := int64(1); col <= size; col++ {
+		for p := c.ColStart[col]; p < c.DiagPos[col]; p++ {
+			row := c.RowIndex[p]
+			c.URowPos[next[row]] = p
+			c.UColIndex[next[row]] = col
+			next[row]++
+		}
+	}
+
+	if m.Complex {
+		c.ComplexValues = make([]ComplexNumber, len(c.Elements))
+		c.complexWork = make([]ComplexNumber, size+1)
+		for p, element := range c.Elements {
+			c.ComplexValues[p] = ComplexNumber{Real: element.Real, Imag: element.Imag}
+		}
+	} else {
+		c.Values = make([]float64, len(c.Elements))
+		c.work = make([]float64, size+1)
+		for p, element := range c.Elements {
+			c.Values[p] = element.Real
+		}
+	}
+
+	m.CSC = c
+}
+
+// factorCompressed refactors on the compressed columns, only the columns reachable from the
+// changed ones when PartialFactor kept the unfactored values.
+func (m *Matrix) factorCompressed() error {
+	c := m.CSC
+	size := m.Size
+
+	partial := m.Config.PartialFactor && m.OriginalValues != nil
+	if !partial && (m.Config.PartialFactor || m.Config.MonitorPivots) {
+		m.saveOriginalValues()
+	}
+	if m.Config.MonitorPivots {
+		m.startMonitor()
+	}
+
+	// Entries of OriginalValues are in the order of the compressed columns
+	source := m.OriginalValues
+	if source == nil {
+		source = c.gather()
+	}
+
+	changed := m.ChangedCols
+	if partial && !m.partialWorthwhile(m.markReachable()) {
+		clear(changed)
+		partial = false
+	}
+
+	m.PartialColumns = 0
+	if !partial && m.FactorLevels != nil {
+		if err := m.factorCompressedLevels(source); err != nil {
+			return err
+		}
+		c.stale = true
+		m.Factored = true
+		return nil
+	}
+
+	for step := int64(1); step <= size; step++ {
+		if partial && !changed[step] {
+			continue
+		}
+
+		var err error
+		if m.Complex {
+			err = m.factorCompressedComplexColumn(step, source, c.complexWork)
+		} else {
+			err = m.factorCompressedRealColumn(step, source, c.work)
+		}
+		if err != nil {
+			return err
+		}
+
+		if partial {
+			changed[step] = false
+			m.PartialColumns++
+		}
+	}
+
+	c.stale = true
+	m.Factored = true
+	return nil
+}
+
+// gather returns the values of the elements in the order of the compressed columns
+func (c *CompressedColumns) gather() []ComplexNumber {
+	if len(c.source) != len(c.Elements) {
+		c.source = make([]ComplexNumber, len(c.Elements))
+	}
+	for p, element := range c.Elements {
+		c.source[p] = ComplexNumber{Real: element.Real, Imag: element.Imag}
+	}
+	return c.source
+}
+
+// syncFactors writes the factors of the compressed columns back into the elements, once after each
+// Factor. Called by the routines that read the factors from the elements.
+func (m *Matrix) syncFactors() {
+	c := m.CSC
+	if c == nil || !c.stale || !m.Factored {
+		return
+	}
+	c.stale = false
+
+	if m.Complex {
+		for p, element := range c.Elements {
+			element.Real = c.ComplexValues[p].Real
+			element.Imag = c.ComplexValues[p].Imag
+		}
+		return
+	}
+	for p, element := range c.Elements {
+		element.Real = c.Values[p]
+	}
+}
+
+// factorCompressedRealColumn computes column step from the unfactored values in source. x is scratch of length Size+1.
+func (m *Matrix) factorCompressedRealColumn(step int64, source []ComplexNumber, x []float64) error {
+	c := m.CSC
+	values := c.Values
+	rows := c.RowIndex
+	start, diag, end := c.ColStart[step], c.DiagPos[step], c.ColStart[step+1]
+
+	for p := start; p < end; p++ {
+		x[rows[p]] = source[p].Real
+	}
+
+	for p := start; p < diag; p++ {
+		k := rows[p]
+		temp := x[k] * values[c.DiagPos[k]]
+		values[p] = temp
+		for q := c.DiagPos[k] + 1; q < c.ColStart[k+1]; q++ {
+			x[rows[q]] -= temp * values[q]
+		}
+	}
+
+	largestInCol := 0.0
+	for p := diag + 1; p < end; p++ {
+		values[p] = x[rows[p]]
+		largestInCol = max(largestInCol, math.Abs(values[p]))
+	}
+
+	pivot := x[step]
+	if m.Config.MonitorPivots && !m.pivotWithinLimits(math.Abs(pivot), largestInCol) {
+		return errUnstablePivot
+	}
+	if pivot == 0.0 {
+		return zeroPivotError(step)
+	}
+	values[diag] = 1.0 / pivot
+
+	return nil
+}
+
+// factorCompressedComplexColumn is the complex version of factorCompressedRealColumn
+func (m *Matrix) factorCompressedComplexColumn(step int64, source []ComplexNumber, x []ComplexNumber) error {
+	c := m.CSC
+	values := c.ComplexValues
+	rows := c.RowIndex
+	start, diag, end := c.ColStart[step], c.DiagPos[step], c.ColStart[step+1]
+
+	for p := start; p < end; p++ {
+		x[rows[p]] = source[p]
+	}
+
+	for p := start; p < diag; p++ {
+		k := rows[p]
+		x[k].multAssign(values[c.DiagPos[k]])
+		values[p] = x[k]
+		for q := c.DiagPos[k] + 1; q < c.ColStart[k+1]; q++ {
+			x[rows[q]].multSubtAssign(x[k], values[q])
+		}
+	}
+
+	largestInCol := 0.0
+	for p := diag + 1; p < end; p++ {
+		values[p] = x[rows[p]]
+		largestInCol = max(largestInCol, complex1Norm(values[p].Real, values[p].Imag))
+	}
+
+	pivot := x[step]
+	if m.Config.MonitorPivots && !m.pivotWithinLimits(complex1Norm(pivot.Real, pivot.Imag), largestInCol) {
+		return errUnstablePivot
+	}
+	if pivot.Real*pivot.Real+pivot.Imag*pivot.Imag == 0.0 {
+		return zeroPivotError(step)
+	}
+	pivot.reciprocal()
+	values[diag] = pivot
+
+	return nil
+}
+
+// solve solves LUx = b in place on the internally ordered intermediate vector
+func (c *CompressedColumns) solve(intermediate []float64, size int64) {
+	values := c.Values
+
+	// Forward elimination - Solves Lc = b
+	for i := int64(1); i <= size; i++ {
+		temp := intermediate[i]
+		if temp != 0.0 {
+			diag := c.DiagPos[i]
+			temp *= values[diag]
+			intermediate[i] = temp
+
+			for p := diag + 1; p < c.ColStart[i+1]; p++ {
+				intermediate[c.RowIndex[p]] -= temp * values[p]
+			}
+		}
+	}
+
+	// Backward Substitution - Solves Ux = c
+	for i := size; i > 0; i-- {
+		temp := intermediate[i]
+		for q := c.URowStart[i]; q < c.URowStart[i+1]; q++ {
+			temp -= values[c.URowPos[q]] * intermediate[c.UColIndex[q]]
+		}
+		intermediate[i] = temp
+	}
+}
+
+// solveTransposed solves (LU)^T x = b in place on the internally ordered intermediate vector
+func (c *CompressedColumns) solveTransposed(intermediate []float64, size int64) {
+	values := c.Values
+
+	// Forward elimination
+	for i := int64(1); i <= size; i++ {
+		temp := intermediate[i]
+		if temp != 0.0 {
+			for q := c.URowStart[i]; q < c.URowStart[i+1]; q++ {
+				intermediate[c.UColIndex[q]] -= temp * values[c.URowPos[q]]
+			}
+		}
+	}
+
+	// Backward Substitution
+	for i := size; i > 0; i-- {
+		diag := c.DiagPos[i]
+		temp := intermediate[i]
+		for p := diag + 1; p < c.ColStart[i+1]; p++ {
+			temp -= values[p] * intermediate[c.RowIndex[p]]
+		}
+		intermediate[i] = temp * values[diag]
+	}
+}
+
+// solveComplex is the complex version of solve on the interleaved intermediate vector
+func (c *CompressedColumns) solveComplex(intermediate []float64, size int64) {
+	values := c.ComplexValues
+
+	// Forward substitution
+	for i := int64(1); i <= size; i++ {
+		temp := ComplexNumber{Real: intermediate[i*2], Imag: intermediate[i*2+1]}
+		if temp.Real != 0.0 || temp.Imag != 0.0 {
+			diag := c.DiagPos[i]
+			temp.multAssign(values[diag])
+			intermediate[i*2] = temp.Real
+			intermediate[i*2+1] = temp.Imag
+
+			for p := diag + 1; p < c.ColStart[i+1]; p++ {
+				row := c.RowIndex[p]
+				interm := ComplexNumber{Real: intermediate[row*2], Imag: intermediate[row*2+1]}
+				interm.multSubtAssign(temp, values[p])
+				intermediate[row*2] = interm.Real
+				intermediate[row*2+1] = interm.Imag
+			}
+		}
+	}
+
+	// Backward substitution
+	for i := size; i > 0; i-- {
+		temp := ComplexNumber{Real: intermediate[i*2], Imag: intermediate[i*2+1]}
+		for q := c.URowStart[i]; q < c.URowStart[i+1]; q++ {
+			col := c.UColIndex[q]
+			temp.multSubtAssign(values[c.URowPos[q]], ComplexNumber{Real: intermediate[col*2], Imag: intermediate[col*2+1]})
+		}
+		intermediate[i*2] = temp.Real
+		intermediate[i*2+1] = temp.Imag
+	}
+}
+
+// solveComplexTransposed is the complex version of solveTransposed on the interleaved intermediate vector
+func (c *CompressedColumns) solveComplexTransposed(intermediate []float64, size int64) {
+	values := c.ComplexValues
+
+	// Forward elimination
+	for i := int64(1); i <= size; i++ {
+		temp := ComplexNumber{Real: intermediate[i*2], Imag: intermediate[i*2+1]}
+		if temp.Real != 0.0 || temp.Imag != 0.0 {
+			for q := c.URowStart[i]; q < c.URowStart[i+1]; q++ {
+				col := c.UColIndex[q]
+				interm := ComplexNumber{Real: intermediate[col*2], Imag: intermediate[col*2+1]}
+				interm.multSubtAssign(temp, values[c.URowPos[q]])
+				intermediate[col*2] = interm.Real
+				intermediate[col*2+1] = interm.Imag
+			}
+		}
+	}
+
+	// Backward substitution
+	for i := size; i > 0; i-- {
+		diag := c.DiagPos[i]
+		temp := ComplexNumber{Real: intermediate[i*2], Imag: intermediate[i*2+1]}
+		for p := diag + 1; p < c.ColStart[i+1]; p++ {
+			row := c.RowIndex[p]
+			temp.multSubtAssign(ComplexNumber{Real: intermediate[row*2], Imag: intermediate[row*2+1]}, values[p])
+		}
+		temp.multAssign(values[diag])
+		intermediate[i*2] = temp.Real
+		intermediate[i*2+1] = temp.Imag
+	}
+}
@@ -0,0 +1,85 @@
+package sparse
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCompressedMatchesLinked(t *testing.T) {
+	const n = 200
+	configs := []Configuration{{}, {MonitorPivots: true}, {PartialFactor: true, MonitorPivots: true}, {Workers: 2}}
+	for _, isComplex := range []bool{false, true} {
+		for _, config := range configs {
+			config.Real, config.Complex = true, isComplex
+			deltas := testDeltas(n, 3, isComplex, 5)
+			linked, linkedElements := testMatrix(t, n, deltas, config)
+			config.Compressed = true
+			m, elements := testMatrix(t, n, deltas, config)
+			for _, matrix := range []*Matrix{linked, m} {
+				if err := matrix.Factor(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if m.CSC == nil {
+				t.Fatal("no compressed columns")
+			}
+			b := testRHS(n)
+
+			changed := deltas
+			for round := 0; round < 3; round++ {
+				changed = slices.Clone(changed)
+				for _, i := range testLastPivots(m, elements, 2) {
+					changed[i].Real += 0.5
+				}
+				changed[(round*37)%len(changed)].Imag += 0.25
+				testStamp(linked, linkedElements, changed)
+				testStamp(m, elements, changed)
+
+				for _, matrix := range []*Matrix{linked, m} {
+					var err error
+					if isComplex {
+						err = matrix.FactorComplex()
+					} else {
+						err = matrix.Factor()
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				// Factor leaves the elements alone
+				if !config.PartialFactor {
+					for i, element := range elements {
+						if element.Real != changed[i].Real {
+							t.Fatalf("element (%d,%d) written by Factor", element.Row, element.Col)
+						}
+					}
+				}
+
+				for _, transposed := range []bool{false, true} {
+					if !slices.Equal(testSolution(t, m, b, transposed), testSolution(t, linked, b, transposed)) {
+						t.Fatalf("complex %v %+v round %d: compressed solution differs", isComplex, config, round)
+					}
+				}
+			}
+
+			// Routines reading the factors from the elements get them written back
+			logAbs, sign, err := m.LogDeterminant()
+			if err != nil {
+				t.Fatal(err)
+			}
+			linkedLogAbs, linkedSign, err := linked.LogDeterminant()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if logAbs != linkedLogAbs || sign != linkedSign {
+				t.Fatalf("complex %v %+v: determinant differs", isComplex, config)
+			}
+			for i, element := range elements {
+				if element.Real != linkedElements[i].Real || (isComplex && element.Imag != linkedElements[i].Imag) {
+					t.Fatalf("complex %v %+v: factor (%d,%d) differs", isComplex, config, element.Row, element.Col)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package sparse
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Dense factorization of the trailing submatrix. Once fill-in makes the remaining submatrix
+// nearly dense, chasing NextInCol pointers costs more than the arithmetic, so the submatrix from
+// DenseStep on is packed into a column-major array and factored by a blocked LU with partial pivoting.
+// The row interchanges are applied to the linked structure and the factors are written back in the
+// same form as RealRowColElimination leaves them, so Solve and the other routines keep working.
+// Factor keeps this row order, as it keeps the sparse pivots.
+
+const (
+	DENSE_MIN_SIZE   int64 = 32  // Smallest trailing submatrix factored as dense
+	DENSE_BLOCK_SIZE int   = 64  // Columns per panel of the blocked LU
+	DENSE_ROW_BLOCK  int   = 256 // Rows per tile of the trailing update
+)
+
+// activeElements returns the number of elements of the active submatrix from step on, the start of the
+// count kept by OrderAndFactor for denseSwitchReached. Uses the column counts kept by the ordering.
+func (m *Matrix) activeElements(step int64) int64 {
+	active := int64(0)
+	for i := step; i <= m.Size; i++ {
+		active += m.MarkowitzCol[i] + 1
+	}
+	return active
+}
+
+// eliminatedElements returns the number of elements leaving the active submatrix with pivot, the
+// elements of its row and column
+func eliminatedElements(pivot *Element) int64 {
+	count := int64(1)
+	for element := pivot.NextInRow; element != nil; element = element.NextInRow {
+		count++
+	}
+	for element := pivot.NextInCol; element != nil; element = element.NextInCol {
+		count++
+	}
+	return count
+}
+
+// denseSwitchReached reports whether the active submatrix at step, holding active elements, is dense
+// enough for dense LU
+func (m *Matrix) denseSwitchReached(step, active int64) bool {
+	if m.Config.DenseThreshold <= 0.0 {
+		return false
+	}
+
+	remaining := m.Size - step + 1
+	if remaining < DENSE_MIN_SIZE {
+		return false
+	}
+
+	return float64(active) >= m.Config.DenseThreshold*float64(remaining*remaining)
+}
+
+// factorDense factors the submatrix from step on, which must hold the values left by eliminating
+// the pivots before step, and makes step the DenseStep. With reuse the rows keep the order found by the
+// last dense factorization, and stable, when not nil, accepts a pivot given the largest magnitude below it.
+func (m *Matrix) factorDense(step int64, reuse bool, stable func(pivot, largest float64) bool) error {
+	size := m.Size
+	n := int(size - step + 1)
+
+	if m.Config.Annotate > 0 && !reuse {
+		fmt.Printf("Step = %d   Dense LU of the remaining %d x %d submatrix\n\n", step, n, n)
+	}
+
+	if !reuse {
+		m.fillDenseBlock(step)
+	}
+
+	var ipiv []int
+	if !reuse {
+		ipiv = make([]int, n)
+	}
+	failed := -1
+	pivotMag := 0.0
+	if m.Complex {
+		a := make([]complex128, n*n)
+		m.forDenseBlock(step, func(element *Element, i, j int) {
+			a[j*n+i] = complex(element.Real, element.Imag)
+		})
+		magnitude := func(x complex128) float64 { return complex1Norm(real(x), imag(x)) }
+		failed = factorDenseLU(a, n, ipiv, magnitude, stable)
+		if failed < 0 {
+			m.permuteDenseRows(step, ipiv)
+			m.forDenseBlock(step, func(element *Element, i, j int) {
+				value := denseFactorValue(a, n, i, j)
+				element.Real = real(value)
+				element.Imag = imag(value)
+			})
+		} else {
+			pivotMag = magnitude(a[failed*n+failed])
+		}
+	} else {
+		a := make([]float64, n*n)
+		m.forDenseBlock(step, func(element *Element, i, j int) {
+			a[j*n+i] = element.Real
+		})
+		failed = factorDenseLU(a, n, ipiv, math.Abs, stable)
+		if failed < 0 {
+			m.permuteDenseRows(step, ipiv)
+			m.forDenseBlock(step, func(element *Element, i, j int) {
+				element.Real = denseFactorValue(a, n, i, j)
+			})
+		} else {
+			pivotMag = math.Abs(a[failed*n+failed])
+		}
+	}
+
+	switch {
+	case failed < 0:
+		m.DenseStep = step
+		return nil
+	case reuse && pivotMag != 0.0:
+		return errUnstablePivot
+	case reuse:
+		return zeroPivotError(step + int64(failed))
+	}
+
+	m.SingularRow = step + int64(failed)
+	m.SingularCol = step + int64(failed)
+	return fmt.Errorf("matrix is singular at step %d", m.SingularRow)
+}
+
+// lastSparseStep returns the last step factored column by column
+func (m *Matrix) lastSparseStep() int64 {
+	if m.DenseStep > 0 {
+		return m.DenseStep - 1
+	}
+	return m.Size
+}
+
+// factorDenseBlock updates the columns from DenseStep on with the pivots before it and factors them as dense
+// in the row order of OrderAndFactor, checking the pivots with MonitorPivots.
+// dest is the scratch of factorRealColumn or factorComplexColumn.
+func (m *Matrix) factorDenseBlock(dest []*float64, complexDest []*Element) error {
+	for col := m.DenseStep; col <= m.Size; col++ {
+		if m.Complex {
+			m.updateComplexColumn(col, m.DenseStep, complexDest)
+		} else {
+			m.updateRealColumn(col, m.DenseStep, dest)
+		}
+	}
+
+	var stable func(pivot, largest float64) bool
+	if m.Config.MonitorPivots {
+		stable = m.pivotWithinLimits
+	}
+	return m.factorDense(m.DenseStep, true, stable)
+}
+
+// fillDenseBlock creates the missing elements of the submatrix from step on as fill-ins. The ordering is
+// over, so they are linked directly, leaving the Markowitz counts alone.
+func (m *Matrix) fillDenseBlock(step int64) {
+	size := m.Size
+
+	rowTail := make([]**Element, size-step+1)
+	for i := step; i <= size; i++ {
+		link := &m.FirstInRow[i]
+		for *link != nil && (*link).Col < step {
+			link = &(*link).NextInRow
+		}
+		rowTail[i-step] = link
+	}
+
+	for j := step; j <= size; j++ {
+		link := &m.FirstInCol[j]
+		for *link != nil && (*link).Row < step {
+			link = &(*link).NextInCol
+		}
+
+		for i := step; i <= size; i++ {
+			element := *link
+			if element == nil || element.Row != i {
+				element = &Element{Row: i, Col: j, NextInRow: *rowTail[i-step], NextInCol: element}
+				*link = element
+				*rowTail[i-step] = element
+				if i == j {
+					m.Diags[i] = element
+				}
+				m.Elements++
+				m.Fillins++
+			}
+			link = &element.NextInCol
+			rowTail[i-step] = &element.NextInRow
+		}
+	}
+}
+
+// forDenseBlock calls fn for every element of the dense submatrix from step on with its block row and column
+func (m *Matrix) forDenseBlock(step int64, fn func(element *Element, i, j int)) {
+	for j := step; j <= m.Size; j++ {
+		element := m.FirstInCol[j]
+		for element != nil && element.Row < step {
+			element = element.NextInCol
+		}
+		for ; element != nil; element = element.NextInCol {
+			fn(element, int(element.Row-step), int(j-step))
+		}
+	}
+}
+
+// permuteDenseRows applies the row interchanges of the dense LU to the linked structure, nil ipiv
+// for none. Elements move with their rows, so handles from GetElement stay valid. Called by
+// OrderAndFactor only, before the unfactored values are saved.
+func (m *Matrix) permuteDenseRows(step int64, ipiv []int) {
+	if !hasRowInterchange(ipiv) {
+		return
+	}
+	n := len(ipiv)
+
+	perm := make([]int, n) // new block row t holds old block row perm[t]
+	for t := range perm {
+		perm[t] = t
+	}
+	interchanges := false
+	for k, p := range ipiv {
+		if p != k {
+			perm[k], perm[p] = perm[p], perm[k]
+			interchanges = !interchanges
+		}
+	}
+	newRow := make([]int64, n)
+	for t, old := range perm {
+		newRow[old] = step + int64(t)
+	}
+
+	var tail []*Element
+	for col := int64(1); col <= m.Size; col++ {
+		link := &m.FirstInCol[col]
+		for *link != nil && (*link).Row < step {
+			link = &(*link).NextInCol
+		}
+		if *link == nil {
+			continue
+		}
+
+		tail = tail[:0]
+		for element := *link; element != nil; element = element.NextInCol {
+			element.Row = newRow[element.Row-step]
+			tail = append(tail, element)
+		}
+		slices.SortFunc(tail, func(a, b *Element) int { return int(a.Row - b.Row) })
+
+		for k := 0; k < len(tail)-1; k++ {
+			tail[k].NextInCol = tail[k+1]
+		}
+		tail[len(tail)-1].NextInCol = nil
+		*link = tail[0]
+	}
+
+	oldRowMap := slices.Clone(m.IntToExtRowMap[step:])
+	for t, old := range perm {
+		row := step + int64(t)
+		m.IntToExtRowMap[row] = oldRowMap[old]
+		if m.Config.Translate {
+			m.ExtToIntRowMap[m.IntToExtRowMap[row]] = row
+		}
+	}
+
+	m.LinkRows()
+	for i := step; i <= m.Size; i++ {
+		m.Diags[i] = m.findDiag(i)
+	}
+	m.SolveLevels = nil
+
+	if interchanges {
+		m.NumberOfInterchangesIsOdd = !m.NumberOfInterchangesIsOdd
+	}
+}
+
+func hasRowInterchange(ipiv []int) bool {
+	for k, p := range ipiv {
+		if p != k {
+			return true
+		}
+	}
+	return false
+}
+
+// updateRealColumn subtracts from column col the contributions of the pivots before limit
+func (m *Matrix) updateRealColumn(col, limit int64, dest []*float64) {
+	for element := m.FirstInCol[col]; element != nil; element = element.NextInCol {
+		dest[element.Row] = &element.Real
+	}
+
+	for column := m.FirstInCol[col]; column != nil && column.Row < limit; column = column.NextInCol {
+		diag := m.Diags[column.Row]
+		if diag == nil {
+			continue
+		}
+
+		pColReal := *dest[column.Row] * diag.Real
+		*dest[column.Row] = pColReal
+		for element := diag.NextInCol; element != nil; element = element.NextInCol {
+			*dest[element.Row] -= pColReal * element.Real
+		}
+	}
+}
+
+// updateComplexColumn is the complex version of updateRealColumn
+func (m *Matrix) updateComplexColumn(col, limit int64, dest []*Element) {
+	for element := m.FirstInCol[col]; element != nil; element = element.NextInCol {
+		dest[element.Row] = element
+	}
+
+	for column := m.FirstInCol[col]; column != nil && column.Row < limit; column = column.NextInCol {
+		element := m.Diags[column.Row]
+		m.complexMultAssign(dest[column.Row], element)
+
+		for element = element.NextInCol; element != nil; element = element.NextInCol {
+			m.complexMultSubtAssign(dest[element.Row], dest[column.Row], element)
+		}
+	}
+}
+
+// denseFactorValue converts the LU factors of the packed array, unit lower triangular L and U,
+// to the stored form: L scaled by the pivots, reciprocal pivots on the diagonal and unit upper triangular U.
+func denseFactorValue[T float64 | complex128](a []T, n, i, j int) T {
+	switch {
+	case i > j:
+		return a[j*n+i] * a[j*n+j]
+	case i == j:
+		return 1 / a[j*n+j]
+	default:
+		return a[j*n+i] / a[i*n+i]
+	}
+}
+
+// factorDenseLU factors the n x n column-major array a in place with partial pivoting, row k being
+// interchanged with row ipiv[k]. With ipiv nil the rows keep their order, and stable, when not nil,
+// accepts each pivot given the largest magnitude below it. Columns are factored in panels and the
+// trailing submatrix is updated in tiles that stay in cache. Returns the column of a zero or
+// rejected pivot, or -1.
+func factorDenseLU[T float64 | complex128](a []T, n int, ipiv []int, magnitude func(T) float64, stable func(pivot, largest float64) bool) int {
+	for k0 := 0; k0 < n; k0 += DENSE_BLOCK_SIZE {
+		k1 := min(k0+DENSE_BLOCK_SIZE, n)
+
+		// Panel factorization
+		for k := k0; k < k1; k++ {
+			colK := a[k*n : (k+1)*n]
+
+			switch {
+			case ipiv != nil:
+				p := k
+				largest := magnitude(colK[k])
+				for i := k + 1; i < n; i++ {
+					if mag := magnitude(colK[i]); mag > largest {
+						largest = mag
+						p = i
+					}
+				}
+				ipiv[k] = p
+
+				if p != k {
+					for j := 0; j < n; j++ {
+						a[j*n+k], a[j*n+p] = a[j*n+p], a[j*n+k]
+					}
+				}
+			case stable != nil:
+				largest := 0.0
+				for i := k + 1; i < n; i++ {
+					largest = max(largest, magnitude(colK[i]))
+				}
+				if !stable(magnitude(colK[k]), largest) {
+					return k
+				}
+			}
+			if colK[k] == 0 {
+				return k
+			}
+
+			reciprocal := 1 / colK[k]
+			for i := k + 1; i < n; i++ {
+				colK[i] *= reciprocal
+			}
+
+			for j := k + 1; j < k1; j++ {
+				colJ := a[j*n : (j+1)*n]
+				if u := colJ[k]; u != 0 {
+					for i := k + 1; i < n; i++ {
+						colJ[i] -= colK[i] * u
+					}
+				}
+			}
+		}
+
+		// U12 = inv(L11) A12
+		for j := k1; j < n; j++ {
+			colJ := a[j*n : (j+1)*n]
+			for k := k0; k < k1; k++ {
+				if u := colJ[k]; u != 0 {
+					colK := a[k*n : (k+1)*n]
+					for i := k + 1; i < k1; i++ {
+						colJ[i] -= colK[i] * u
+					}
+				}
+			}
+		}
+
+		// A22 -= L21 U12
+		for i0 := k1; i0 < n; i0 += DENSE_ROW_BLOCK {
+			i1 := min(i0+DENSE_ROW_BLOCK, n)
+			for j := k1; j < n; j++ {
+				colJ := a[j*n : (j+1)*n]
+				for k := k0; k < k1; k++ {
+					if u := colJ[k]; u != 0 {
+						colK := a[k*n : (k+1)*n]
+						for i := i0; i < i1; i++ {
+							colJ[i] -= colK[i] * u
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,86 @@
+package sparse
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// testScale returns deltas with every value scaled by a random factor in [0.5, 1.5)
+func testScale(deltas []ElementDelta, seed int64) []ElementDelta {
+	rnd := rand.New(rand.NewSource(seed))
+	scaled := append([]ElementDelta(nil), deltas...)
+	for i := range scaled {
+		factor := 0.5 + rnd.Float64()
+		scaled[i].Real *= factor
+		scaled[i].Imag *= factor
+	}
+	return scaled
+}
+
+func TestDenseBlock(t *testing.T) {
+	const n = 300
+	for _, isComplex := range []bool{false, true} {
+		for _, monitor := range []bool{false, true} {
+			deltas := testDeltas(n, 6, isComplex, 8)
+			config := Configuration{Real: true, Complex: isComplex, DenseThreshold: 0.2, MonitorPivots: monitor}
+			m, elements := testMatrix(t, n, deltas, config)
+			if err := m.Factor(); err != nil {
+				t.Fatal(err)
+			}
+			if m.DenseStep == 0 {
+				t.Fatal("no dense block")
+			}
+			b := testRHS(n)
+			testClose(t, "dense", testSolution(t, m, b, false), testReference(t, n, deltas, isComplex, b, false), 1e-9)
+
+			// Refactored in the row order of the first factorization
+			rows := slices.Clone(m.IntToExtRowMap)
+			elementCount, fillins := m.Elements, m.Fillins
+			for seed := int64(1); seed <= 3; seed++ {
+				changed := testScale(deltas, seed)
+				testStamp(m, elements, changed)
+				if err := m.Factor(); err != nil {
+					t.Fatal(err)
+				}
+				if !m.LastFactorReordered && !slices.Equal(rows, m.IntToExtRowMap) {
+					t.Fatal("rows permuted by Factor")
+				}
+				if m.Elements != elementCount || m.Fillins != fillins {
+					t.Fatal("elements created by Factor")
+				}
+				for _, transposed := range []bool{false, true} {
+					testClose(t, "dense refactor", testSolution(t, m, b, transposed), testReference(t, n, changed, isComplex, b, transposed), 1e-9)
+				}
+			}
+		}
+	}
+}
+
+func TestDenseBlockUnstable(t *testing.T) {
+	const n = 300
+	deltas := testDeltas(n, 6, false, 9)
+	m, elements := testMatrix(t, n, deltas, Configuration{Real: true, DenseThreshold: 0.2, MonitorPivots: true, PartialFactor: true})
+	if err := m.Factor(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The row of the first pivot of the block scaled down makes the pivot tiny relative to the
+	// elements below it, so it is rejected and the matrix reordered
+	changed := append([]ElementDelta(nil), deltas...)
+	row := m.IntToExtRowMap[m.DenseStep]
+	for i := range changed {
+		if changed[i].Row == row {
+			changed[i].Real *= 1e-8
+		}
+	}
+	testStamp(m, elements, changed)
+	if err := m.Factor(); err != nil {
+		t.Fatal(err)
+	}
+	if !m.LastFactorReordered {
+		t.Fatal("unstable dense pivot accepted")
+	}
+	b := testRHS(n)
+	testClose(t, "dense fallback", testSolution(t, m, b, false), testReference(t, n, changed, false, b, false), 1e-6)
+}
@@ -0,0 +1,116 @@
+package sparse
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// Logarithm of the determinant. The determinant of a large matrix overflows or underflows any float64,
+// so LogDeterminant sums the logarithms of the pivots instead of multiplying them, with compensated
+// summation, and keeps the sign or the phase apart.
+
+// LogDeterminant returns log|det| and the sign of the determinant of the factored matrix: ±1 for a real
+// matrix, the unit complex e^(iφ) of its phase for a complex one. A singular matrix gives -Inf and 0.
+func (m *Matrix) LogDeterminant() (logAbs float64, sign complex128, err error) {
+	if m == nil || !m.Factored {
+		return 0.0, 0.0, fmt.Errorf("matrix is not factored")
+	}
+	m.syncFactors()
+	if m.SingularRow > 0 || m.SingularCol > 0 {
+		return math.Inf(-1), 0.0, nil
+	}
+
+	var logSum, phaseSum compensatedSum
+	negative := m.NumberOfInterchangesIsOdd
+	for i := int64(1); i <= m.Size; i++ {
+		// Diags hold the reciprocals of the pivots
+		diag := m.Diags[i]
+		if m.Complex {
+			reciprocal := complex(diag.Real, diag.Imag)
+			if cmplx.IsInf(reciprocal) {
+				return math.Inf(-1), 0.0, nil
+			}
+			logSum.add(-math.Log(cmplx.Abs(reciprocal)))
+			phaseSum.add(-cmplx.Phase(reciprocal))
+			continue
+		}
+		if math.IsInf(diag.Real, 0) {
+			return math.Inf(-1), 0.0, nil
+		}
+		logSum.add(-math.Log(math.Abs(diag.Real)))
+		if diag.Real < 0.0 {
+			negative = !negative
+		}
+	}
+
+	sign = 1.0
+	if m.Complex {
+		sign = cmplx.Rect(1.0, math.Remainder(phaseSum.sum(), 2.0*math.Pi))
+	}
+	if negative {
+		sign = -sign
+	}
+	return logSum.sum(), sign, nil
+}
+
+// DeterminantRatio returns det(m)/det(other) for two factored matrices of the same size and kind, real
+// for real matrices. The logarithms of the ratios of the pivots are summed, which keeps the accuracy of
+// the pivots when both determinants overflow and they share their pivot order.
+func (m *Matrix) DeterminantRatio(other *Matrix) (complex128, error) {
+	if other == nil || m.Size != other.Size || m.Complex != other.Complex {
+		return 0.0, fmt.Errorf("matrices differ in size or kind")
+	}
+	_, sign, err := m.LogDeterminant()
+	if err != nil {
+		return 0.0, err
+	}
+	_, otherSign, err := other.LogDeterminant()
+	if err != nil {
+		return 0.0, err
+	}
+	if otherSign == 0.0 {
+		return 0.0, fmt.Errorf("other matrix is singular")
+	}
+	if sign == 0.0 {
+		return 0.0, nil
+	}
+
+	// Pivot of m by pivot of other, from the reciprocals in Diags
+	var logSum, phaseSum compensatedSum
+	for i := int64(1); i <= m.Size; i++ {
+		diag, otherDiag := m.Diags[i], other.Diags[i]
+		ratio := complex(otherDiag.Real, otherDiag.Imag) / complex(diag.Real, diag.Imag)
+		if !m.Complex {
+			ratio = complex(otherDiag.Real/diag.Real, 0.0)
+		}
+		logSum.add(math.Log(cmplx.Abs(ratio)))
+		phaseSum.add(cmplx.Phase(ratio))
+	}
+
+	if !m.Complex {
+		return complex(real(sign/otherSign)*math.Exp(logSum.sum()), 0.0), nil
+	}
+	ratio := cmplx.Rect(math.Exp(logSum.sum()), math.Remainder(phaseSum.sum(), 2.0*math.Pi))
+	if m.NumberOfInterchangesIsOdd != other.NumberOfInterchangesIsOdd {
+		ratio = -ratio
+	}
+	return ratio, nil
+}
+
+// compensatedSum is a sum with Neumaier's compensation of the rounding errors
+type compensatedSum struct {
+	total, compensation float64
+}
+
+func (s *compensatedSum) add(value float64) {
+	t := s.total + value
+	if math.Abs(s.total) >= math.Abs(value) {
+		s.compensation += (s.total - t) + value
+	} else {
+		s.compensation += (value - t) + s.total
+	}
+	s.total = t
+}
+
+func (s *compensatedSum) sum() float64 { return s.total + s.compensation }
@@ -122,6 +122,7 @@ func (m *Matrix) rowExchange(row1, row2 int64) {
 	m.MarkowitzRow[row1], m.MarkowitzRow[row2] = m.MarkowitzRow[row2], m.MarkowitzRow[row1]
 	m.FirstInRow[row1], m.FirstInRow[row2] = m.FirstInRow[row2], m.FirstInRow[row1]
 	m.IntToExtRowMap[row1], m.IntToExtRowMap[row2] = m.IntToExtRowMap[row2], m.IntToExtRowMap[row1]
+	m.NumberOfInterchangesIsOdd = !m.NumberOfInterchangesIsOdd
 
 	if m.Config.Translate {
 		m.ExtToIntRowMap[m.IntToExtRowMap[row1]] = row1
@@ -172,6 +173,7 @@ func (m *Matrix) colExchange(col1, col2 int64) {
 	m.MarkowitzCol[col1], m.MarkowitzCol[col2] = m.MarkowitzCol[col2], m.MarkowitzCol[col1]
 	m.FirstInCol[col1], m.FirstInCol[col2] = m.FirstInCol[col2], m.FirstInCol[col1]
 	m.IntToExtColMap[col1], m.IntToExtColMap[col2] = m.IntToExtColMap[col2], m.IntToExtColMap[col1]
+	m.NumberOfInterchangesIsOdd = !m.NumberOfInterchangesIsOdd
 
 	if m.Config.Translate {
 		m.ExtToIntColMap[m.IntToExtColMap[col1]] = col1
@@ -2,8 +2,25 @@ package sparse
 
 import (
 	"fmt"
+	"math"
 )
 
+// zeroPivotError is the error of a zero pivot found by Factor, holding its step
+type zeroPivotError int64
+
+func (e zeroPivotError) Error() string {
+	return fmt.Sprintf("zero pivot at step %d", int64(e))
+}
+
+// markSingular records the step of a zero pivot in SingularRow and SingularCol
+func (m *Matrix) markSingular(err error) error {
+	if step, ok := err.(zeroPivotError); ok {
+		m.SingularRow = int64(step)
+		m.SingularCol = int64(step)
+	}
+	return err
+}
+
 func (m *Matrix) OrderAndFactor(rhs []float64, relThreshold, absThreshold float64, diagPivoting bool) error {
 	var err error
 
@@ -19,16 +36,48 @@ func (m *Matrix) OrderAndFactor(rhs []float64, relThreshold, absThreshold float6
 	size := m.Size
 	var step int64 = 1
 
+	var values []ComplexNumber
+	if m.Config.PartialFactor {
+		values = m.captureValues()
+	}
+	m.OriginalValues = nil
+	m.previousValues = nil
+	m.cleared = false
+	m.columnOps = nil
+
+	if m.Config.MonitorPivots {
+		m.startMonitor()
+	}
+
 	if !m.NeedsOrdering {
 		for step = 1; step <= size; step++ {
+			if step == m.DenseStep {
+				// Rows of the block in the order of the last dense LU, reordered when a pivot is rejected
+				if m.factorDense(step, true, func(pivot, largest float64) bool {
+					if m.Config.MonitorPivots {
+						return m.pivotWithinLimits(pivot, largest)
+					}
+					return largest*relThreshold < pivot
+				}) != nil {
+					m.NeedsOrdering = true
+				}
+				break
+			}
+
 			pivot := m.Diags[step]
 			if pivot == nil {
 				m.NeedsOrdering = true
 				break
 			}
 
-			largestInCol := m.FindBiggestInCol(pivot.NextInCol)
-			if largestInCol*relThreshold < m.elementMag(pivot) {
+			var stable bool
+			if m.Config.MonitorPivots {
+				stable = m.pivotIsStable(step, m.elementMag(pivot))
+			} else {
+				largestInCol := m.FindBiggestInCol(pivot.NextInCol)
+				stable = largestInCol*relThreshold < m.elementMag(pivot)
+			}
+			if stable {
 				if m.Complex {
 					m.ComplexRowColElimination(pivot)
 				} else {
@@ -41,6 +90,12 @@ func (m *Matrix) OrderAndFactor(rhs []float64, relThreshold, absThreshold float6
 		}
 
 		if !m.NeedsOrdering {
+			if values != nil {
+				m.saveOriginalValuesFrom(values)
+			}
+			m.buildCompressed()
+			m.buildFactorLevels()
+			m.SolveLevels = nil
 			m.Factored = true
 			return nil
 		}
@@ -60,8 +115,22 @@ func (m *Matrix) OrderAndFactor(rhs []float64, relThreshold, absThreshold float6
 	m.CountMarkowitz(rhs, step)
 	m.MarkowitzProducts(step)
 	m.MaxRowCountInLowerTri = -1
+	m.DenseStep = 0
+
+	// Elements of the active submatrix, kept up to date for the dense switch
+	var active int64
+	if m.Config.DenseThreshold > 0.0 {
+		active = m.activeElements(step)
+	}
 
 	for ; step <= size; step++ {
+		if m.denseSwitchReached(step, active) {
+			if err = m.factorDense(step, false, nil); err != nil {
+				return err
+			}
+			break
+		}
+
 		pivot := m.SearchForPivot(step, diagPivoting)
 		if pivot == nil {
 			m.SingularRow = step
@@ -71,11 +140,16 @@ func (m *Matrix) OrderAndFactor(rhs []float64, relThreshold, absThreshold float6
 
 		m.ExchangeRowsAndCols(pivot, step)
 
+		fillins := m.Fillins
+		if m.Config.DenseThreshold > 0.0 {
+			active -= eliminatedElements(pivot)
+		}
 		if m.Complex {
 			m.ComplexRowColElimination(pivot)
 		} else {
 			m.RealRowColElimination(pivot)
 		}
+		active += int64(m.Fillins - fillins)
 
 		m.UpdateMarkowitzNumbers(pivot)
 
@@ -84,13 +158,24 @@ func (m *Matrix) OrderAndFactor(rhs []float64, relThreshold, absThreshold float6
 		}
 	}
 
+	if values != nil {
+		m.saveOriginalValuesFrom(values)
+	}
+	m.buildCompressed()
+	m.buildFactorLevels()
+	m.SolveLevels = nil
+
 	m.NeedsOrdering = false
 	m.Reordered = true
 	m.Factored = true
 	return nil
 }
 
+// Factor factors the matrix in the pivot order of the last OrderAndFactor. With PartialFactor the
+// elements hold the factors once factored, and Factor fails when no change was recorded since by
+// Clear, AddToElement or MarkChanged: assignments to the element fields are not seen.
 func (m *Matrix) Factor() error {
+	m.PartialColumns = 0
 	if m.NeedsOrdering {
 		return m.OrderAndFactor(nil, 0.0, 0.0, true)
 	}
@@ -101,73 +186,99 @@ func (m *Matrix) Factor() error {
 		}
 	}
 
-	if m.Complex {
-		return m.FactorComplex()
-	}
+	m.LastFactorReordered = false
 
-	if m.Diags[1] == nil || m.Diags[1].Real == 0.0 {
-		m.SingularRow = 1
-		m.SingularCol = 1
-		return fmt.Errorf("zero pivot at step 1")
+	if m.Config.Workers > 1 && m.FactorLevels == nil {
+		m.buildFactorLevels()
 	}
 
-	m.Diags[1].Real = 1.0 / m.Diags[1].Real
+	// The elements hold the factors of the kept unfactored values, changed since by Clear and
+	// restamping, AddToElement or MarkChanged
+	kept := m.Config.PartialFactor && m.OriginalValues != nil
+	if kept {
+		if m.Factored {
+			return errNoChange
+		}
+		m.markRestamped()
+	}
 
-	matrixSize := m.Size + 1 // 1-based indexing
+	if m.CSC != nil {
+		return m.factorFailed(m.factorCompressed())
+	}
 
-	for step := int64(2); step <= m.Size; step++ {
-		if m.DoRealDirect[step] {
-			// factorization - Direct
-			for element := m.FirstInCol[step]; element != nil; element = element.NextInCol {
-				m.Intermediate[element.Row] = element.Real
+	if kept {
+		if m.partialWorthwhile(m.markReachable()) {
+			if m.Config.MonitorPivots {
+				m.startMonitor()
 			}
+			return m.factorFailed(m.factorChangedColumns())
+		}
+		m.restoreValues()
+		clear(m.ChangedCols)
+	} else if m.Config.PartialFactor || m.Config.MonitorPivots {
+		m.saveOriginalValues()
+	}
+	if m.Config.MonitorPivots {
+		m.startMonitor()
+	}
 
-			pColumn := m.FirstInCol[step]
-			for pColumn != nil && pColumn.Row < step {
-				element := m.Diags[pColumn.Row]
-				pColumn.Real = m.Intermediate[pColumn.Row] * element.Real
-				for element = element.NextInCol; element != nil; element = element.NextInCol {
-					m.Intermediate[element.Row] -= pColumn.Real * element.Real
-				}
-				pColumn = pColumn.NextInCol
-			}
+	if m.Complex {
+		return m.factorFailed(m.factorComplexColumns())
+	}
 
-			for element := m.Diags[step].NextInCol; element != nil; element = element.NextInCol {
-				element.Real = m.Intermediate[element.Row]
-			}
+	dest := make([]*float64, m.Size+1) // 1-based indexing
 
-			if m.Intermediate[step] == 0.0 {
-				return fmt.Errorf("zero pivot at step %d", step)
+	if m.FactorLevels != nil {
+		if err := m.factorRealLevels(); err != nil {
+			return m.factorFailed(err)
+		}
+	} else {
+		for step := int64(1); step <= m.lastSparseStep(); step++ {
+			if err := m.factorRealColumn(step, m.Intermediate, dest); err != nil {
+				return m.factorFailed(err)
 			}
-			m.Diags[step].Real = 1.0 / m.Intermediate[step]
-		} else {
-			// factorization - Indirect
-			dest := make([]*float64, matrixSize)
+		}
+	}
+	if m.DenseStep > 0 {
+		if err := m.factorDenseBlock(dest, nil); err != nil {
+			return m.factorFailed(err)
+		}
+	}
 
-			for element := m.FirstInCol[step]; element != nil; element = element.NextInCol {
-				dest[element.Row] = &element.Real
-			}
+	m.Factored = true
+	return nil
+}
 
-			for column := m.FirstInCol[step]; column != nil && column.Row < step; column = column.NextInCol {
-				diag := m.Diags[column.Row]
-				if diag == nil {
-					continue
-				}
+// FactorComplex factors a complex matrix in the pivot order of the last OrderAndFactor. It is Factor,
+// with the same partial refactorization, compressed columns and pivot monitor.
+func (m *Matrix) FactorComplex() error {
+	if !m.Complex {
+		return fmt.Errorf("matrix must be complex")
+	}
+	return m.Factor()
+}
 
-				pColReal := *dest[column.Row] * diag.Real
-				*dest[column.Row] = pColReal
-				for element := diag.NextInCol; element != nil; element = element.NextInCol {
-					*dest[element.Row] -= pColReal * element.Real
-				}
-			}
+// factorComplexColumns is the complex version of the column loop of Factor
+func (m *Matrix) factorComplexColumns() error {
+	matrixSize := m.Size + 1 // 1-based indexing
+
+	work := make([]Element, matrixSize)
+	dest := make([]*Element, matrixSize)
 
-			diag := m.Diags[step]
-			if diag == nil || diag.Real == 0.0 {
-				m.SingularRow = step
-				m.SingularCol = step
-				return fmt.Errorf("zero pivot at step %d", step)
+	if m.FactorLevels != nil {
+		if err := m.factorComplexLevels(); err != nil {
+			return m.markSingular(err)
+		}
+	} else {
+		for step := int64(1); step <= m.lastSparseStep(); step++ {
+			if err := m.factorComplexColumn(step, work, dest); err != nil {
+				return m.markSingular(err)
 			}
-			diag.Real = 1.0 / diag.Real
+		}
+	}
+	if m.DenseStep > 0 {
+		if err := m.factorDenseBlock(nil, dest); err != nil {
+			return err
 		}
 	}
 
@@ -175,78 +286,107 @@ func (m *Matrix) Factor() error {
 	return nil
 }
 
-func (m *Matrix) FactorComplex() error {
-	if m.Diags[1] == nil || (m.Diags[1].Real*m.Diags[1].Real+m.Diags[1].Imag*m.Diags[1].Imag) == 0 {
-		m.SingularRow = 1
-		m.SingularCol = 1
-		return fmt.Errorf("zero pivot at step 1")
+// factorRealColumn computes column step of L and U from the unfactored values of the column
+// and the already factored columns to its left. intermediate and dest are scratch of length Size+1.
+func (m *Matrix) factorRealColumn(step int64, intermediate []float64, dest []*float64) error {
+	if m.Diags[step] == nil {
+		return zeroPivotError(step)
 	}
 
-	m.complexReciprocal(m.Diags[1])
-
-	matrixSize := m.Size + 1 // 1-based indexing
+	if m.DoRealDirect[step] {
+		// factorization - Direct
+		for element := m.FirstInCol[step]; element != nil; element = element.NextInCol {
+			intermediate[element.Row] = element.Real
+		}
 
-	for step := int64(2); step <= m.Size; step++ {
-		if m.DoComplexDirect[step] {
-			dest := make([]*Element, matrixSize)
-			for i := range dest {
-				dest[i] = &Element{}
-			}
-			for element := m.FirstInCol[step]; element != nil; element = element.NextInCol {
-				dest[element.Row].Real = element.Real
-				dest[element.Row].Imag = element.Imag
+		pColumn := m.FirstInCol[step]
+		for pColumn != nil && pColumn.Row < step {
+			element := m.Diags[pColumn.Row]
+			pColumn.Real = intermediate[pColumn.Row] * element.Real
+			for element = element.NextInCol; element != nil; element = element.NextInCol {
+				intermediate[element.Row] -= pColumn.Real * element.Real
 			}
+			pColumn = pColumn.NextInCol
+		}
 
-			for column := m.FirstInCol[step]; column.Row < step; column = column.NextInCol {
-				element := m.Diags[column.Row]
-				m.complexMultAssign(dest[column.Row], element)
-				column.Real = dest[column.Row].Real
-				column.Imag = dest[column.Row].Imag
+		for element := m.Diags[step].NextInCol; element != nil; element = element.NextInCol {
+			element.Real = intermediate[element.Row]
+		}
 
-				for element = element.NextInCol; element != nil; element = element.NextInCol {
-					m.complexMultSubtAssign(dest[element.Row], dest[column.Row], element)
-				}
-			}
+		if m.Config.MonitorPivots && !m.pivotIsStable(step, math.Abs(intermediate[step])) {
+			return errUnstablePivot
+		}
+		if intermediate[step] == 0.0 {
+			return zeroPivotError(step)
+		}
+		m.Diags[step].Real = 1.0 / intermediate[step]
+	} else {
+		// factorization - Indirect
+		m.updateRealColumn(step, step, dest)
 
-			for element := m.FirstInCol[step]; element != nil; element = element.NextInCol {
-				element.Real = dest[element.Row].Real
-				element.Imag = dest[element.Row].Imag
-			}
+		diag := m.Diags[step]
+		if m.Config.MonitorPivots && !m.pivotIsStable(step, math.Abs(diag.Real)) {
+			return errUnstablePivot
+		}
+		if diag.Real == 0.0 {
+			return zeroPivotError(step)
+		}
+		diag.Real = 1.0 / diag.Real
+	}
 
-			if dest[step].Real*dest[step].Real+dest[step].Imag*dest[step].Imag == 0.0 {
-				m.SingularRow = step
-				m.SingularCol = step
-				return fmt.Errorf("zero pivot at step %d", step)
-			}
+	return nil
+}
 
-			m.complexReciprocal(dest[step])
-			m.Diags[step].Real = dest[step].Real
-			m.Diags[step].Imag = dest[step].Imag
-		} else {
-			dest := make([]*Element, matrixSize)
-			for element := m.FirstInCol[step]; element != nil; element = element.NextInCol {
-				dest[element.Row] = element
-			}
+// factorComplexColumn is the complex version of factorRealColumn. work and dest are scratch of length Size+1.
+func (m *Matrix) factorComplexColumn(step int64, work []Element, dest []*Element) error {
+	if m.Diags[step] == nil {
+		return zeroPivotError(step)
+	}
 
-			for column := m.FirstInCol[step]; column.Row < step; column = column.NextInCol {
-				element := m.Diags[column.Row]
-				m.complexMultAssign(dest[column.Row], element)
+	if m.DoComplexDirect[step] {
+		for element := m.FirstInCol[step]; element != nil; element = element.NextInCol {
+			work[element.Row].Real = element.Real
+			work[element.Row].Imag = element.Imag
+		}
 
-				for element = element.NextInCol; element != nil; element = element.NextInCol {
-					m.complexMultSubtAssign(dest[element.Row], dest[column.Row], element)
-				}
-			}
+		for column := m.FirstInCol[step]; column != nil && column.Row < step; column = column.NextInCol {
+			element := m.Diags[column.Row]
+			m.complexMultAssign(&work[column.Row], element)
+			column.Real = work[column.Row].Real
+			column.Imag = work[column.Row].Imag
 
-			if m.Diags[step].Real*m.Diags[step].Real+m.Diags[step].Imag*m.Diags[step].Imag == 0.0 {
-				m.SingularRow = step
-				m.SingularCol = step
-				return fmt.Errorf("zero pivot at step %d", step)
+			for element = element.NextInCol; element != nil; element = element.NextInCol {
+				m.complexMultSubtAssign(&work[element.Row], &work[column.Row], element)
 			}
-			m.complexReciprocal(m.Diags[step])
 		}
+
+		for element := m.FirstInCol[step]; element != nil; element = element.NextInCol {
+			element.Real = work[element.Row].Real
+			element.Imag = work[element.Row].Imag
+		}
+
+		if m.Config.MonitorPivots && !m.pivotIsStable(step, complex1Norm(work[step].Real, work[step].Imag)) {
+			return errUnstablePivot
+		}
+		if work[step].Real*work[step].Real+work[step].Imag*work[step].Imag == 0.0 {
+			return zeroPivotError(step)
+		}
+
+		m.complexReciprocal(&work[step])
+		m.Diags[step].Real = work[step].Real
+		m.Diags[step].Imag = work[step].Imag
+	} else {
+		m.updateComplexColumn(step, step, dest)
+
+		if m.Config.MonitorPivots && !m.pivotIsStable(step, m.elementMag(m.Diags[step])) {
+			return errUnstablePivot
+		}
+		if m.Diags[step].Real*m.Diags[step].Real+m.Diags[step].Imag*m.Diags[step].Imag == 0.0 {
+			return zeroPivotError(step)
+		}
+		m.complexReciprocal(m.Diags[step])
 	}
 
-	m.Factored = true
 	return nil
 }
 
@@ -0,0 +1,66 @@
+package sparse
+
+import "testing"
+
+func TestFactorComplex(t *testing.T) {
+	const n = 80
+	configs := []Configuration{{}, {MonitorPivots: true}, {PartialFactor: true, MonitorPivots: true}, {Compressed: true}, {Compressed: true, MonitorPivots: true}, {Workers: 2}}
+	for _, config := range configs {
+		config.Real, config.Complex = true, true
+		deltas := testDeltas(n, 4, true, 10)
+		m, elements := testMatrix(t, n, deltas, config)
+		if err := m.OrderAndFactor(nil, 0.0, -1.0, true); err != nil {
+			t.Fatal(err)
+		}
+
+		b := testRHS(n)
+		for seed := int64(1); seed <= 3; seed++ {
+			changed := testScale(deltas, seed)
+			testStamp(m, elements, changed)
+			if err := m.FactorComplex(); err != nil {
+				t.Fatal(err)
+			}
+			testClose(t, "FactorComplex", testSolution(t, m, b, false), testReference(t, n, changed, true, b, false), 1e-9)
+		}
+	}
+}
+
+func TestFactorComplexFallback(t *testing.T) {
+	const n = 80
+	deltas := testDeltas(n, 4, true, 11)
+	for _, compressed := range []bool{false, true} {
+		m, elements := testMatrix(t, n, deltas, Configuration{Real: true, Complex: true, MonitorPivots: true, Compressed: compressed})
+		if err := m.OrderAndFactor(nil, 0.0, -1.0, true); err != nil {
+			t.Fatal(err)
+		}
+
+		// The row of the first pivot scaled down makes it tiny relative to the elements below it
+		changed := append([]ElementDelta(nil), deltas...)
+		row := m.IntToExtRowMap[1]
+		for i := range changed {
+			if changed[i].Row == row {
+				changed[i].Real *= 1e-8
+				changed[i].Imag *= 1e-8
+			}
+		}
+		testStamp(m, elements, changed)
+		if err := m.FactorComplex(); err != nil {
+			t.Fatal(err)
+		}
+		if !m.LastFactorReordered {
+			t.Fatal("unstable pivot accepted by FactorComplex")
+		}
+		b := testRHS(n)
+		testClose(t, "FactorComplex fallback", testSolution(t, m, b, false), testReference(t, n, changed, true, b, false), 1e-6)
+	}
+}
+
+func TestFactorComplexOfReal(t *testing.T) {
+	m, _ := testMatrix(t, 10, testDeltas(10, 2, false, 12), Configuration{Real: true})
+	if err := m.OrderAndFactor(nil, 0.0, -1.0, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.FactorComplex(); err == nil {
+		t.Fatal("real matrix factored by FactorComplex")
+	}
+}
@@ -0,0 +1,62 @@
+package sparse
+
+import "fmt"
+
+// Ground row and column. Stamps to row or column 0 go to TrashCan, except row 0 which is kept
+// by external column when Config.GroundRow is set. It never takes part in the factorization,
+// but its product with a solution gives the current into ground for KCL checks.
+
+// groundElement returns the element of row or column 0
+func (m *Matrix) groundElement(row, col int64) *Element {
+	if row != 0 || col == 0 || !m.Config.GroundRow {
+		return m.TrashCan
+	}
+
+	if col >= int64(len(m.GroundRow)) {
+		m.GroundRow = append(m.GroundRow, make([]*Element, col+1-int64(len(m.GroundRow)))...)
+	}
+	if m.GroundRow[col] == nil {
+		m.GroundRow[col] = &Element{Row: 0, Col: col}
+	}
+
+	return m.GroundRow[col]
+}
+
+// GroundRowProduct returns the product of the ground row with solution, laid out as for Solve or
+// SolveComplex. With nodal stamps it is the current into ground, which must match the current
+// injected at ground for KCL to hold.
+func (m *Matrix) GroundRowProduct(solution, isolution []float64) (float64, float64, error) {
+	if !m.Config.GroundRow {
+		return 0.0, 0.0, fmt.Errorf("ground row is not kept, set GroundRow config")
+	}
+
+	separated := m.Config.SeparatedComplexVectors
+	last := int64(len(m.GroundRow)) - 1
+	for last > 0 && m.GroundRow[last] == nil {
+		last--
+	}
+	switch {
+	case m.Complex && !separated && int64(len(solution)) < 2*(last+1):
+		return 0.0, 0.0, fmt.Errorf("solution array size(%d) is smaller than interleaved ground row size(%d)", len(solution), 2*(last+1))
+	case int64(len(solution)) <= last || (m.Complex && separated && int64(len(isolution)) <= last):
+		return 0.0, 0.0, fmt.Errorf("solution or isolution array size(%d,%d) is smaller than ground row size(%d)", len(solution), len(isolution), last+1)
+	}
+
+	sum := Element{}
+	for col, element := range m.GroundRow {
+		if element == nil {
+			continue
+		}
+
+		switch {
+		case !m.Complex:
+			sum.Real += element.Real * solution[col]
+		case separated:
+			m.complexMultAddAssign(&sum, element, &Element{Real: solution[col], Imag: isolution[col]})
+		default:
+			m.complexMultAddAssign(&sum, element, &Element{Real: solution[2*col], Imag: solution[2*col+1]})
+		}
+	}
+
+	return sum.Real, sum.Imag, nil
+}
@@ -0,0 +1,153 @@
+package sparse
+
+import "fmt"
+
+// Selected entries of the inverse. The factored matrix is P A Q = L U, L lower triangular holding the
+// pivots d, stored as reciprocals on the diagonal, and U unit upper triangular. With L = L̃ D, L̃ unit
+// lower triangular, Z = (P A Q)⁻¹ = U⁻¹ D⁻¹ L̃⁻¹ satisfies the Takahashi equations
+//   Z = D⁻¹ L̃⁻¹ + (I - U) Z  and  Z = U⁻¹ D⁻¹ + Z (I - L̃)
+// whose triangular parts give every entry from entries of larger indices:
+//   z(i,i) = 1/d(i) - Σ u(i,k) z(k,i)           k > i in row i of U
+//   z(i,j) =        - Σ u(i,k) z(k,j)   i < j,   k > i in row i of U
+//   z(i,j) =        - Σ z(i,k) l̃(k,j)   i > j,   k > j in column j of L
+// The entries are computed on demand and kept, so only those reachable through the patterns of L and
+// U are computed, which for the diagonal are about the entries of the factors.
+
+const INVERSE_DENSE_MAX int64 = 2000 // Largest matrix for InverseDense
+
+// Entry is a position of the inverse, in external indexing
+type Entry struct {
+	Row, Col int64
+}
+
+// takahashi computes the entries of the inverse of the factored matrix, in internal indexing
+type takahashi struct {
+	m        *Matrix
+	computed map[int64]complex128
+}
+
+// entry returns z(i,j), computing the entries it needs first
+func (t *takahashi) entry(i, j int64) complex128 {
+	key := i*(t.m.Size+1) + j
+	if z, ok := t.computed[key]; ok {
+		return z
+	}
+
+	diags := t.m.Diags
+	z := complex128(0.0)
+	if i <= j {
+		if i == j {
+			z = t.value(diags[i])
+		}
+		for element := diags[i].NextInRow; element != nil; element = element.NextInRow {
+			z -= t.value(element) * t.entry(element.Col, j)
+		}
+	} else {
+		reciprocal := t.value(diags[j])
+		for element := diags[j].NextInCol; element != nil; element = element.NextInCol {
+			z -= t.entry(i, element.Row) * t.value(element) * reciprocal
+		}
+	}
+
+	t.computed[key] = z
+	return z
+}
+
+func (t *takahashi) value(element *Element) complex128 {
+	if t.m.Complex {
+		return complex(element.Real, element.Imag)
+	}
+	return complex(element.Real, 0.0)
+}
+
+// inverseMaps returns the internal rows and columns of the external indices, 0 when not in the matrix
+func (m *Matrix) inverseMaps() (rows, cols []int64, err error) {
+	if !m.Factored {
+		return nil, nil, fmt.Errorf("matrix is not factored")
+	}
+	if m.SingularRow > 0 || m.SingularCol > 0 {
+		return nil, nil, fmt.Errorf("matrix is singular")
+	}
+	m.syncFactors()
+
+	size := m.GetSize(true)
+	rows = make([]int64, size+1) // 1-based indexing
+	cols = make([]int64, size+1)
+	for i := int64(1); i <= m.Size; i++ {
+		if ext := m.IntToExtRowMap[i]; ext > 0 && ext <= size {
+			rows[ext] = i
+		}
+		if ext := m.IntToExtColMap[i]; ext > 0 && ext <= size {
+			cols[ext] = i
+		}
+	}
+	return rows, cols, nil
+}
+
+// InverseEntries returns the entries of A⁻¹ at pairs, from the factors by the Takahashi equations. The
+// values are one per pair in the layout of the vectors of Solve or SolveComplex, as Sensitivity.
+func (m *Matrix) InverseEntries(pairs []Entry) ([]float64, []float64, error) {
+	rows, cols, err := m.inverseMaps()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// x = A⁻¹ b is x[extCol(i)] = z(i,j) b[extRow(j)]
+	t := &takahashi{m: m, computed: make(map[int64]complex128)}
+	values := make([]complex128, len(pairs))
+	for n, pair := range pairs {
+		if pair.Row < 1 || pair.Col < 1 || pair.Row >= int64(len(cols)) || pair.Col >= int64(len(rows)) ||
+			cols[pair.Row] == 0 || rows[pair.Col] == 0 {
+			return nil, nil, fmt.Errorf("index (%d,%d) is not in the matrix", pair.Row, pair.Col)
+		}
+		values[n] = t.entry(cols[pair.Row], rows[pair.Col])
+	}
+	re, im := m.fromComplexVector(values, 0)
+	return re, im, nil
+}
+
+// InverseDiagonal returns the diagonal of A⁻¹ by external index, in the layout of the vectors of Solve
+// or SolveComplex, from the factors by the Takahashi equations
+func (m *Matrix) InverseDiagonal() ([]float64, []float64, error) {
+	rows, cols, err := m.inverseMaps()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t := &takahashi{m: m, computed: make(map[int64]complex128)}
+	diagonal := make([]complex128, len(rows))
+	for ext := int64(1); ext < int64(len(rows)); ext++ {
+		if cols[ext] != 0 && rows[ext] != 0 {
+			diagonal[ext] = t.entry(cols[ext], rows[ext])
+		}
+	}
+	re, im := m.fromComplexVector(diagonal, 0)
+	return re, im, nil
+}
+
+// InverseDense returns A⁻¹ in external indexing, [row][col] from 1, by a solve per column. For matrices
+// up to INVERSE_DENSE_MAX, as a reference for the Takahashi entries.
+func (m *Matrix) InverseDense() ([][]complex128, error) {
+	if _, _, err := m.inverseMaps(); err != nil {
+		return nil, err
+	}
+	size := m.GetSize(true)
+	if size > INVERSE_DENSE_MAX {
+		return nil, fmt.Errorf("matrix size %d larger than %d", size, INVERSE_DENSE_MAX)
+	}
+
+	inverse := make([][]complex128, size+1) // 1-based indexing
+	for row := range inverse {
+		inverse[row] = make([]complex128, size+1)
+	}
+	for col := int64(1); col <= size; col++ {
+		x, err := m.solveUnit(col, false)
+		if err != nil {
+			return nil, err
+		}
+		for row := int64(1); row <= size; row++ {
+			inverse[row][col] = x[row]
+		}
+	}
+	return inverse, nil
+}
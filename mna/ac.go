@@ -0,0 +1,450 @@
+package mna
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"strings"
+
+	"github.com/edp1096/sparse"
+)
+
+// AC small-signal analysis. The devices are linearized at the operating point and loaded at S = jω
+// into a complex matrix, factored and solved at every frequency of the sweep. The pivot order of the
+// first frequency is reused by Factor for the whole sweep, only computed again
+// when MonitorPivots finds it unstable.
+
+// Sweep is the spacing of the frequencies of an AC analysis
+type Sweep int
+
+const (
+	LINEAR Sweep = iota // Points evenly spaced from Start to Stop
+	DECADE              // Points per decade
+	OCTAVE              // Points per octave
+)
+
+// Format is the output format of an AC analysis
+type Format int
+
+const (
+	CSV  Format = iota // Frequency, then magnitude, dB, phase and group delay of every probe
+	JSON               // Object of the frequencies and of the columns of every probe
+)
+
+// ACOptions are the settings of an AC analysis
+type ACOptions struct {
+	Sweep       Sweep
+	Points      int     // In total for LINEAR, per decade or octave otherwise
+	Start, Stop float64 // Hz, Start above 0 for DECADE and OCTAVE
+
+	Inputs []string // Sources driven alone, at their AC value or 1 without one. All with AC values when empty
+	Probes []string // Outputs, see Probe. Every unknown when empty
+
+	Solution []float64     // Operating point, computed with Newton when nil
+	Newton   NewtonOptions // Operating point
+
+	Writer io.Writer // Output written after the sweep, nil for none
+	Format Format
+}
+
+// ACResult holds the probe values over the sweep
+type ACResult struct {
+	Frequencies []float64
+	Probes      []Probe
+	Values      [][]complex128 // [probe][point]
+	Reorders    int            // Factor calls that fell back to a new pivot order
+
+	OperatingPoint []float64
+}
+
+// Probe is an output of the AC analysis: the difference of two unknowns, or the ratio of two differences
+type Probe struct {
+	Name           string
+	Pos, Neg       int64 // Unknowns, 0 for ground
+	RefPos, RefNeg int64 // Divisor of a ratio
+	Ratio          bool
+}
+
+// Probe parses an output: a node name, V(node), V(node,node), I(device), or the ratio of two of them
+// as V(out)/V(in)
+func (c *Circuit) Probe(expr string) (Probe, error) {
+	probe := Probe{Name: expr}
+
+	depth, slash := 0, -1
+	for i, r := range expr {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '/':
+			if depth == 0 {
+				slash = i
+			}
+		}
+	}
+
+	var err error
+	if slash < 0 {
+		probe.Pos, probe.Neg, err = c.difference(expr)
+		return probe, err
+	}
+	if probe.Pos, probe.Neg, err = c.difference(expr[:slash]); err != nil {
+		return probe, err
+	}
+	probe.RefPos, probe.RefNeg, err = c.difference(expr[slash+1:])
+	probe.Ratio = true
+	return probe, err
+}
+
+// difference returns the unknowns of V(node), V(node,node), I(device) or a node name
+func (c *Circuit) difference(term string) (int64, int64, error) {
+	term = strings.TrimSpace(term)
+	index := func(name string) (int64, error) {
+		i, ok := c.Nodes.Index(strings.TrimSpace(name))
+		if !ok {
+			return 0, fmt.Errorf("unknown probe: %s", term)
+		}
+		return i, nil
+	}
+
+	lower := strings.ToLower(term)
+	if !strings.HasSuffix(lower, ")") {
+		i, err := index(term)
+		return i, 0, err
+	}
+	switch {
+	case strings.HasPrefix(lower, "v("):
+		nodes := strings.Split(term[2:len(term)-1], ",")
+		if len(nodes) > 2 {
+			return 0, 0, fmt.Errorf("invalid probe: %s", term)
+		}
+		pos, err := index(nodes[0])
+		if err != nil || len(nodes) == 1 {
+			return pos, 0, err
+		}
+		neg, err := index(nodes[1])
+		return pos, neg, err
+	case strings.HasPrefix(lower, "i("):
+		i, err := index("I(" + strings.TrimSpace(term[2:len(term)-1]) + ")")
+		return i, 0, err
+	}
+	i, err := index(term)
+	return i, 0, err
+}
+
+// Value returns the probe in an interleaved complex solution
+func (p Probe) Value(x []float64) complex128 {
+	value := phasor(x, p.Pos) - phasor(x, p.Neg)
+	if p.Ratio {
+		value /= phasor(x, p.RefPos) - phasor(x, p.RefNeg)
+	}
+	return value
+}
+
+// phasor returns the complex unknown of index in an interleaved solution, 0 for ground
+func phasor(x []float64, index int64) complex128 {
+	if index == 0 {
+		return 0.0
+	}
+	return complex(x[2*index], x[2*index+1])
+}
+
+// Frequencies returns the frequencies of the sweep
+func (o *ACOptions) Frequencies() ([]float64, error) {
+	if o.Points < 1 || o.Start < 0.0 || o.Stop < o.Start {
+		return nil, fmt.Errorf("invalid AC sweep: %d points, start %g, stop %g", o.Points, o.Start, o.Stop)
+	}
+
+	if o.Sweep == LINEAR {
+		if o.Points == 1 {
+			return []float64{o.Start}, nil
+		}
+		frequencies := make([]float64, o.Points)
+		for i := range frequencies {
+			frequencies[i] = o.Start + (o.Stop-o.Start)*float64(i)/float64(o.Points-1)
+		}
+		return frequencies, nil
+	}
+
+	if o.Start <= 0.0 {
+		return nil, fmt.Errorf("logarithmic AC sweep from %g", o.Start)
+	}
+	base := 10.0
+	if o.Sweep == OCTAVE {
+		base = 2.0
+	}
+	// Points at Start·base^(k/Points) up to Stop, rounding allowed
+	count := int(math.Floor(math.Log(o.Stop/o.Start)/math.Log(base)*float64(o.Points)+1e-9)) + 1
+	frequencies := make([]float64, count)
+	for k := range frequencies {
+		frequencies[k] = o.Start * math.Pow(base, float64(k)/float64(o.Points))
+	}
+	return frequencies, nil
+}
+
+// AC runs an AC analysis on m, a complex matrix of the circuit, or on a new one when m is nil
+func (c *Circuit) AC(m *sparse.Matrix, options ACOptions) (*ACResult, error) {
+	frequencies, err := options.Frequencies()
+	if err != nil {
+		return nil, err
+	}
+
+	probes, err := c.acProbes(options.Probes)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := NewContext(AC)
+	if ctx.Inputs, err = c.inputs(options.Inputs); err != nil {
+		return nil, err
+	}
+
+	// Operating point on a real matrix
+	x := options.Solution
+	if x == nil {
+		op, err := c.OperatingPoint(nil, NewContext(DC), options.Newton)
+		if err != nil {
+			return nil, fmt.Errorf("operating point: %v", err)
+		}
+		x = op.Solution
+	}
+	ctx.Solution = x
+	ctx.Gmin = options.Newton.defaults().Gmin
+
+	if m == nil {
+		if m, err = c.NewMatrix(true); err != nil {
+			return nil, err
+		}
+		defer m.Destroy()
+	}
+	if !m.Complex {
+		return nil, fmt.Errorf("matrix must be complex")
+	}
+
+	result := &ACResult{Frequencies: frequencies, Probes: probes, Values: make([][]complex128, len(probes)), OperatingPoint: x}
+	for i := range result.Values {
+		result.Values[i] = make([]complex128, len(frequencies))
+	}
+
+	reorders := m.FallbackReorders
+	for point, f := range frequencies {
+		ctx.S = complex(0.0, 2.0*math.Pi*f)
+		solution, err := c.Solve(m, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("frequency %g: %v", f, err)
+		}
+		for i, probe := range probes {
+			result.Values[i][point] = probe.Value(solution)
+		}
+	}
+	result.Reorders = m.FallbackReorders - reorders
+
+	if options.Writer != nil {
+		if err := result.Write(options.Writer, options.Format); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// acProbes parses the probes, every unknown when there are none
+func (c *Circuit) acProbes(exprs []string) ([]Probe, error) {
+	if len(exprs) == 0 {
+		probes := make([]Probe, c.Nodes.Size())
+		for i := range probes {
+			index := int64(i + 1)
+			probes[i] = Probe{Name: c.Label(index), Pos: index}
+		}
+		return probes, nil
+	}
+
+	probes := make([]Probe, len(exprs))
+	for i, expr := range exprs {
+		probe, err := c.Probe(expr)
+		if err != nil {
+			return nil, err
+		}
+		probes[i] = probe
+	}
+	return probes, nil
+}
+
+// inputs returns the AC values of the named sources for Context.Inputs, nil when there are none
+func (c *Circuit) inputs(names []string) (map[string]complex128, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	inputs := make(map[string]complex128)
+	for _, name := range names {
+		source, ok := c.Device(name).(interface{ phasor() complex128 })
+		if !ok {
+			return nil, fmt.Errorf("%s is not an independent source", name)
+		}
+		value := source.phasor()
+		if value == 0.0 {
+			value = 1.0
+		}
+		inputs[name] = value
+	}
+	return inputs, nil
+}
+
+// Magnitude returns the magnitudes of a probe over the sweep
+func (r *ACResult) Magnitude(probe int) []float64 {
+	magnitudes := make([]float64, len(r.Frequencies))
+	for i, value := range r.Values[probe] {
+		magnitudes[i] = cmplx.Abs(value)
+	}
+	return magnitudes
+}
+
+// DB returns the magnitudes of a probe in decibels, 20·log10
+func (r *ACResult) DB(probe int) []float64 {
+	db := r.Magnitude(probe)
+	for i, magnitude := range db {
+		db[i] = 20.0 * math.Log10(magnitude)
+	}
+	return db
+}
+
+// Phase returns the phases of a probe in degrees, unwrapped along the sweep from the first one in (-180, 180]
+func (r *ACResult) Phase(probe int) []float64 {
+	values := r.Values[probe]
+	phases := make([]float64, len(values))
+	for i, value := range values {
+		if i == 0 {
+			phases[i] = cmplx.Phase(value)
+			continue
+		}
+		phases[i] = phases[i-1] + turn(values[i-1], value)
+	}
+	for i := range phases {
+		phases[i] *= 180.0 / math.Pi
+	}
+	return phases
+}
+
+// GroupDelay returns -dφ/dω of a probe in seconds, by differences between the neighbouring frequencies
+func (r *ACResult) GroupDelay(probe int) []float64 {
+	values := r.Values[probe]
+	delays := make([]float64, len(values))
+	if len(values) < 2 {
+		return delays
+	}
+	for i := range values {
+		lo, hi := max(i-1, 0), min(i+1, len(values)-1)
+		omega := 2.0 * math.Pi * (r.Frequencies[hi] - r.Frequencies[lo])
+		delays[i] = -turn(values[lo], values[hi]) / omega
+	}
+	return delays
+}
+
+// turn returns the phase from a to b in (-π, π], 0 when either is 0
+func turn(a, b complex128) float64 {
+	if a == 0.0 || b == 0.0 {
+		return 0.0
+	}
+	return cmplx.Phase(b / a)
+}
+
+// Write writes the result in format
+func (r *ACResult) Write(w io.Writer, format Format) error {
+	switch format {
+	case CSV:
+		return r.WriteCSV(w)
+	case JSON:
+		return r.WriteJSON(w)
+	}
+	return fmt.Errorf("unknown format: %d", format)
+}
+
+// WriteCSV writes a header, then a row per frequency with the magnitude, dB, phase and group delay
+// of every probe
+func (r *ACResult) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+
+	header := []string{"frequency"}
+	for _, probe := range r.Probes {
+		for _, column := range []string{"mag", "db", "phase", "gd"} {
+			header = append(header, column+"("+probe.Name+")")
+		}
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	columns := make([][]float64, 0, 4*len(r.Probes))
+	for i := range r.Probes {
+		columns = append(columns, r.Magnitude(i), r.DB(i), r.Phase(i), r.GroupDelay(i))
+	}
+	for point, f := range r.Frequencies {
+		row := []string{strconv.FormatFloat(f, 'e', 9, 64)}
+		for _, column := range columns {
+			row = append(row, strconv.FormatFloat(column[point], 'e', 9, 64))
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+type acProbeJSON struct {
+	Name       string     `json:"name"`
+	Real       []*float64 `json:"real"`
+	Imag       []*float64 `json:"imag"`
+	Magnitude  []*float64 `json:"magnitude"`
+	DB         []*float64 `json:"db"`
+	Phase      []*float64 `json:"phase"`
+	GroupDelay []*float64 `json:"group_delay"`
+}
+
+type acJSON struct {
+	Frequencies []float64     `json:"frequencies"`
+	Probes      []acProbeJSON `json:"probes"`
+}
+
+// WriteJSON writes the frequencies and, for every probe, the real and imaginary parts, magnitude,
+// dB, phase and group delay. Values that are not finite, as the dB of 0, are null.
+func (r *ACResult) WriteJSON(w io.Writer) error {
+	out := acJSON{Frequencies: r.Frequencies, Probes: make([]acProbeJSON, len(r.Probes))}
+	for i, probe := range r.Probes {
+		re := make([]float64, len(r.Frequencies))
+		im := make([]float64, len(r.Frequencies))
+		for point, value := range r.Values[i] {
+			re[point], im[point] = real(value), imag(value)
+		}
+		out.Probes[i] = acProbeJSON{
+			Name:       probe.Name,
+			Real:       finite(re),
+			Imag:       finite(im),
+			Magnitude:  finite(r.Magnitude(i)),
+			DB:         finite(r.DB(i)),
+			Phase:      finite(r.Phase(i)),
+			GroupDelay: finite(r.GroupDelay(i)),
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// finite returns values for JSON, nil for those that are not finite
+func finite(values []float64) []*float64 {
+	pointers := make([]*float64, len(values))
+	for i := range values {
+		if !math.IsInf(values[i], 0) && !math.IsNaN(values[i]) {
+			pointers[i] = &values[i]
+		}
+	}
+	return pointers
+}
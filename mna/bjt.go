@@ -0,0 +1,287 @@
+package mna
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/edp1096/sparse"
+)
+
+// BJTModel is the model card of a bipolar transistor, the Gummel-Poon model of SPICE without base
+// resistance modulation, quasi-saturation and substrate junction. Without VAF, VAR, IKF, IKR, ISE and
+// ISC it is the Ebers-Moll model.
+type BJTModel struct {
+	PNP bool
+
+	IS  float64 // Transport saturation current
+	BF  float64 // Ideal forward beta
+	BR  float64 // Ideal reverse beta
+	NF  float64 // Forward emission coefficient
+	NR  float64 // Reverse emission coefficient
+	VAF float64 // Forward Early voltage, 0 for none
+	VAR float64 // Reverse Early voltage, 0 for none
+	IKF float64 // Forward beta roll-off corner current, 0 for none
+	IKR float64 // Reverse beta roll-off corner current, 0 for none
+	ISE float64 // Base-emitter leakage saturation current
+	NE  float64
+	ISC float64 // Base-collector leakage saturation current
+	NC  float64
+
+	RB, RC, RE float64 // Series resistances
+
+	CJE, VJE, MJE float64 // Base-emitter depletion capacitance
+	CJC, VJC, MJC float64 // Base-collector depletion capacitance
+	FC            float64
+	TF, TR        float64 // Forward and reverse transit times
+
+	EG, XTI, XTB float64 // Band gap, saturation current and beta temperature exponents
+	KF, AF       float64 // Flicker noise coefficient and exponent
+	TNOM         float64
+}
+
+func NewBJTModel(pnp bool) *BJTModel {
+	return &BJTModel{
+		PNP: pnp, IS: 1e-16, BF: 100.0, BR: 1.0, NF: 1.0, NR: 1.0, NE: 1.5, NC: 2.0,
+		VJE: 0.75, MJE: 0.33, VJC: 0.75, MJC: 0.33, FC: 0.5, EG: 1.11, XTI: 3.0, AF: 1.0, TNOM: TNOM,
+	}
+}
+
+func (m *BJTModel) Parameters() map[string]*float64 {
+	return map[string]*float64{
+		"is": &m.IS, "bf": &m.BF, "br": &m.BR, "nf": &m.NF, "nr": &m.NR,
+		"vaf": &m.VAF, "va": &m.VAF, "var": &m.VAR, "vb": &m.VAR, "ikf": &m.IKF, "ik": &m.IKF, "ikr": &m.IKR,
+		"ise": &m.ISE, "ne": &m.NE, "isc": &m.ISC, "nc": &m.NC,
+		"rb": &m.RB, "rc": &m.RC, "re": &m.RE,
+		"cje": &m.CJE, "vje": &m.VJE, "pe": &m.VJE, "mje": &m.MJE, "me": &m.MJE,
+		"cjc": &m.CJC, "vjc": &m.VJC, "pc": &m.VJC, "mjc": &m.MJC, "mc": &m.MJC,
+		"fc": &m.FC, "tf": &m.TF, "tr": &m.TR,
+		"eg": &m.EG, "xti": &m.XTI, "xtb": &m.XTB, "kf": &m.KF, "af": &m.AF, "tnom": &m.TNOM,
+	}
+}
+
+// BJT is a bipolar transistor between Collector, Base and Emitter, with the base-emitter and
+// base-collector charges integrated in transient
+type BJT struct {
+	name                     string
+	Collector, Base, Emitter int64
+	Model                    *BJTModel
+	Area                     float64
+	Temp                     float64 // °C
+
+	rc, rb, re resistance
+
+	// At Temp, scaled by Area
+	polarity           float64 // 1 for NPN, -1 for PNP
+	vt, is, ise, isc   float64
+	bf, br, vcrit      float64
+	cje, vje, cjc, vjc float64
+
+	be, bc   sparse.Template // Base current and charge admittances
+	tbe, tbc sparse.Template // Transport current from collector to emitter, controlled by vbe and vbc
+	base     int
+
+	last bjtPoint // Last linearization
+}
+
+// bjtPoint is the linearization at vbe and vbc, in NPN polarity
+type bjtPoint struct {
+	vbe, vbc float64
+	ibe, gpi float64 // Base to emitter current
+	ibc, gmu float64 // Base to collector current
+	it       float64 // Collector to emitter transport current
+	gf, gr   float64 // Transport current derivatives by vbe and vbc
+	cbe, gbe float64 // Ideal diode currents, for the diffusion charges
+	cbc, gbc float64
+}
+
+func NewBJT(name string, collector, base, emitter int64, model *BJTModel, area float64) *BJT {
+	q := &BJT{name: name, Collector: collector, Base: base, Emitter: emitter, Model: model, Area: area, Temp: TNOM}
+	q.rc.set(collector, 0.0, nil)
+	q.rb.set(base, 0.0, nil)
+	q.re.set(emitter, 0.0, nil)
+	return q
+}
+
+func (q *BJT) Name() string { return q.name }
+
+func (q *BJT) SetInternal(node func(name string) int64) {
+	q.rc.set(q.Collector, q.Model.RC/q.Area, func() int64 { return node("c") })
+	q.rb.set(q.Base, q.Model.RB/q.Area, func() int64 { return node("b") })
+	q.re.set(q.Emitter, q.Model.RE/q.Area, func() int64 { return node("e") })
+}
+
+func (q *BJT) Setup(m *sparse.Matrix) error {
+	model := q.Model
+	if q.Area <= 0.0 {
+		return fmt.Errorf("area %g not positive", q.Area)
+	}
+
+	q.polarity = 1.0
+	if model.PNP {
+		q.polarity = -1.0
+	}
+
+	ratio := (q.Temp + KELVIN) / (model.TNOM + KELVIN)
+	factor := math.Pow(ratio, model.XTB)
+	q.vt = thermalVoltage(q.Temp)
+	q.is = saturationTemp(model.IS, model.EG, model.XTI, 1.0, model.TNOM, q.Temp)
+	q.ise = model.ISE / factor * math.Pow(q.is/model.IS, 1.0/model.NE) * q.Area
+	q.isc = model.ISC / factor * math.Pow(q.is/model.IS, 1.0/model.NC) * q.Area
+	q.is *= q.Area
+	q.bf = model.BF * factor
+	q.br = model.BR * factor
+	q.vcrit = CriticalVoltage(q.is, q.vt)
+	q.vje, q.cje = junctionTemp(model.VJE, model.CJE, model.MJE, model.TNOM, q.Temp)
+	q.vjc, q.cjc = junctionTemp(model.VJC, model.CJC, model.MJC, model.TNOM, q.Temp)
+	q.cje *= q.Area
+	q.cjc *= q.Area
+
+	for _, r := range []*resistance{&q.rc, &q.rb, &q.re} {
+		if err := r.setup(m); err != nil {
+			return err
+		}
+	}
+
+	c, b, e := q.rc.internal, q.rb.internal, q.re.internal
+	if err := m.GetAdmittance(b, e, &q.be); err != nil {
+		return err
+	}
+	if err := m.GetAdmittance(b, c, &q.bc); err != nil {
+		return err
+	}
+	if err := m.GetQuad(c, e, b, e, &q.tbe); err != nil {
+		return err
+	}
+	return m.GetQuad(c, e, b, c, &q.tbc)
+}
+
+// evaluate returns the currents and conductances at vbe and vbc
+func (q *BJT) evaluate(vbe, vbc, gmin float64) bjtPoint {
+	model := q.Model
+	p := bjtPoint{vbe: vbe, vbc: vbc}
+
+	p.cbe, p.gbe = junction(vbe, q.is, model.NF*q.vt)
+	p.cbc, p.gbc = junction(vbc, q.is, model.NR*q.vt)
+	ile, gle := 0.0, 0.0
+	if q.ise != 0.0 {
+		ile, gle = junction(vbe, q.ise, model.NE*q.vt)
+	}
+	ilc, glc := 0.0, 0.0
+	if q.isc != 0.0 {
+		ilc, glc = junction(vbc, q.isc, model.NC*q.vt)
+	}
+
+	// Base charge
+	inverse := func(x float64) float64 {
+		if x == 0.0 {
+			return 0.0
+		}
+		return 1.0 / x
+	}
+	ova, ovb := inverse(model.VAF), inverse(model.VAR)
+	oik, oikr := inverse(model.IKF*q.Area), inverse(model.IKR*q.Area)
+
+	q1 := 1.0 / (1.0 - ova*vbc - ovb*vbe)
+	qb, dqbdve, dqbdvc := q1, q1*q1*ovb, q1*q1*ova
+	if oik != 0.0 || oikr != 0.0 {
+		q2 := oik*p.cbe + oikr*p.cbc
+		sqarg := math.Sqrt(max(1.0+4.0*q2, 0.0))
+		if sqarg == 0.0 {
+			sqarg = 1.0
+		}
+		qb = q1 * (1.0 + sqarg) / 2.0
+		dqbdve = q1 * (qb*ovb + oik*p.gbe/sqarg)
+		dqbdvc = q1 * (qb*ova + oikr*p.gbc/sqarg)
+	}
+
+	p.ibe = p.cbe/q.bf + ile + gmin*vbe
+	p.gpi = p.gbe/q.bf + gle + gmin
+	p.ibc = p.cbc/q.br + ilc + gmin*vbc
+	p.gmu = p.gbc/q.br + glc + gmin
+	p.it = (p.cbe - p.cbc) / qb
+	p.gf = (p.gbe - p.it*dqbdve) / qb
+	p.gr = (-p.gbc - p.it*dqbdvc) / qb
+	return p
+}
+
+// charges returns the base-emitter and base-collector charges and capacitances at p
+func (q *BJT) charges(p bjtPoint) (qbe, cbe, qbc, cbc float64) {
+	model := q.Model
+	qbe, cbe = depletion(p.vbe, q.cje, q.vje, model.MJE, model.FC)
+	qbc, cbc = depletion(p.vbc, q.cjc, q.vjc, model.MJC, model.FC)
+	return qbe + model.TF*p.cbe, cbe + model.TF*p.gbe, qbc + model.TR*p.cbc, cbc + model.TR*p.gbc
+}
+
+// voltages returns vbe and vbc at solution in NPN polarity
+func (q *BJT) voltages(solution []float64) (float64, float64) {
+	vb := voltage(solution, q.rb.internal)
+	return q.polarity * (vb - voltage(solution, q.re.internal)), q.polarity * (vb - voltage(solution, q.rc.internal))
+}
+
+func (q *BJT) Load(m *sparse.Matrix, rhs []float64, ctx *Context) error {
+	q.rc.load()
+	q.rb.load()
+	q.re.load()
+
+	c, b, e := q.rc.internal, q.rb.internal, q.re.internal
+	vbe, vbc := q.voltages(ctx.Solution)
+
+	if ctx.Mode == AC {
+		p := q.evaluate(vbe, vbc, ctx.Gmin)
+		_, cbe, _, cbc := q.charges(p)
+		q.be.AddRealQuad(p.gpi)
+		q.bc.AddRealQuad(p.gmu)
+		q.tbe.AddRealQuad(p.gf)
+		q.tbc.AddRealQuad(p.gr)
+		loadCharge(&q.be, rhs, b, e, q.base, 0.0, cbe, 0.0, ctx)
+		loadCharge(&q.bc, rhs, b, c, q.base+1, 0.0, cbc, 0.0, ctx)
+		return nil
+	}
+
+	if ctx.Initial {
+		vbe, vbc = q.vcrit, 0.0
+	} else {
+		vbe = ctx.limit(vbe, q.last.vbe, q.vt, q.vcrit)
+		vbc = ctx.limit(vbc, q.last.vbc, q.vt, q.vcrit)
+	}
+	p := q.evaluate(vbe, vbc, ctx.Gmin)
+	q.last = p
+
+	// Currents in circuit polarity, conductances unchanged
+	s := q.polarity
+	q.be.AddRealQuad(p.gpi)
+	loadCurrent(rhs, b, e, s*p.ibe, s*p.gpi*vbe)
+	q.bc.AddRealQuad(p.gmu)
+	loadCurrent(rhs, b, c, s*p.ibc, s*p.gmu*vbc)
+	q.tbe.AddRealQuad(p.gf)
+	q.tbc.AddRealQuad(p.gr)
+	loadCurrent(rhs, c, e, s*p.it, s*(p.gf*vbe+p.gr*vbc))
+
+	if ctx.Mode == TRANSIENT {
+		qbe, cbe, qbc, cbc := q.charges(p)
+		loadCharge(&q.be, rhs, b, e, q.base, s*qbe, cbe, s*vbe, ctx)
+		loadCharge(&q.bc, rhs, b, c, q.base+1, s*qbc, cbc, s*vbc, ctx)
+	}
+	return nil
+}
+
+func (q *BJT) Converged(ctx *Context) bool {
+	vbe, vbc := q.voltages(ctx.Solution)
+	p := q.evaluate(vbe, vbc, ctx.Gmin)
+	l := q.last
+	dbe, dbc := vbe-l.vbe, vbc-l.vbc
+	return ctx.currentConverged(l.ibe+l.gpi*dbe, p.ibe) &&
+		ctx.currentConverged(l.ibc+l.gmu*dbc, p.ibc) &&
+		ctx.currentConverged(l.it+l.gf*dbe+l.gr*dbc, p.it)
+}
+
+func (q *BJT) StateCount() int { return 2 }
+
+func (q *BJT) SetStateBase(base int) { q.base = base }
+
+func (q *BJT) States(solution []float64, states []float64) {
+	vbe, vbc := q.voltages(solution)
+	qbe, _, qbc, _ := q.charges(q.evaluate(vbe, vbc, 0.0))
+	states[q.base] = q.polarity * qbe
+	states[q.base+1] = q.polarity * qbc
+}
@@ -0,0 +1,178 @@
+package mna
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/edp1096/sparse"
+)
+
+// Circuit is a list of devices on named nodes and branches
+type Circuit struct {
+	Nodes   *sparse.NodeMap
+	Devices []Device
+	Config  sparse.Configuration // Configuration of the matrices, Real and Complex being set by NewMatrix
+	States  int                  // Number of charges and fluxes of the reactive devices
+
+	names  map[string]Device
+	matrix *sparse.Matrix    // Matrix of the last Setup
+	shunts []*sparse.Element // Diagonals of the nodes, taken on the first load with Context.Shunt
+}
+
+func NewCircuit() *Circuit {
+	return &Circuit{
+		Nodes: sparse.NewNodeMap(nil),
+		Config: sparse.Configuration{
+			Expandable:    true,
+			Translate:     true,
+			ModifiedNodal: true,
+			PartialFactor: true,
+			MonitorPivots: true,
+		},
+		names: make(map[string]Device),
+	}
+}
+
+// Node returns the external index of a node
+func (c *Circuit) Node(name string) int64 {
+	return c.Nodes.Node(name)
+}
+
+// Branch returns the external index of the branch current of a device, named I(device)
+func (c *Circuit) Branch(device string) int64 {
+	return c.Nodes.Node("I(" + device + ")")
+}
+
+// Add appends devices to the circuit
+func (c *Circuit) Add(devices ...Device) error {
+	for _, device := range devices {
+		if _, ok := c.names[device.Name()]; ok {
+			return fmt.Errorf("duplicate device: %s", device.Name())
+		}
+		c.names[device.Name()] = device
+		if internal, ok := device.(Internal); ok {
+			prefix := device.Name() + "#"
+			internal.SetInternal(func(name string) int64 { return c.Node(prefix + name) })
+		}
+		c.Devices = append(c.Devices, device)
+	}
+	c.matrix = nil
+	return nil
+}
+
+// Device returns the device of a name, nil if there is none
+func (c *Circuit) Device(name string) Device {
+	return c.names[name]
+}
+
+// NewMatrix creates a real or complex matrix for the circuit and sets the devices up on it
+func (c *Circuit) NewMatrix(complex bool) (*sparse.Matrix, error) {
+	config := c.Config
+	config.Real = !complex
+	config.Complex = complex
+	config.SeparatedComplexVectors = false
+
+	m, err := sparse.Create(0, &config)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Setup(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Setup takes the elements of every device on m and numbers the states
+func (c *Circuit) Setup(m *sparse.Matrix) error {
+	c.Nodes.Matrix = m
+	c.States = 0
+	c.shunts = nil
+	for _, device := range c.Devices {
+		if err := device.Setup(m); err != nil {
+			return fmt.Errorf("%s: %v", device.Name(), err)
+		}
+		if reactive, ok := device.(Reactive); ok {
+			reactive.SetStateBase(c.States)
+			c.States += reactive.StateCount()
+		}
+	}
+	c.matrix = m
+	return nil
+}
+
+// Load clears m, loads every device for ctx and returns the rhs
+func (c *Circuit) Load(m *sparse.Matrix, ctx *Context) ([]float64, error) {
+	if c.matrix != m {
+		if err := c.Setup(m); err != nil {
+			return nil, err
+		}
+	}
+	c.Nodes.Matrix = m
+
+	m.Clear()
+	size := max(c.Nodes.Size(), m.GetSize(true)) + 1 // 1-based indexing
+	if m.Complex {
+		size *= 2
+	}
+	rhs := make([]float64, size)
+	for _, device := range c.Devices {
+		if err := device.Load(m, rhs, ctx); err != nil {
+			return nil, fmt.Errorf("%s: %v", device.Name(), err)
+		}
+	}
+	if ctx.Shunt != 0.0 && !m.Complex {
+		c.loadShunts(m, rhs, ctx)
+	}
+	return rhs, nil
+}
+
+// loadShunts adds Context.Shunt from every node to ground, or to its voltage in Context.ShuntTarget
+func (c *Circuit) loadShunts(m *sparse.Matrix, rhs []float64, ctx *Context) {
+	if c.shunts == nil {
+		c.shunts = make([]*sparse.Element, c.Nodes.Size()+1) // 1-based indexing
+		for i := int64(1); i <= c.Nodes.Size(); i++ {
+			if !c.IsBranch(i) {
+				c.shunts[i] = m.GetElement(i, i)
+			}
+		}
+	}
+
+	for i, element := range c.shunts {
+		if element == nil {
+			continue
+		}
+		m.AddToElement(element, ctx.Shunt, 0.0)
+		if ctx.ShuntTarget != nil {
+			rhs[i] += ctx.Shunt * ctx.ShuntTarget[i]
+		}
+	}
+}
+
+// IsBranch reports whether an index is a branch current rather than a node voltage
+func (c *Circuit) IsBranch(index int64) bool {
+	return strings.HasPrefix(c.Nodes.Name(index), "I(")
+}
+
+// Solve loads, factors and solves m once for ctx, interleaved for a complex matrix. Nonlinear devices
+// are linearized at Context.Solution, see Newton.
+func (c *Circuit) Solve(m *sparse.Matrix, ctx *Context) ([]float64, error) {
+	rhs, err := c.Load(m, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Factor(); err != nil {
+		return nil, err
+	}
+	return m.Solve(rhs)
+}
+
+// StateVector returns the charges and fluxes of the reactive devices at solution
+func (c *Circuit) StateVector(solution []float64) []float64 {
+	states := make([]float64, c.States)
+	for _, device := range c.Devices {
+		if reactive, ok := device.(Reactive); ok {
+			reactive.States(solution, states)
+		}
+	}
+	return states
+}
@@ -0,0 +1,218 @@
+package mna
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/edp1096/sparse"
+)
+
+// Resistor between Pos and Neg
+type Resistor struct {
+	name       string
+	Pos, Neg   int64
+	Resistance float64
+	Temp       float64 // °C, for its thermal noise
+
+	template sparse.Template
+}
+
+func NewResistor(name string, pos, neg int64, resistance float64) *Resistor {
+	return &Resistor{name: name, Pos: pos, Neg: neg, Resistance: resistance, Temp: TNOM}
+}
+
+func (r *Resistor) Name() string { return r.name }
+
+func (r *Resistor) Setup(m *sparse.Matrix) error {
+	if r.Resistance == 0.0 {
+		return fmt.Errorf("zero resistance")
+	}
+	return m.GetAdmittance(r.Pos, r.Neg, &r.template)
+}
+
+func (r *Resistor) Load(m *sparse.Matrix, rhs []float64, ctx *Context) error {
+	r.template.AddRealQuad(1.0 / r.Resistance)
+	return nil
+}
+
+// Capacitor between Pos and Neg, its charge being integrated in transient
+type Capacitor struct {
+	name        string
+	Pos, Neg    int64
+	Capacitance float64
+
+	template sparse.Template
+	base     int
+}
+
+func NewCapacitor(name string, pos, neg int64, capacitance float64) *Capacitor {
+	return &Capacitor{name: name, Pos: pos, Neg: neg, Capacitance: capacitance}
+}
+
+func (c *Capacitor) Name() string { return c.name }
+
+func (c *Capacitor) Setup(m *sparse.Matrix) error {
+	return m.GetAdmittance(c.Pos, c.Neg, &c.template)
+}
+
+func (c *Capacitor) Load(m *sparse.Matrix, rhs []float64, ctx *Context) error {
+	switch ctx.Mode {
+	case TRANSIENT:
+		// i = coeff q + history, q = C v
+		coeff, history := ctx.Integrate(c.base)
+		c.template.AddRealQuad(coeff * c.Capacitance)
+		addRHS(rhs, c.Pos, -history)
+		addRHS(rhs, c.Neg, history)
+	case AC:
+		addQuad(&c.template, ctx.S*complex(c.Capacitance, 0.0))
+	}
+	return nil
+}
+
+func (c *Capacitor) StateCount() int { return 1 }
+
+func (c *Capacitor) SetStateBase(base int) { c.base = base }
+
+func (c *Capacitor) States(solution []float64, states []float64) {
+	states[c.base] = c.Capacitance * (voltage(solution, c.Pos) - voltage(solution, c.Neg))
+}
+
+// Inductor between Pos and Neg with its current as the Branch unknown, its flux being integrated in transient
+type Inductor struct {
+	name       string
+	Pos, Neg   int64
+	Branch     int64
+	Inductance float64
+
+	ones    sparse.Template
+	diag    *sparse.Element
+	base    int
+	mutuals []*Mutual
+}
+
+func NewInductor(name string, pos, neg, branch int64, inductance float64) *Inductor {
+	return &Inductor{name: name, Pos: pos, Neg: neg, Branch: branch, Inductance: inductance}
+}
+
+func (l *Inductor) Name() string { return l.name }
+
+func (l *Inductor) Setup(m *sparse.Matrix) error {
+	l.diag = m.GetElement(l.Branch, l.Branch)
+	return m.GetOnes(l.Pos, l.Neg, l.Branch, &l.ones)
+}
+
+func (l *Inductor) Load(m *sparse.Matrix, rhs []float64, ctx *Context) error {
+	// v = dφ/dt
+	l.ones.AddRealQuad(1.0)
+
+	switch ctx.Mode {
+	case TRANSIENT:
+		coeff, history := ctx.Integrate(l.base)
+		m.AddToElement(l.diag, -coeff*l.Inductance, 0.0)
+		addRHS(rhs, l.Branch, history)
+	case AC:
+		addComplex(m, l.diag, -ctx.S*complex(l.Inductance, 0.0))
+	}
+	return nil
+}
+
+func (l *Inductor) StateCount() int { return 1 }
+
+func (l *Inductor) SetStateBase(base int) { l.base = base }
+
+func (l *Inductor) States(solution []float64, states []float64) {
+	flux := l.Inductance * voltage(solution, l.Branch)
+	for _, mutual := range l.mutuals {
+		other := mutual.Inductor1
+		if other == l {
+			other = mutual.Inductor2
+		}
+		flux += mutual.Inductance() * voltage(solution, other.Branch)
+	}
+	states[l.base] = flux
+}
+
+// Mutual couples two inductors, whose fluxes include the mutual inductance Coupling·sqrt(L1·L2)
+type Mutual struct {
+	name                 string
+	Inductor1, Inductor2 *Inductor
+	Coupling             float64
+
+	element12, element21 *sparse.Element
+}
+
+func NewMutual(name string, inductor1, inductor2 *Inductor, coupling float64) *Mutual {
+	mutual := &Mutual{name: name, Inductor1: inductor1, Inductor2: inductor2, Coupling: coupling}
+	inductor1.mutuals = append(inductor1.mutuals, mutual)
+	inductor2.mutuals = append(inductor2.mutuals, mutual)
+	return mutual
+}
+
+func (k *Mutual) Name() string { return k.name }
+
+// Inductance returns the mutual inductance
+func (k *Mutual) Inductance() float64 {
+	return k.Coupling * math.Sqrt(k.Inductor1.Inductance*k.Inductor2.Inductance)
+}
+
+func (k *Mutual) Setup(m *sparse.Matrix) error {
+	if k.Coupling < -1.0 || k.Coupling > 1.0 {
+		return fmt.Errorf("coupling %g out of [-1, 1]", k.Coupling)
+	}
+	k.element12 = m.GetElement(k.Inductor1.Branch, k.Inductor2.Branch)
+	k.element21 = m.GetElement(k.Inductor2.Branch, k.Inductor1.Branch)
+	return nil
+}
+
+func (k *Mutual) Load(m *sparse.Matrix, rhs []float64, ctx *Context) error {
+	switch ctx.Mode {
+	case TRANSIENT:
+		// Histories are in the fluxes of the inductors
+		coeff, _ := ctx.Integrate(k.Inductor1.base)
+		m.AddToElement(k.element12, -coeff*k.Inductance(), 0.0)
+		m.AddToElement(k.element21, -coeff*k.Inductance(), 0.0)
+	case AC:
+		addComplex(m, k.element12, -ctx.S*complex(k.Inductance(), 0.0))
+		addComplex(m, k.element21, -ctx.S*complex(k.Inductance(), 0.0))
+	}
+	return nil
+}
+
+// Transformer is an ideal transformer, V(Pos1,Neg1) = Ratio·V(Pos2,Neg2), with the primary current as the Branch unknown
+type Transformer struct {
+	name       string
+	Pos1, Neg1 int64
+	Pos2, Neg2 int64
+	Branch     int64
+	Ratio      float64
+
+	primary, secondary sparse.Template
+}
+
+func NewTransformer(name string, pos1, neg1, pos2, neg2, branch int64, ratio float64) *Transformer {
+	return &Transformer{name: name, Pos1: pos1, Neg1: neg1, Pos2: pos2, Neg2: neg2, Branch: branch, Ratio: ratio}
+}
+
+func (t *Transformer) Name() string { return t.name }
+
+func (t *Transformer) Setup(m *sparse.Matrix) error {
+	if err := m.GetOnes(t.Pos1, t.Neg1, t.Branch, &t.primary); err != nil {
+		return err
+	}
+	return m.GetOnes(t.Pos2, t.Neg2, t.Branch, &t.secondary)
+}
+
+func (t *Transformer) Load(m *sparse.Matrix, rhs []float64, ctx *Context) error {
+	// Secondary current -Ratio·i
+	t.primary.AddRealQuad(1.0)
+	t.secondary.AddRealQuad(-t.Ratio)
+	return nil
+}
+
+// voltage returns the value of index in solution, 0 for ground
+func voltage(solution []float64, index int64) float64 {
+	if index == 0 {
+		return 0.0
+	}
+	return solution[index]
+}
@@ -0,0 +1,163 @@
+package mna
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/edp1096/sparse"
+)
+
+// DiodeModel is the model card of a junction diode, as SPICE level 1
+type DiodeModel struct {
+	IS   float64 // Saturation current
+	N    float64 // Emission coefficient
+	RS   float64 // Series resistance
+	CJO  float64 // Zero-bias junction capacitance
+	VJ   float64 // Junction potential
+	M    float64 // Grading coefficient
+	FC   float64 // Forward-bias depletion capacitance coefficient
+	TT   float64 // Transit time
+	BV   float64 // Reverse breakdown voltage, +Inf for none
+	IBV  float64 // Current at BV
+	EG   float64 // Band gap, eV
+	XTI  float64 // Saturation current temperature exponent
+	KF   float64 // Flicker noise coefficient
+	AF   float64 // Flicker noise exponent
+	TNOM float64
+}
+
+func NewDiodeModel() *DiodeModel {
+	return &DiodeModel{IS: 1e-14, N: 1.0, VJ: 1.0, M: 0.5, FC: 0.5, BV: math.Inf(1), IBV: 1e-3, EG: 1.11, XTI: 3.0, AF: 1.0, TNOM: TNOM}
+}
+
+func (m *DiodeModel) Parameters() map[string]*float64 {
+	return map[string]*float64{
+		"is": &m.IS, "n": &m.N, "rs": &m.RS,
+		"cjo": &m.CJO, "cj0": &m.CJO, "vj": &m.VJ, "m": &m.M, "fc": &m.FC, "tt": &m.TT,
+		"bv": &m.BV, "ibv": &m.IBV, "eg": &m.EG, "xti": &m.XTI, "kf": &m.KF, "af": &m.AF, "tnom": &m.TNOM,
+	}
+}
+
+// Diode from Pos (anode) to Neg (cathode), with a junction charge integrated in transient
+type Diode struct {
+	name     string
+	Pos, Neg int64
+	Model    *DiodeModel
+	Area     float64
+	Temp     float64 // °C
+
+	rs resistance
+
+	// At Temp, scaled by Area
+	nvt, is, ibv, vcrit float64
+	cj, vj              float64
+
+	junction sparse.Template
+	base     int
+
+	vd, id, gd float64 // Last linearization
+}
+
+func NewDiode(name string, pos, neg int64, model *DiodeModel, area float64) *Diode {
+	d := &Diode{name: name, Pos: pos, Neg: neg, Model: model, Area: area, Temp: TNOM}
+	d.rs.set(pos, 0.0, nil)
+	return d
+}
+
+func (d *Diode) Name() string { return d.name }
+
+func (d *Diode) SetInternal(node func(name string) int64) {
+	d.rs.set(d.Pos, d.Model.RS/d.Area, func() int64 { return node("a") })
+}
+
+func (d *Diode) Setup(m *sparse.Matrix) error {
+	model := d.Model
+	if d.Area <= 0.0 {
+		return fmt.Errorf("area %g not positive", d.Area)
+	}
+
+	vt := thermalVoltage(d.Temp)
+	d.nvt = model.N * vt
+	d.is = saturationTemp(model.IS, model.EG, model.XTI, model.N, model.TNOM, d.Temp) * d.Area
+	d.ibv = model.IBV * d.Area
+	d.vcrit = CriticalVoltage(d.is, d.nvt)
+	d.vj, d.cj = junctionTemp(model.VJ, model.CJO, model.M, model.TNOM, d.Temp)
+	d.cj *= d.Area
+
+	if err := d.rs.setup(m); err != nil {
+		return err
+	}
+	return m.GetAdmittance(d.rs.internal, d.Neg, &d.junction)
+}
+
+// current returns the junction current and conductance at vd, breakdown included
+func (d *Diode) current(vd, gmin float64) (float64, float64) {
+	id, gd := junction(vd, d.is, d.nvt)
+	if !math.IsInf(d.Model.BV, 1) {
+		e := math.Exp(-(d.Model.BV + vd) / d.nvt)
+		id -= d.ibv * e
+		gd += d.ibv * e / d.nvt
+	}
+	return id + gmin*vd, gd + gmin
+}
+
+// charge returns the depletion and diffusion charge and capacitance at vd
+func (d *Diode) charge(vd, id, gd float64) (float64, float64) {
+	q, c := depletion(vd, d.cj, d.vj, d.Model.M, d.Model.FC)
+	return q + d.Model.TT*id, c + d.Model.TT*gd
+}
+
+func (d *Diode) voltage(solution []float64) float64 {
+	return voltage(solution, d.rs.internal) - voltage(solution, d.Neg)
+}
+
+func (d *Diode) Load(m *sparse.Matrix, rhs []float64, ctx *Context) error {
+	d.rs.load()
+
+	vd := d.voltage(ctx.Solution)
+	if ctx.Mode == AC {
+		id, gd := d.current(vd, ctx.Gmin)
+		_, c := d.charge(vd, id, gd)
+		addQuad(&d.junction, complex(gd, 0.0))
+		loadCharge(&d.junction, rhs, d.rs.internal, d.Neg, d.base, 0.0, c, vd, ctx)
+		return nil
+	}
+
+	switch {
+	case ctx.Initial:
+		vd = d.vcrit
+	case !math.IsInf(d.Model.BV, 1) && vd < min(0.0, -d.Model.BV+10.0*d.nvt):
+		// Limited as the voltage beyond breakdown
+		vd = -d.Model.BV - ctx.limit(-(vd+d.Model.BV), -(d.vd+d.Model.BV), d.nvt, d.vcrit)
+	default:
+		vd = ctx.limit(vd, d.vd, d.nvt, d.vcrit)
+	}
+
+	id, gd := d.current(vd, ctx.Gmin)
+	d.vd, d.id, d.gd = vd, id, gd
+
+	d.junction.AddRealQuad(gd)
+	loadCurrent(rhs, d.rs.internal, d.Neg, id, gd*vd)
+
+	if ctx.Mode == TRANSIENT {
+		q, c := d.charge(vd, id, gd)
+		loadCharge(&d.junction, rhs, d.rs.internal, d.Neg, d.base, q, c, vd, ctx)
+	}
+	return nil
+}
+
+func (d *Diode) Converged(ctx *Context) bool {
+	vd := d.voltage(ctx.Solution)
+	id, _ := d.current(vd, ctx.Gmin)
+	return ctx.currentConverged(d.id+d.gd*(vd-d.vd), id)
+}
+
+func (d *Diode) StateCount() int { return 1 }
+
+func (d *Diode) SetStateBase(base int) { d.base = base }
+
+func (d *Diode) States(solution []float64, states []float64) {
+	vd := d.voltage(solution)
+	id, gd := d.current(vd, 0.0)
+	states[d.base], _ = d.charge(vd, id, gd)
+}
@@ -0,0 +1,100 @@
+package mna
+
+import "math"
+
+// Junction limiting. Newton steps on exponential junctions are cut as in SPICE, so that a large
+// change of the solution does not overflow the junction current. The devices count the limited
+// voltages in Context.Limited, which keeps the iterations going.
+
+// CriticalVoltage returns the junction voltage above which changes are limited, for a saturation
+// current is and thermal voltage vt
+func CriticalVoltage(is, vt float64) float64 {
+	return vt * math.Log(vt/(math.Sqrt2*is))
+}
+
+// LimitJunction limits a new junction voltage against the previous one, as SPICE pnjlim
+func LimitJunction(vnew, vold, vt, vcrit float64) (float64, bool) {
+	if vnew <= vcrit || math.Abs(vnew-vold) <= 2.0*vt {
+		return vnew, false
+	}
+	if vold <= 0.0 {
+		return vt * math.Log(vnew/vt), true
+	}
+
+	arg := 1.0 + (vnew-vold)/vt
+	if arg <= 0.0 {
+		return vcrit, true
+	}
+	return vold + vt*math.Log(arg), true
+}
+
+// LimitFET limits a new gate-source voltage against the previous one, for threshold vto, as SPICE fetlim
+func LimitFET(vnew, vold, vto float64) (float64, bool) {
+	vtsthi := math.Abs(2.0*(vold-vto)) + 2.0
+	vtstlo := vtsthi/2.0 + 2.0
+	vtox := vto + 3.5
+	delv := vnew - vold
+	limited := vnew
+
+	if vold >= vto {
+		if vold >= vtox {
+			switch {
+			case delv <= 0.0:
+				// Going off
+				if vnew >= vtox {
+					if -delv > vtstlo {
+						limited = vold - vtstlo
+					}
+				} else {
+					limited = max(vnew, vto+2.0)
+				}
+			case delv >= vtsthi:
+				limited = vold + vtsthi
+			}
+		} else {
+			// Middle region
+			if delv <= 0.0 {
+				limited = max(vnew, vto-0.5)
+			} else {
+				limited = min(vnew, vto+4.0)
+			}
+		}
+	} else {
+		// Off
+		if delv <= 0.0 {
+			if -delv > vtsthi {
+				limited = vold - vtsthi
+			}
+		} else {
+			vtemp := vto + 0.5
+			if vnew <= vtemp {
+				if delv > vtstlo {
+					limited = vold + vtstlo
+				}
+			} else {
+				limited = vtemp
+			}
+		}
+	}
+
+	return limited, limited != vnew
+}
+
+// LimitDrain limits a new drain-source voltage against the previous one, as SPICE limvds
+func LimitDrain(vnew, vold float64) (float64, bool) {
+	limited := vnew
+	if vold >= 3.5 {
+		if vnew > vold {
+			limited = min(vnew, 3.0*vold+2.0)
+		} else if vnew < 3.5 {
+			limited = max(vnew, 2.0)
+		}
+	} else {
+		if vnew > vold {
+			limited = min(vnew, 4.0)
+		} else {
+			limited = max(vnew, -0.5)
+		}
+	}
+	return limited, limited != vnew
+}
@@ -0,0 +1,119 @@
+// Package mna stamps circuit devices into a sparse matrix by modified nodal analysis.
+//
+// Unknowns are node voltages and branch currents, named in the NodeMap of a Circuit and
+// numbered as external indexes of the matrix. Node 0 is ground. Devices take their Templates and
+// elements in Setup and add their companion model for the analysis described by a Context in Load.
+// Real matrices are used for DC and transient, complex ones for AC, with interleaved vectors.
+package mna
+
+import "github.com/edp1096/sparse"
+
+type Mode int
+
+const (
+	DC        Mode = iota // Operating point, capacitors open and inductors short
+	TRANSIENT             // Time step, reactive devices replaced by their integration companion model
+	AC                    // Small-signal at the Laplace variable S, around the operating point
+)
+
+type Method int
+
+const (
+	BDF         Method = iota // Backward differentiation (Gear), orders 1 to 6
+	TRAPEZOIDAL               // Trapezoidal, order 2, order 1 being backward Euler
+)
+
+// Device is a circuit element. Load stamps through the templates or Matrix.AddToElement, not the
+// element fields: with PartialFactor the elements hold the factors between loads.
+type Device interface {
+	Name() string
+	Setup(m *sparse.Matrix) error                             // Take the elements of the device
+	Load(m *sparse.Matrix, rhs []float64, ctx *Context) error // Add the stamps of the device, the matrix being cleared
+}
+
+// Reactive is a device with charges or fluxes integrated in transient
+type Reactive interface {
+	Device
+	StateCount() int                             // Number of charges and fluxes
+	SetStateBase(base int)                       // Index of the first state in Context
+	States(solution []float64, states []float64) // Charges and fluxes at solution, written from the state base
+}
+
+// Context describes the analysis a device loads for
+type Context struct {
+	Mode Mode
+
+	Solution []float64  // Latest real solution by external index, the operating point in AC
+	Time     float64    // Time of the point being computed in transient
+	S        complex128 // Laplace variable in AC, jω at angular frequency ω
+
+	Inputs map[string]complex128 // AC values of the sources by name, replacing their own when not nil
+
+	// Integration - TRANSIENT mode. dq/dt = Coeffs[0] q + Σ Coeffs[j] States[j] + DerivativeCoeff Derivs[1]
+	Method          Method
+	Order           int
+	Coeffs          []float64   // [0...Order]
+	DerivativeCoeff float64     // Weight of the previous derivative, trapezoidal only
+	States          [][]float64 // Charges and fluxes of the accepted points, States[j] j points back
+	Derivs          [][]float64 // Derivatives of the states of the accepted points
+
+	SourceScale float64 // Scale of the independent sources, below 1 during source stepping
+	Gmin        float64 // Conductance added across junctions
+
+	// Newton iterations
+	Iteration   int       // 0 on the first load
+	Initial     bool      // First load without an initial guess, junctions starting from their own initial voltages
+	Limited     int       // Junction voltages limited by the devices in the last load
+	Shunt       float64   // Conductance from every node, during gmin stepping and pseudo-transient
+	ShuntTarget []float64 // Voltages the shunts pull to, ground when nil
+	RelTol      float64   // Tolerances of the convergence tests of the devices
+	VoltTol     float64
+	AbsTol      float64
+}
+
+// NewContext returns a context for mode with full sources
+func NewContext(mode Mode) *Context {
+	return &Context{Mode: mode, SourceScale: 1.0}
+}
+
+// Integrate returns the companion model of the derivative of a state, dq/dt = coeff q + history.
+// Outside transient both are 0, leaving capacitors open and inductors short.
+func (ctx *Context) Integrate(state int) (coeff, history float64) {
+	if ctx.Mode != TRANSIENT || len(ctx.Coeffs) == 0 {
+		return 0.0, 0.0
+	}
+
+	for j := 1; j < len(ctx.Coeffs); j++ {
+		history += ctx.Coeffs[j] * ctx.States[j][state]
+	}
+	if ctx.DerivativeCoeff != 0.0 {
+		history += ctx.DerivativeCoeff * ctx.Derivs[1][state]
+	}
+
+	return ctx.Coeffs[0], history
+}
+
+// addRHS adds value to the rhs of index, ground excluded
+func addRHS(rhs []float64, index int64, value float64) {
+	if index != 0 {
+		rhs[index] += value
+	}
+}
+
+// addComplexRHS adds a complex value to the interleaved rhs of index, ground excluded
+func addComplexRHS(rhs []float64, index int64, value complex128) {
+	if index != 0 {
+		rhs[2*index] += real(value)
+		rhs[2*index+1] += imag(value)
+	}
+}
+
+// addComplex adds value to an element of m
+func addComplex(m *sparse.Matrix, element *sparse.Element, value complex128) {
+	m.AddToElement(element, real(value), imag(value))
+}
+
+// addQuad adds value to a template, complex in AC
+func addQuad(template *sparse.Template, value complex128) {
+	template.AddComplexQuad(real(value), imag(value))
+}
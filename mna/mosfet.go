@@ -0,0 +1,363 @@
+package mna
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/edp1096/sparse"
+)
+
+// MOSFETModel is the model card of a level 1 MOSFET, the Shichman-Hodges model of SPICE. Gate
+// capacitances are the overlaps, plus two thirds of the oxide capacitance to the source with TOX,
+// as in saturation.
+type MOSFETModel struct {
+	PMOS bool
+
+	VTO    float64 // Zero-bias threshold voltage
+	KP     float64 // Transconductance, from U0 and TOX when 0
+	GAMMA  float64 // Bulk threshold
+	PHI    float64 // Surface potential
+	LAMBDA float64 // Channel length modulation
+	RD, RS float64 // Drain and source resistances
+
+	CBD, CBS float64 // Zero-bias bulk junction capacitances, from CJ and the areas when 0
+	IS       float64 // Bulk junction saturation current
+	PB       float64 // Bulk junction potential
+	CJ, MJ   float64 // Bulk junction capacitance per area and grading coefficient
+	FC       float64
+
+	CGSO, CGDO, CGBO float64 // Overlap capacitances per width, and per length for CGBO
+	TOX              float64 // Oxide thickness, 0 for none
+	U0               float64 // Surface mobility, cm²/Vs
+	KF, AF           float64 // Flicker noise coefficient and exponent, without flicker noise when TOX is 0
+	TNOM             float64
+}
+
+func NewMOSFETModel(pmos bool) *MOSFETModel {
+	return &MOSFETModel{PMOS: pmos, PHI: 0.6, IS: 1e-14, PB: 0.8, MJ: 0.5, FC: 0.5, U0: 600.0, AF: 1.0, TNOM: TNOM}
+}
+
+func (m *MOSFETModel) Parameters() map[string]*float64 {
+	return map[string]*float64{
+		"vto": &m.VTO, "vt0": &m.VTO, "kp": &m.KP, "gamma": &m.GAMMA, "phi": &m.PHI, "lambda": &m.LAMBDA,
+		"rd": &m.RD, "rs": &m.RS, "cbd": &m.CBD, "cbs": &m.CBS, "is": &m.IS, "pb": &m.PB,
+		"cj": &m.CJ, "mj": &m.MJ, "fc": &m.FC, "cgso": &m.CGSO, "cgdo": &m.CGDO, "cgbo": &m.CGBO,
+		"tox": &m.TOX, "u0": &m.U0, "uo": &m.U0, "kf": &m.KF, "af": &m.AF, "tnom": &m.TNOM,
+	}
+}
+
+// MOSFET between Drain, Gate, Source and Bulk, with the gate and bulk junction charges integrated in transient
+type MOSFET struct {
+	name                      string
+	Drain, Gate, Source, Bulk int64
+	Model                     *MOSFETModel
+	L, W                      float64 // Channel length and width
+	AD, AS                    float64 // Drain and source areas
+	Temp                      float64 // °C
+
+	rd, rs resistance
+
+	// At Temp, in NMOS polarity
+	polarity           float64 // 1 for NMOS, -1 for PMOS
+	vt, vto, beta, phi float64
+	is, vcrit, pb      float64
+	cbd, cbs           float64
+	cgs, cgd, cgb      float64
+
+	gm, gds, gmbs sparse.Template // Drain to source current, controlled by vgs, vds and vbs
+	bd, bs        sparse.Template // Bulk junctions
+	gs, gd, gb    sparse.Template // Gate capacitances
+	base          int
+
+	last mosPoint // Last linearization
+}
+
+// mosPoint is the linearization at vgs, vds and vbs, in NMOS polarity
+type mosPoint struct {
+	vgs, vds, vbs float64
+	von           float64
+	id            float64 // Drain to source current
+	gm, gds, gmbs float64 // Its derivatives by vgs, vds and vbs
+	ibd, gbd      float64 // Bulk junction currents
+	ibs, gbs      float64
+}
+
+func NewMOSFET(name string, drain, gate, source, bulk int64, model *MOSFETModel, l, w float64) *MOSFET {
+	f := &MOSFET{name: name, Drain: drain, Gate: gate, Source: source, Bulk: bulk, Model: model, L: l, W: w, Temp: TNOM}
+	f.rd.set(drain, 0.0, nil)
+	f.rs.set(source, 0.0, nil)
+	return f
+}
+
+func (f *MOSFET) Name() string { return f.name }
+
+func (f *MOSFET) SetInternal(node func(name string) int64) {
+	f.rd.set(f.Drain, f.Model.RD, func() int64 { return node("d") })
+	f.rs.set(f.Source, f.Model.RS, func() int64 { return node("s") })
+}
+
+func (f *MOSFET) Setup(m *sparse.Matrix) error {
+	model := f.Model
+	if f.L <= 0.0 || f.W <= 0.0 {
+		return fmt.Errorf("channel %g x %g not positive", f.W, f.L)
+	}
+
+	f.polarity = 1.0
+	if model.PMOS {
+		f.polarity = -1.0
+	}
+
+	cox := 0.0
+	if model.TOX > 0.0 {
+		cox = EPSOX / model.TOX
+	}
+	kp := model.KP
+	if kp == 0.0 {
+		kp = 2e-5
+		if cox != 0.0 {
+			kp = model.U0 * 1e-4 * cox
+		}
+	}
+
+	// Temperature, as SPICE mos1temp
+	t, t0 := f.Temp+KELVIN, model.TNOM+KELVIN
+	ratio := t / t0
+	f.vt = thermalVoltage(f.Temp)
+	f.beta = kp * math.Pow(ratio, -1.5) * f.W / f.L
+	f.phi, _ = junctionTemp(model.PHI, 0.0, 0.0, model.TNOM, f.Temp)
+	vbi := model.VTO - f.polarity*model.GAMMA*math.Sqrt(model.PHI) + 0.5*(energyGap(t0)-energyGap(t)) + f.polarity*0.5*(f.phi-model.PHI)
+	f.vto = f.polarity * (vbi + f.polarity*model.GAMMA*math.Sqrt(f.phi))
+	f.is = model.IS * math.Exp(-energyGap(t)/f.vt+energyGap(t0)/thermalVoltage(model.TNOM))
+	f.vcrit = CriticalVoltage(f.is, f.vt)
+
+	cbd, cbs := model.CBD, model.CBS
+	if cbd == 0.0 {
+		cbd = model.CJ * f.AD
+	}
+	if cbs == 0.0 {
+		cbs = model.CJ * f.AS
+	}
+	f.pb, f.cbd = junctionTemp(model.PB, cbd, model.MJ, model.TNOM, f.Temp)
+	_, f.cbs = junctionTemp(model.PB, cbs, model.MJ, model.TNOM, f.Temp)
+
+	f.cgs = model.CGSO*f.W + 2.0/3.0*cox*f.W*f.L
+	f.cgd = model.CGDO * f.W
+	f.cgb = model.CGBO * f.L
+
+	for _, r := range []*resistance{&f.rd, &f.rs} {
+		if err := r.setup(m); err != nil {
+			return err
+		}
+	}
+
+	d, g, s, b := f.rd.internal, f.Gate, f.rs.internal, f.Bulk
+	for _, quad := range []struct {
+		template               *sparse.Template
+		row1, row2, col1, col2 int64
+	}{
+		{&f.gm, d, s, g, s}, {&f.gds, d, s, d, s}, {&f.gmbs, d, s, b, s},
+		{&f.bd, b, d, b, d}, {&f.bs, b, s, b, s},
+		{&f.gs, g, s, g, s}, {&f.gd, g, d, g, d}, {&f.gb, g, b, g, b},
+	} {
+		if err := m.GetQuad(quad.row1, quad.row2, quad.col1, quad.col2, quad.template); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drain returns the drain current and its derivatives by vgs, vds and vbs for vds ≥ 0, and the threshold
+func (f *MOSFET) drain(vgs, vds, vbs float64) (id, gm, gds, gmbs, von float64) {
+	model := f.Model
+
+	sarg := math.Sqrt(f.phi)
+	if vbs <= 0.0 {
+		sarg = math.Sqrt(f.phi - vbs)
+	} else {
+		sarg = max(sarg-vbs/(sarg+sarg), 0.0)
+	}
+	von = f.vto + model.GAMMA*(sarg-math.Sqrt(f.phi))
+	vgst := vgs - von
+	if vgst <= 0.0 {
+		return 0.0, 0.0, 0.0, 0.0, von
+	}
+
+	arg := 0.0
+	if sarg > 0.0 {
+		arg = model.GAMMA / (sarg + sarg)
+	}
+	betap := f.beta * (1.0 + model.LAMBDA*vds)
+	if vgst <= vds {
+		// Saturation
+		id = betap * vgst * vgst / 2.0
+		gm = betap * vgst
+		gds = model.LAMBDA * f.beta * vgst * vgst / 2.0
+	} else {
+		// Linear
+		id = betap * vds * (vgst - vds/2.0)
+		gm = betap * vds
+		gds = betap*(vgst-vds) + model.LAMBDA*f.beta*vds*(vgst-vds/2.0)
+	}
+	return id, gm, gds, gm * arg, von
+}
+
+// evaluate returns the currents and conductances at vgs, vds and vbs, swapping drain and source when vds < 0
+func (f *MOSFET) evaluate(vgs, vds, vbs, gmin float64) mosPoint {
+	p := mosPoint{vgs: vgs, vds: vds, vbs: vbs}
+
+	if vds >= 0.0 {
+		p.id, p.gm, p.gds, p.gmbs, p.von = f.drain(vgs, vds, vbs)
+	} else {
+		// Reversed, i(vgs, vds, vbs) = -id(vgs-vds, -vds, vbs-vds)
+		id, gm, gds, gmbs, von := f.drain(vgs-vds, -vds, vbs-vds)
+		p.id, p.gm, p.gds, p.gmbs, p.von = -id, -gm, gm+gds+gmbs, -gmbs, von
+	}
+
+	p.ibd, p.gbd = junction(vbs-vds, f.is, f.vt)
+	p.ibd += gmin * (vbs - vds)
+	p.gbd += gmin
+	p.ibs, p.gbs = junction(vbs, f.is, f.vt)
+	p.ibs += gmin * vbs
+	p.gbs += gmin
+	return p
+}
+
+// voltages returns vgs, vds and vbs at solution in NMOS polarity
+func (f *MOSFET) voltages(solution []float64) (float64, float64, float64) {
+	vs := voltage(solution, f.rs.internal)
+	return f.polarity * (voltage(solution, f.Gate) - vs),
+		f.polarity * (voltage(solution, f.rd.internal) - vs),
+		f.polarity * (voltage(solution, f.Bulk) - vs)
+}
+
+// limit limits the voltages against the last linearization, as SPICE mos1load
+func (f *MOSFET) limit(vgs, vds, vbs float64, ctx *Context) (float64, float64, float64) {
+	l := f.last
+	vgd, vgdOld := vgs-vds, l.vgs-l.vds
+	limited := false
+	var ok bool
+
+	if l.vds >= 0.0 {
+		if vgs, ok = LimitFET(vgs, l.vgs, l.von); ok {
+			limited = true
+		}
+		vds = vgs - vgd
+		if vds, ok = LimitDrain(vds, l.vds); ok {
+			limited = true
+		}
+	} else {
+		if vgd, ok = LimitFET(vgd, vgdOld, l.von); ok {
+			limited = true
+		}
+		vds = vgs - vgd
+		if vds, ok = LimitDrain(-vds, -l.vds); ok {
+			limited = true
+		}
+		vds = -vds
+		vgs = vgd + vds
+	}
+	if limited {
+		ctx.Limited++
+	}
+
+	if vds >= 0.0 {
+		vbs = ctx.limit(vbs, l.vbs, f.vt, f.vcrit)
+	} else {
+		vbd := ctx.limit(vbs-vds, l.vbs-l.vds, f.vt, f.vcrit)
+		vbs = vbd + vds
+	}
+	return vgs, vds, vbs
+}
+
+// charges returns the bulk junction charges and capacitances at p
+func (f *MOSFET) charges(p mosPoint) (qbd, cbd, qbs, cbs float64) {
+	model := f.Model
+	qbd, cbd = depletion(p.vbs-p.vds, f.cbd, f.pb, model.MJ, model.FC)
+	qbs, cbs = depletion(p.vbs, f.cbs, f.pb, model.MJ, model.FC)
+	return qbd, cbd, qbs, cbs
+}
+
+func (f *MOSFET) Load(m *sparse.Matrix, rhs []float64, ctx *Context) error {
+	f.rd.load()
+	f.rs.load()
+
+	d, s, b := f.rd.internal, f.rs.internal, f.Bulk
+	vgs, vds, vbs := f.voltages(ctx.Solution)
+	x := f.polarity
+
+	if ctx.Mode == AC {
+		p := f.evaluate(vgs, vds, vbs, ctx.Gmin)
+		_, cbd, _, cbs := f.charges(p)
+		f.gm.AddRealQuad(p.gm)
+		f.gds.AddRealQuad(p.gds)
+		f.gmbs.AddRealQuad(p.gmbs)
+		f.bd.AddRealQuad(p.gbd)
+		f.bs.AddRealQuad(p.gbs)
+		loadCharge(&f.bd, rhs, b, d, f.base, 0.0, cbd, 0.0, ctx)
+		loadCharge(&f.bs, rhs, b, s, f.base+1, 0.0, cbs, 0.0, ctx)
+		f.loadGate(rhs, ctx)
+		return nil
+	}
+
+	if ctx.Initial {
+		vgs, vds, vbs = f.vto, 0.0, -1.0
+	} else {
+		vgs, vds, vbs = f.limit(vgs, vds, vbs, ctx)
+	}
+	p := f.evaluate(vgs, vds, vbs, ctx.Gmin)
+	f.last = p
+
+	f.gm.AddRealQuad(p.gm)
+	f.gds.AddRealQuad(p.gds)
+	f.gmbs.AddRealQuad(p.gmbs)
+	loadCurrent(rhs, d, s, x*p.id, x*(p.gm*vgs+p.gds*vds+p.gmbs*vbs))
+	f.bd.AddRealQuad(p.gbd)
+	loadCurrent(rhs, b, d, x*p.ibd, x*p.gbd*(vbs-vds))
+	f.bs.AddRealQuad(p.gbs)
+	loadCurrent(rhs, b, s, x*p.ibs, x*p.gbs*vbs)
+
+	if ctx.Mode == TRANSIENT {
+		qbd, cbd, qbs, cbs := f.charges(p)
+		loadCharge(&f.bd, rhs, b, d, f.base, x*qbd, cbd, x*(vbs-vds), ctx)
+		loadCharge(&f.bs, rhs, b, s, f.base+1, x*qbs, cbs, x*vbs, ctx)
+		f.loadGate(rhs, ctx)
+	}
+	return nil
+}
+
+// loadGate adds the linear gate capacitances
+func (f *MOSFET) loadGate(rhs []float64, ctx *Context) {
+	d, g, s, b := f.rd.internal, f.Gate, f.rs.internal, f.Bulk
+	v := func(pos, neg int64) float64 { return voltage(ctx.Solution, pos) - voltage(ctx.Solution, neg) }
+
+	loadCharge(&f.gs, rhs, g, s, f.base+2, f.cgs*v(g, s), f.cgs, v(g, s), ctx)
+	loadCharge(&f.gd, rhs, g, d, f.base+3, f.cgd*v(g, d), f.cgd, v(g, d), ctx)
+	loadCharge(&f.gb, rhs, g, b, f.base+4, f.cgb*v(g, b), f.cgb, v(g, b), ctx)
+}
+
+func (f *MOSFET) Converged(ctx *Context) bool {
+	vgs, vds, vbs := f.voltages(ctx.Solution)
+	p := f.evaluate(vgs, vds, vbs, ctx.Gmin)
+	l := f.last
+	dgs, dds, dbs := vgs-l.vgs, vds-l.vds, vbs-l.vbs
+	return ctx.currentConverged(l.id+l.gm*dgs+l.gds*dds+l.gmbs*dbs, p.id) &&
+		ctx.currentConverged(l.ibd+l.gbd*(dbs-dds), p.ibd) &&
+		ctx.currentConverged(l.ibs+l.gbs*dbs, p.ibs)
+}
+
+func (f *MOSFET) StateCount() int { return 5 }
+
+func (f *MOSFET) SetStateBase(base int) { f.base = base }
+
+func (f *MOSFET) States(solution []float64, states []float64) {
+	vgs, vds, vbs := f.voltages(solution)
+	qbd, _, qbs, _ := f.charges(mosPoint{vgs: vgs, vds: vds, vbs: vbs})
+	d, g, s, b := f.rd.internal, f.Gate, f.rs.internal, f.Bulk
+	v := func(pos, neg int64) float64 { return voltage(solution, pos) - voltage(solution, neg) }
+
+	states[f.base] = f.polarity * qbd
+	states[f.base+1] = f.polarity * qbs
+	states[f.base+2] = f.cgs * v(g, s)
+	states[f.base+3] = f.cgd * v(g, d)
+	states[f.base+4] = f.cgb * v(g, b)
+}
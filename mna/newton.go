@@ -0,0 +1,302 @@
+package mna
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/edp1096/sparse"
+)
+
+// Newton-Raphson. Nonlinear devices load their companion model linearized at Context.Solution, the
+// circuit is solved again from the new solution until the solution and the device currents settle.
+// Each iteration is Load, Factor and Solve on the same matrix, so the pivot order of the first one is
+// reused until MonitorPivots finds it unstable, and PartialFactor only refactors the columns whose
+// stamped values changed, as those of the nonlinear devices.
+
+// Nonlinear is a device linearized at Context.Solution in Load
+type Nonlinear interface {
+	Device
+	Converged(ctx *Context) bool // Whether the currents at Context.Solution match those of the last linearization
+}
+
+// Strategy is the method that found an operating point
+type Strategy int
+
+const (
+	NEWTON           Strategy = iota // Plain Newton iterations
+	GMIN_STEPPING                    // Conductances from every node to ground, decreased to zero
+	SOURCE_STEPPING                  // Independent sources raised from zero to full value
+	PSEUDO_TRANSIENT                 // Conductances to the previous solution, as capacitors at growing time steps
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case NEWTON:
+		return "Newton"
+	case GMIN_STEPPING:
+		return "gmin stepping"
+	case SOURCE_STEPPING:
+		return "source stepping"
+	case PSEUDO_TRANSIENT:
+		return "pseudo-transient"
+	}
+	return fmt.Sprintf("Strategy(%d)", int(s))
+}
+
+// NewtonOptions are the settings of the Newton iterations and of the continuation fallbacks
+type NewtonOptions struct {
+	MaxIterations int // Per solve. Default: 100
+
+	RelTol  float64 // Default: 1e-3
+	VoltTol float64 // Node voltages. Default: 1e-6
+	AbsTol  float64 // Branch and device currents. Default: 1e-12
+	Gmin    float64 // Across junctions. Default: 1e-12
+
+	MaxSteps  int     // Continuation steps of each fallback. Default: 100
+	GminStart float64 // First conductance of gmin stepping. Default: 1e-2
+	Pseudo    float64 // First conductance of pseudo-transient continuation. Default: 1
+
+	NoFallback bool // Fail when plain Newton does
+}
+
+// OperatingPoint is the result of a nonlinear DC solve
+type OperatingPoint struct {
+	Solution   []float64
+	Strategy   Strategy
+	Iterations int // Newton iterations of all the attempts
+	Reorders   int // Factor calls that fell back to a new pivot order
+}
+
+func (o NewtonOptions) defaults() NewtonOptions {
+	if o.MaxIterations <= 0 {
+		o.MaxIterations = 100
+	}
+	if o.RelTol <= 0.0 {
+		o.RelTol = 1e-3
+	}
+	if o.VoltTol <= 0.0 {
+		o.VoltTol = 1e-6
+	}
+	if o.AbsTol <= 0.0 {
+		o.AbsTol = 1e-12
+	}
+	if o.Gmin <= 0.0 {
+		o.Gmin = 1e-12
+	}
+	if o.MaxSteps <= 0 {
+		o.MaxSteps = 100
+	}
+	if o.GminStart <= 0.0 {
+		o.GminStart = 1e-2
+	}
+	if o.Pseudo <= 0.0 {
+		o.Pseudo = 1.0
+	}
+	return o
+}
+
+// Newton iterates from x, zero when nil, until convergence and returns the solution and the number of iterations
+func (c *Circuit) Newton(m *sparse.Matrix, ctx *Context, x []float64, options NewtonOptions) ([]float64, int, error) {
+	o := options.defaults()
+	ctx.RelTol, ctx.VoltTol, ctx.AbsTol, ctx.Gmin = o.RelTol, o.VoltTol, o.AbsTol, o.Gmin
+
+	initial := x == nil
+	if initial {
+		x = make([]float64, c.Nodes.Size()+1) // 1-based indexing
+	}
+	linear := !c.Nonlinear()
+
+	for iteration := 0; iteration < o.MaxIterations; iteration++ {
+		ctx.Solution = x
+		ctx.Iteration = iteration
+		ctx.Initial = initial && iteration == 0
+		ctx.Limited = 0
+
+		xn, err := c.Solve(m, ctx)
+		if err != nil {
+			return x, iteration + 1, err
+		}
+		if linear {
+			return xn, iteration + 1, nil
+		}
+
+		if !ctx.Initial && ctx.Limited == 0 && c.converged(x, xn, ctx) {
+			return xn, iteration + 1, nil
+		}
+		x = xn
+	}
+
+	ctx.Initial = false
+	return x, o.MaxIterations, fmt.Errorf("no convergence in %d iterations", o.MaxIterations)
+}
+
+// Nonlinear reports whether a device of the circuit is nonlinear
+func (c *Circuit) Nonlinear() bool {
+	for _, device := range c.Devices {
+		if _, ok := device.(Nonlinear); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// converged tests the change of each unknown from x to xn, then the currents of the nonlinear devices at xn
+func (c *Circuit) converged(x, xn []float64, ctx *Context) bool {
+	for i := int64(1); i <= c.Nodes.Size(); i++ {
+		tol := ctx.VoltTol
+		if c.IsBranch(i) {
+			tol = ctx.AbsTol
+		}
+		tol += ctx.RelTol * max(math.Abs(x[i]), math.Abs(xn[i]))
+		if math.Abs(xn[i]-x[i]) > tol {
+			return false
+		}
+	}
+
+	ctx.Solution = xn
+	for _, device := range c.Devices {
+		if device, ok := device.(Nonlinear); ok && !device.Converged(ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+// OperatingPoint solves the DC operating point on m, or on a new real matrix when m is nil, for ctx,
+// a new DC context when nil. Plain Newton is tried first, then gmin stepping, source stepping and
+// pseudo-transient continuation.
+func (c *Circuit) OperatingPoint(m *sparse.Matrix, ctx *Context, options NewtonOptions) (*OperatingPoint, error) {
+	var err error
+	if m == nil {
+		if m, err = c.NewMatrix(false); err != nil {
+			return nil, err
+		}
+	}
+	if ctx == nil {
+		ctx = NewContext(DC)
+	}
+	o := options.defaults()
+
+	op := &OperatingPoint{}
+	reorders := m.FallbackReorders
+	defer func() { op.Reorders = m.FallbackReorders - reorders }()
+
+	steps := []func(*sparse.Matrix, *Context, NewtonOptions, *OperatingPoint) ([]float64, error){
+		c.plainNewton, c.gminStepping, c.sourceStepping, c.pseudoTransient,
+	}
+	if o.NoFallback {
+		steps = steps[:1]
+	}
+
+	var errs []error
+	for strategy, step := range steps {
+		ctx.Shunt, ctx.ShuntTarget, ctx.SourceScale = 0.0, nil, 1.0
+		x, err := step(m, ctx, o, op)
+		if err == nil {
+			op.Solution = x
+			op.Strategy = Strategy(strategy)
+			return op, nil
+		}
+		errs = append(errs, fmt.Errorf("%v: %v", Strategy(strategy), err))
+	}
+	ctx.Shunt, ctx.ShuntTarget, ctx.SourceScale = 0.0, nil, 1.0
+
+	return op, fmt.Errorf("no operating point: %v", errs)
+}
+
+// newton runs Newton for a step of a continuation and counts its iterations
+func (c *Circuit) newton(m *sparse.Matrix, ctx *Context, x []float64, o NewtonOptions, op *OperatingPoint) ([]float64, error) {
+	xn, iterations, err := c.Newton(m, ctx, x, o)
+	op.Iterations += iterations
+	return xn, err
+}
+
+func (c *Circuit) plainNewton(m *sparse.Matrix, ctx *Context, o NewtonOptions, op *OperatingPoint) ([]float64, error) {
+	return c.newton(m, ctx, nil, o, op)
+}
+
+// gminStepping lowers the shunt conductance by up to a decade per step, by less after a failure
+func (c *Circuit) gminStepping(m *sparse.Matrix, ctx *Context, o NewtonOptions, op *OperatingPoint) ([]float64, error) {
+	ctx.Shunt = o.GminStart
+	x, err := c.newton(m, ctx, nil, o, op)
+	if err != nil {
+		return nil, err
+	}
+
+	factor := 10.0
+	shunt := ctx.Shunt
+	for range o.MaxSteps {
+		if shunt <= o.Gmin {
+			ctx.Shunt = 0.0
+			return c.newton(m, ctx, x, o, op)
+		}
+
+		ctx.Shunt = max(shunt/factor, o.Gmin)
+		xn, err := c.newton(m, ctx, x, o, op)
+		if err != nil {
+			if factor = math.Sqrt(factor); factor < 1.00005 {
+				return nil, fmt.Errorf("stuck at %g", shunt)
+			}
+			continue
+		}
+
+		x, shunt = xn, ctx.Shunt
+		factor = min(factor*math.Sqrt(factor), 10.0)
+	}
+	return nil, fmt.Errorf("more than %d steps", o.MaxSteps)
+}
+
+// sourceStepping raises the sources from zero, by steps doubled after a success and cut after a failure
+func (c *Circuit) sourceStepping(m *sparse.Matrix, ctx *Context, o NewtonOptions, op *OperatingPoint) ([]float64, error) {
+	ctx.SourceScale = 0.0
+	x, err := c.newton(m, ctx, nil, o, op)
+	if err != nil {
+		return nil, err
+	}
+
+	scale, step := 0.0, 0.1
+	for range o.MaxSteps {
+		ctx.SourceScale = min(scale+step, 1.0)
+		xn, err := c.newton(m, ctx, x, o, op)
+		if err != nil {
+			if step /= 4.0; step < 1e-7 {
+				return nil, fmt.Errorf("stuck at source scale %g", scale)
+			}
+			continue
+		}
+
+		x, scale = xn, ctx.SourceScale
+		if scale == 1.0 {
+			return x, nil
+		}
+		step = min(2.0*step, 0.5)
+	}
+	return nil, fmt.Errorf("more than %d steps", o.MaxSteps)
+}
+
+// pseudoTransient pulls every node to its previous value through a conductance, as a unit capacitor
+// would at a step of 1/conductance, growing the step after a success and cutting it after a failure
+func (c *Circuit) pseudoTransient(m *sparse.Matrix, ctx *Context, o NewtonOptions, op *OperatingPoint) ([]float64, error) {
+	x := make([]float64, c.Nodes.Size()+1) // 1-based indexing
+	shunt := o.Pseudo
+
+	for range o.MaxSteps {
+		if shunt < o.Gmin {
+			ctx.Shunt, ctx.ShuntTarget = 0.0, nil
+			return c.newton(m, ctx, x, o, op)
+		}
+
+		ctx.Shunt, ctx.ShuntTarget = shunt, x
+		xn, err := c.newton(m, ctx, x, o, op)
+		if err != nil {
+			if shunt *= 8.0; shunt > 1e12 {
+				return nil, fmt.Errorf("stuck at conductance %g", shunt)
+			}
+			continue
+		}
+
+		x = xn
+		shunt /= 4.0
+	}
+	return nil, fmt.Errorf("more than %d steps", o.MaxSteps)
+}
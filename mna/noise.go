@@ -0,0 +1,361 @@
+package mna
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/edp1096/sparse"
+)
+
+// Small-signal noise analysis. Every noise source is a current between two nodes, uncorrelated with the
+// others, so the output noise is Σ |Z|²·S over the sources, Z being the transimpedance from the source to
+// the output. All the Z at one frequency are the entries of one adjoint solution Aᵀ λ = c, c selecting the
+// output, so each frequency costs one factorization and one transposed solve whatever the number of
+// sources. The gain from the input source, λᵀ b, refers the output noise to the input.
+
+// NoiseSource is a noise current between Pos and Neg of power spectral density Density, A²/Hz
+type NoiseSource struct {
+	Name     string // Kind of noise, as "thermal", "shot" or "flicker"
+	Pos, Neg int64
+	Density  float64
+}
+
+// Noisy is a device with noise sources at the operating point in Context.Solution
+type Noisy interface {
+	Device
+	Noise(ctx *Context, frequency float64) []NoiseSource
+}
+
+// NoiseOptions are the settings of a noise analysis
+type NoiseOptions struct {
+	ACOptions        // Sweep, operating point and output. Inputs and Probes are unused
+	Output    string // Probe without ratio, as V(out), V(out,ref) or I(vsense)
+	Input     string // Source the noise is referred to, no input noise when empty
+}
+
+// NoiseResult holds the noise densities over the sweep, in V²/Hz or A²/Hz, and their integrals over
+// the sweep by the trapezoidal rule, in V or A RMS
+type NoiseResult struct {
+	Frequencies   []float64
+	Output        Probe
+	Input         string
+	OutputDensity []float64
+	InputDensity  []float64    // Output density divided by |Gain|², nil without input
+	Gain          []complex128 // From the input source to the output, nil without input
+
+	Devices       []string    // Noisy devices
+	Contributions [][]float64 // [device][point], output density of each device
+
+	OutputTotal  float64
+	InputTotal   float64
+	DeviceTotals []float64 // Output noise of each device
+}
+
+// thermal returns the thermal noise current density of a conductance at temp, 4kT·g
+func thermal(temp, g float64) float64 {
+	return 4.0 * BOLTZMANN * (temp + KELVIN) * g
+}
+
+// shot returns the shot noise current density of a current, 2q·|i|
+func shot(i float64) float64 {
+	return 2.0 * CHARGE * math.Abs(i)
+}
+
+// flicker returns the flicker noise current density kf·|i|^af/f
+func flicker(kf, af, i, frequency float64) float64 {
+	if kf == 0.0 || frequency <= 0.0 {
+		return 0.0
+	}
+	return kf * math.Pow(math.Abs(i), af) / frequency
+}
+
+// noise returns the thermal noise of the series resistance, none when it is absent
+func (r *resistance) noise(name string, temp float64) []NoiseSource {
+	if r.conductance == 0.0 {
+		return nil
+	}
+	return []NoiseSource{{Name: name, Pos: r.node, Neg: r.internal, Density: thermal(temp, r.conductance)}}
+}
+
+// Noise runs a noise analysis on m, a complex matrix of the circuit, or on a new one when m is nil
+func (c *Circuit) Noise(m *sparse.Matrix, options NoiseOptions) (*NoiseResult, error) {
+	frequencies, err := options.Frequencies()
+	if err != nil {
+		return nil, err
+	}
+	probe, err := c.Probe(options.Output)
+	if err != nil {
+		return nil, err
+	}
+	if probe.Ratio {
+		return nil, fmt.Errorf("ratio output: %s", options.Output)
+	}
+
+	// The rhs of a load is the input source alone, at 1
+	ctx := NewContext(AC)
+	ctx.Inputs = map[string]complex128{}
+	if options.Input != "" {
+		if ctx.Inputs, err = c.inputs([]string{options.Input}); err != nil {
+			return nil, err
+		}
+		ctx.Inputs[options.Input] = 1.0
+	}
+
+	x := options.Solution
+	if x == nil {
+		op, err := c.OperatingPoint(nil, NewContext(DC), options.Newton)
+		if err != nil {
+			return nil, fmt.Errorf("operating point: %v", err)
+		}
+		x = op.Solution
+	}
+	ctx.Solution = x
+	ctx.Gmin = options.Newton.defaults().Gmin
+
+	if m == nil {
+		if m, err = c.NewMatrix(true); err != nil {
+			return nil, err
+		}
+		defer m.Destroy()
+	}
+	if !m.Complex {
+		return nil, fmt.Errorf("matrix must be complex")
+	}
+
+	var noisy []Noisy
+	for _, device := range c.Devices {
+		if device, ok := device.(Noisy); ok {
+			noisy = append(noisy, device)
+		}
+	}
+
+	result := &NoiseResult{
+		Frequencies:   frequencies,
+		Output:        probe,
+		Input:         options.Input,
+		OutputDensity: make([]float64, len(frequencies)),
+		Devices:       make([]string, len(noisy)),
+		Contributions: make([][]float64, len(noisy)),
+	}
+	for i, device := range noisy {
+		result.Devices[i] = device.Name()
+		result.Contributions[i] = make([]float64, len(frequencies))
+	}
+	if options.Input != "" {
+		result.InputDensity = make([]float64, len(frequencies))
+		result.Gain = make([]complex128, len(frequencies))
+	}
+
+	for point, f := range frequencies {
+		ctx.S = complex(0.0, 2.0*math.Pi*f)
+		rhs, err := c.Load(m, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.Factor(); err != nil {
+			return nil, fmt.Errorf("frequency %g: %v", f, err)
+		}
+
+		selector := make([]float64, len(rhs))
+		if probe.Pos != 0 {
+			selector[2*probe.Pos] = 1.0
+		}
+		if probe.Neg != 0 {
+			selector[2*probe.Neg] = -1.0
+		}
+		adjoint, _, err := m.SolveComplexTransposed(selector, make([]float64, len(selector)))
+		if err != nil {
+			return nil, fmt.Errorf("frequency %g: %v", f, err)
+		}
+
+		total := 0.0
+		for i, device := range noisy {
+			density := 0.0
+			for _, source := range device.Noise(ctx, f) {
+				z := phasor(adjoint, source.Pos) - phasor(adjoint, source.Neg)
+				density += (real(z)*real(z) + imag(z)*imag(z)) * source.Density
+			}
+			result.Contributions[i][point] = density
+			total += density
+		}
+		result.OutputDensity[point] = total
+
+		if options.Input != "" {
+			gain := complex128(0.0)
+			for i := int64(1); 2*i+1 < int64(len(rhs)); i++ {
+				gain += phasor(adjoint, i) * phasor(rhs, i)
+			}
+			result.Gain[point] = gain
+			magnitude := real(gain)*real(gain) + imag(gain)*imag(gain)
+			if magnitude == 0.0 {
+				return nil, fmt.Errorf("frequency %g: no gain from %s to %s", f, options.Input, options.Output)
+			}
+			result.InputDensity[point] = total / magnitude
+		}
+	}
+
+	result.OutputTotal = integrate(frequencies, result.OutputDensity)
+	if result.InputDensity != nil {
+		result.InputTotal = integrate(frequencies, result.InputDensity)
+	}
+	result.DeviceTotals = make([]float64, len(noisy))
+	for i, contribution := range result.Contributions {
+		result.DeviceTotals[i] = integrate(frequencies, contribution)
+	}
+
+	if options.Writer != nil {
+		if err := result.Write(options.Writer, options.Format); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// integrate returns the square root of the integral of density over frequencies, by the trapezoidal rule
+func integrate(frequencies, density []float64) float64 {
+	sum := 0.0
+	for i := 1; i < len(frequencies); i++ {
+		sum += (density[i] + density[i-1]) / 2.0 * (frequencies[i] - frequencies[i-1])
+	}
+	return math.Sqrt(sum)
+}
+
+// Write writes the result in format
+func (r *NoiseResult) Write(w io.Writer, format Format) error {
+	switch format {
+	case CSV:
+		return r.WriteCSV(w)
+	case JSON:
+		return r.WriteJSON(w)
+	}
+	return fmt.Errorf("unknown format: %d", format)
+}
+
+// WriteCSV writes a header, then a row per frequency with the output noise, the input noise when there
+// is an input, and the output noise of every device, as spectral densities per √Hz
+func (r *NoiseResult) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+
+	header := []string{"frequency", "onoise"}
+	columns := [][]float64{r.OutputDensity}
+	if r.InputDensity != nil {
+		header = append(header, "inoise")
+		columns = append(columns, r.InputDensity)
+	}
+	for i, name := range r.Devices {
+		header = append(header, name)
+		columns = append(columns, r.Contributions[i])
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for point, f := range r.Frequencies {
+		row := []string{strconv.FormatFloat(f, 'e', 9, 64)}
+		for _, column := range columns {
+			row = append(row, strconv.FormatFloat(math.Sqrt(column[point]), 'e', 9, 64))
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+type noiseDeviceJSON struct {
+	Name    string     `json:"name"`
+	Density []*float64 `json:"density"`
+	Total   float64    `json:"total"`
+}
+
+type noiseJSON struct {
+	Frequencies   []float64         `json:"frequencies"`
+	Output        string            `json:"output"`
+	Input         string            `json:"input,omitempty"`
+	OutputDensity []*float64        `json:"output_density"`
+	InputDensity  []*float64        `json:"input_density,omitempty"`
+	OutputTotal   float64           `json:"output_total"`
+	InputTotal    float64           `json:"input_total,omitempty"`
+	Devices       []noiseDeviceJSON `json:"devices"`
+}
+
+// WriteJSON writes the frequencies, the output and input noise and the output noise of every device,
+// as spectral densities per √Hz, with their integrals
+func (r *NoiseResult) WriteJSON(w io.Writer) error {
+	out := noiseJSON{
+		Frequencies:   r.Frequencies,
+		Output:        r.Output.Name,
+		Input:         r.Input,
+		OutputDensity: finite(root(r.OutputDensity)),
+		OutputTotal:   r.OutputTotal,
+		InputTotal:    r.InputTotal,
+		Devices:       make([]noiseDeviceJSON, len(r.Devices)),
+	}
+	if r.InputDensity != nil {
+		out.InputDensity = finite(root(r.InputDensity))
+	}
+	for i, name := range r.Devices {
+		out.Devices[i] = noiseDeviceJSON{Name: name, Density: finite(root(r.Contributions[i])), Total: r.DeviceTotals[i]}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// root returns the square roots of densities
+func root(densities []float64) []float64 {
+	roots := make([]float64, len(densities))
+	for i, density := range densities {
+		roots[i] = math.Sqrt(density)
+	}
+	return roots
+}
+
+func (r *Resistor) Noise(ctx *Context, frequency float64) []NoiseSource {
+	return []NoiseSource{{Name: "thermal", Pos: r.Pos, Neg: r.Neg, Density: thermal(r.Temp, 1.0/math.Abs(r.Resistance))}}
+}
+
+func (d *Diode) Noise(ctx *Context, frequency float64) []NoiseSource {
+	id, _ := d.current(d.voltage(ctx.Solution), 0.0)
+	return append(d.rs.noise("rs", d.Temp),
+		NoiseSource{Name: "shot", Pos: d.rs.internal, Neg: d.Neg, Density: shot(id)},
+		NoiseSource{Name: "flicker", Pos: d.rs.internal, Neg: d.Neg, Density: flicker(d.Model.KF, d.Model.AF, id, frequency)},
+	)
+}
+
+func (q *BJT) Noise(ctx *Context, frequency float64) []NoiseSource {
+	vbe, vbc := q.voltages(ctx.Solution)
+	p := q.evaluate(vbe, vbc, 0.0)
+	ic, ib := p.it-p.ibc, p.ibe+p.ibc
+	c, b, e := q.rc.internal, q.rb.internal, q.re.internal
+
+	sources := append(q.rc.noise("rc", q.Temp), q.rb.noise("rb", q.Temp)...)
+	return append(append(sources, q.re.noise("re", q.Temp)...),
+		NoiseSource{Name: "collector shot", Pos: c, Neg: e, Density: shot(ic)},
+		NoiseSource{Name: "base shot", Pos: b, Neg: e, Density: shot(ib)},
+		NoiseSource{Name: "flicker", Pos: b, Neg: e, Density: flicker(q.Model.KF, q.Model.AF, ib, frequency)},
+	)
+}
+
+func (f *MOSFET) Noise(ctx *Context, frequency float64) []NoiseSource {
+	model := f.Model
+	vgs, vds, vbs := f.voltages(ctx.Solution)
+	p := f.evaluate(vgs, vds, vbs, 0.0)
+	d, s := f.rd.internal, f.rs.internal
+
+	sources := append(f.rd.noise("rd", f.Temp), f.rs.noise("rs", f.Temp)...)
+	sources = append(sources, NoiseSource{Name: "thermal", Pos: d, Neg: s, Density: thermal(f.Temp, 2.0/3.0*math.Abs(p.gm))})
+	if model.TOX > 0.0 {
+		cox := EPSOX / model.TOX
+		density := flicker(model.KF, model.AF, p.id, frequency) / (cox * f.L * f.L)
+		sources = append(sources, NoiseSource{Name: "flicker", Pos: d, Neg: s, Density: density})
+	}
+	return sources
+}
@@ -0,0 +1,339 @@
+package mna
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+
+	"github.com/edp1096/sparse"
+)
+
+// Pole-zero analysis, as the .pz of SPICE. The poles are the roots of det(G + sC), the matrix of the
+// circuit in AC at the complex frequency s. The zeros of the transfer function cᵀ(G + sC)⁻¹b from an
+// input b to an output c are the roots of the determinant of the bordered matrix
+//   | G + sC  b |
+//   |   cᵀ    0 |
+// equal to -det(G + sC)·cᵀ(G + sC)⁻¹b. Both are found one by one with Muller's method, each determinant
+// coming from FactorComplex and Determinant as a mantissa and an exponent, and the roots already found
+// being deflated from it. The roots of the real polynomials come in conjugate pairs.
+
+// PoleZeroOptions are the settings of a pole-zero analysis
+type PoleZeroOptions struct {
+	Input  string // Voltage or current source
+	Output string // Probe without ratio, as V(out), V(out,ref) or I(vsense)
+
+	Solution []float64     // Operating point, solved with Newton when nil
+	Newton   NewtonOptions // For the operating point
+
+	Scale         float64 // Typical root magnitude in rad/s, from the norms of G and C when 0
+	RelTol        float64 // Relative change of a root at convergence, 1e-12 when 0
+	MaxIterations int     // Per root, 100 when 0
+}
+
+// PoleZeroResult holds the poles and zeros in rad/s, sorted by magnitude
+type PoleZeroResult struct {
+	Input  string
+	Output Probe
+	Poles  []complex128
+	Zeros  []complex128
+}
+
+// determinant is a complex value mantissa·10^exponent, as returned by Determinant
+type determinant struct {
+	mantissa complex128
+	exponent int
+}
+
+// normalize scales the mantissa to a magnitude in [1, 10)
+func (d determinant) normalize() determinant {
+	magnitude := cmplx.Abs(d.mantissa)
+	if magnitude == 0.0 || math.IsInf(magnitude, 0) || math.IsNaN(magnitude) {
+		return d
+	}
+	shift := int(math.Floor(math.Log10(magnitude)))
+	d.mantissa *= complex(math.Pow(10.0, float64(-shift)), 0.0)
+	d.exponent += shift
+	return d
+}
+
+// scaled returns the values with a common exponent, the largest one
+func scaled(values ...determinant) []complex128 {
+	exponent := math.MinInt
+	for _, value := range values {
+		if value.mantissa != 0.0 {
+			exponent = max(exponent, value.exponent)
+		}
+	}
+	out := make([]complex128, len(values))
+	for i, value := range values {
+		if value.mantissa != 0.0 {
+			out[i] = value.mantissa * complex(math.Pow(10.0, float64(value.exponent-exponent)), 0.0)
+		}
+	}
+	return out
+}
+
+// rootFinder evaluates a determinant of s on a complex matrix, with the roots found deflated
+type rootFinder struct {
+	c      *Circuit
+	m      *sparse.Matrix
+	ctx    *Context
+	border func() // Adds the border of the zeros after the load, nil for the poles
+	roots  []complex128
+
+	scale, relTol float64
+	maxIterations int
+}
+
+// determinant returns det(s), 0 when the matrix is singular
+func (f *rootFinder) determinant(s complex128) (determinant, error) {
+	load := func() error {
+		f.ctx.S = s
+		if _, err := f.c.Load(f.m, f.ctx); err != nil {
+			return err
+		}
+		if f.border != nil {
+			f.border()
+		}
+		return nil
+	}
+	if err := load(); err != nil {
+		return determinant{}, err
+	}
+
+	// The pivot order of the first factorization is kept while its pivots hold
+	var err error
+	if f.m.NeedsOrdering {
+		err = f.m.OrderAndFactor(nil, 0.0, -1.0, true)
+	} else if err = f.m.FactorComplex(); err != nil {
+		if err = load(); err != nil {
+			return determinant{}, err
+		}
+		err = f.m.OrderAndFactor(nil, 0.0, -1.0, true)
+	}
+	if err != nil {
+		if f.m.SingularRow > 0 || f.m.SingularCol > 0 {
+			return determinant{}, nil
+		}
+		return determinant{}, err
+	}
+
+	mantissa, exponent, imag := f.m.Determinant()
+	value := determinant{mantissa: complex(mantissa, 0.0), exponent: exponent}
+	if imag != nil {
+		value.mantissa = complex(mantissa, *imag)
+	}
+	return value, nil
+}
+
+// deflated returns det(s)/Π(s - root) over the roots found
+func (f *rootFinder) deflated(s complex128) (determinant, error) {
+	value, err := f.determinant(s)
+	if err != nil {
+		return value, err
+	}
+	for _, root := range f.roots {
+		value.mantissa /= s - root
+		value = value.normalize()
+	}
+	return value, nil
+}
+
+// find returns the roots up to count, stopping at the first one Muller's method does not converge to
+func (f *rootFinder) find(count int) ([]complex128, error) {
+	for len(f.roots) < count {
+		root, ok, err := f.muller()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		// Parts below the accuracy of the root are rounding
+		accuracy := 1e3 * f.relTol * cmplx.Abs(root)
+		if math.Abs(real(root)) <= accuracy {
+			root = complex(0.0, imag(root))
+		}
+		if math.Abs(imag(root)) <= accuracy {
+			f.roots = append(f.roots, complex(real(root), 0.0))
+			continue
+		}
+		f.roots = append(f.roots, root, cmplx.Conj(root))
+	}
+
+	sort.Slice(f.roots, func(i, j int) bool {
+		if a, b := cmplx.Abs(f.roots[i]), cmplx.Abs(f.roots[j]); a != b {
+			return a < b
+		}
+		return imag(f.roots[i]) < imag(f.roots[j])
+	})
+	return f.roots, nil
+}
+
+// muller returns the next root of the deflated determinant, ok being false without convergence
+func (f *rootFinder) muller() (root complex128, ok bool, err error) {
+	const LIMIT = 1e12 // Largest root magnitude, relative to the scale
+
+	x := [3]complex128{complex(-0.5*f.scale, 0.0), complex(-1.5*f.scale, 0.0), complex(-f.scale, 0.0)}
+	var values [3]determinant
+	for i := range x {
+		x[i] = f.avoid(x[i])
+		if values[i], err = f.deflated(x[i]); err != nil {
+			return 0.0, false, err
+		}
+		if values[i].mantissa == 0.0 {
+			return x[i], true, nil
+		}
+	}
+
+	for iteration := 0; iteration < f.maxIterations; iteration++ {
+		y := scaled(values[:]...)
+		h1, h2 := x[1]-x[0], x[2]-x[1]
+		d1, d2 := (y[1]-y[0])/h1, (y[2]-y[1])/h2
+		a := (d2 - d1) / (h1 + h2)
+		b := a*h2 + d2
+		discriminant := cmplx.Sqrt(b*b - 4.0*a*y[2])
+		denominator := b + discriminant
+		if cmplx.Abs(b-discriminant) > cmplx.Abs(denominator) {
+			denominator = b - discriminant
+		}
+		if denominator == 0.0 {
+			return 0.0, false, nil
+		}
+
+		// Diverging to a root at infinity
+		next := f.avoid(x[2] - 2.0*y[2]/denominator)
+		if cmplx.IsNaN(next) || cmplx.Abs(next) > LIMIT*f.scale {
+			return 0.0, false, nil
+		}
+		value, err := f.deflated(next)
+		if err != nil {
+			return 0.0, false, err
+		}
+		step := cmplx.Abs(next - x[2])
+		x[0], x[1], x[2] = x[1], x[2], next
+		values[0], values[1], values[2] = values[1], values[2], value
+
+		if value.mantissa == 0.0 || step <= f.relTol*cmplx.Abs(next) {
+			return next, true, nil
+		}
+	}
+	return 0.0, false, nil
+}
+
+// avoid moves s off the roots found, where the deflated determinant is not defined
+func (f *rootFinder) avoid(s complex128) complex128 {
+	for _, root := range f.roots {
+		if s == root {
+			return s + complex(f.relTol*math.Max(cmplx.Abs(s), f.scale), 0.0)
+		}
+	}
+	return s
+}
+
+// PoleZero finds the poles of the circuit and the zeros of the transfer function from options.Input to
+// options.Output, linearized at the operating point. Roots beyond the reach of Muller's method from
+// options.Scale, as those at infinity, are not reported.
+func (c *Circuit) PoleZero(options PoleZeroOptions) (*PoleZeroResult, error) {
+	probe, err := c.Probe(options.Output)
+	if err != nil {
+		return nil, err
+	}
+	if probe.Ratio {
+		return nil, fmt.Errorf("ratio output: %s", options.Output)
+	}
+	pos, neg, _, err := c.excitation(options.Input)
+	if err != nil {
+		return nil, err
+	}
+
+	x := options.Solution
+	if x == nil {
+		op, err := c.OperatingPoint(nil, NewContext(DC), options.Newton)
+		if err != nil {
+			return nil, fmt.Errorf("operating point: %v", err)
+		}
+		x = op.Solution
+	}
+	ctx := NewContext(AC)
+	ctx.Inputs = map[string]complex128{}
+	ctx.Solution = x
+	ctx.Gmin = options.Newton.defaults().Gmin
+
+	if options.RelTol <= 0.0 {
+		options.RelTol = 1e-12
+	}
+	if options.MaxIterations <= 0 {
+		options.MaxIterations = 100
+	}
+
+	// Poles
+	m, err := c.NewMatrix(true)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Destroy()
+	if options.Scale <= 0.0 {
+		if options.Scale, err = c.rootScale(m, ctx); err != nil {
+			return nil, err
+		}
+	}
+	count := c.States // Degree of det(G + sC)
+
+	poles := &rootFinder{c: c, m: m, ctx: ctx, scale: options.Scale, relTol: options.RelTol, maxIterations: options.MaxIterations}
+	result := &PoleZeroResult{Input: options.Input, Output: probe}
+	if result.Poles, err = poles.find(count); err != nil {
+		return nil, fmt.Errorf("poles: %v", err)
+	}
+
+	// Zeros, on the matrix bordered by the input and the output at an extra row and column
+	bordered, err := c.NewMatrix(true)
+	if err != nil {
+		return nil, err
+	}
+	defer bordered.Destroy()
+	extra := c.Nodes.Size() + 1
+	var border []*sparse.Element
+	var signs []float64
+	for _, entry := range []struct {
+		row, col int64
+		sign     float64
+	}{
+		{pos, extra, 1.0}, {neg, extra, -1.0}, {extra, probe.Pos, 1.0}, {extra, probe.Neg, -1.0},
+	} {
+		if entry.row != 0 && entry.col != 0 {
+			border = append(border, bordered.GetElement(entry.row, entry.col))
+			signs = append(signs, entry.sign)
+		}
+	}
+	zeros := &rootFinder{c: c, m: bordered, ctx: ctx, scale: options.Scale, relTol: options.RelTol, maxIterations: options.MaxIterations}
+	zeros.border = func() {
+		for i, element := range border {
+			bordered.AddToElement(element, signs[i], 0.0)
+		}
+	}
+	if result.Zeros, err = zeros.find(count); err != nil {
+		return nil, fmt.Errorf("zeros: %v", err)
+	}
+
+	return result, nil
+}
+
+// rootScale returns the ratio of the norms of G and C, the typical magnitude of the roots
+func (c *Circuit) rootScale(m *sparse.Matrix, ctx *Context) (float64, error) {
+	const LARGE = 1e15
+
+	norms := [2]float64{}
+	for i, s := range []complex128{0.0, LARGE} {
+		ctx.S = s
+		if _, err := c.Load(m, ctx); err != nil {
+			return 0.0, err
+		}
+		norms[i] = m.Norm()
+	}
+	if norms[0] == 0.0 || norms[1] == 0.0 {
+		return 1.0, nil
+	}
+	return norms[0] / (norms[1] / LARGE), nil
+}
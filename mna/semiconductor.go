@@ -0,0 +1,147 @@
+package mna
+
+import (
+	"math"
+
+	"github.com/edp1096/sparse"
+)
+
+// Shared parts of the semiconductor models: thermal voltage, temperature scaling, junction
+// currents and depletion charges, series resistances and the companion model of a charge.
+// Temperatures are in °C, as in SPICE.
+
+const (
+	BOLTZMANN = 1.380649e-23          // J/K
+	CHARGE    = 1.602176634e-19       // C
+	KELVIN    = 273.15                // 0 °C
+	TNOM      = 27.0                  // Default nominal and device temperature, °C
+	EPSOX     = 3.9 * 8.854214871e-12 // Permittivity of silicon dioxide, F/m
+)
+
+// Internal is a device with nodes of its own, behind series resistances
+type Internal interface {
+	Device
+	SetInternal(node func(name string) int64) // node returns the index of a node of the device, numbered on first use
+}
+
+// Parameters is a model card
+type Parameters interface {
+	Parameters() map[string]*float64 // Lower case SPICE names
+}
+
+// thermalVoltage returns kT/q at a temperature in °C
+func thermalVoltage(temp float64) float64 {
+	return BOLTZMANN * (temp + KELVIN) / CHARGE
+}
+
+// energyGap returns the band gap of silicon at a temperature in K, in eV
+func energyGap(kelvin float64) float64 {
+	return 1.16 - 7.02e-4*kelvin*kelvin/(kelvin+1108.0)
+}
+
+// junctionTemp returns the built-in potential and zero-bias capacitance at temp of a junction given at tnom
+func junctionTemp(vj, cj, m, tnom, temp float64) (float64, float64) {
+	t, t0 := temp+KELVIN, tnom+KELVIN
+	ratio := t / t0
+	vjT := vj*ratio - 3.0*thermalVoltage(temp)*math.Log(ratio) - energyGap(t0)*ratio + energyGap(t)
+	cjT := cj * (1.0 + m*(4e-4*(t-t0)-vjT/vj+1.0))
+	return vjT, cjT
+}
+
+// saturationTemp returns a saturation current at temp, given at tnom with gap eg, temperature exponent xti
+// and emission coefficient n
+func saturationTemp(is, eg, xti, n, tnom, temp float64) float64 {
+	ratio := (temp + KELVIN) / (tnom + KELVIN)
+	return is * math.Pow(ratio, xti/n) * math.Exp((ratio-1.0)*eg/(n*thermalVoltage(temp)))
+}
+
+// junction returns the current and conductance of a junction of saturation current is at v, nvt being N·Vt
+func junction(v, is, nvt float64) (float64, float64) {
+	e := math.Exp(v / nvt)
+	return is * (e - 1.0), is * e / nvt
+}
+
+// depletion returns the charge and capacitance of a depletion region at v, linear in capacitance above fc·vj
+func depletion(v, cj, vj, m, fc float64) (float64, float64) {
+	if cj == 0.0 {
+		return 0.0, 0.0
+	}
+	if v < fc*vj {
+		arg := 1.0 - v/vj
+		sarg := math.Pow(arg, -m)
+		return cj * vj * (1.0 - arg*sarg) / (1.0 - m), cj * sarg
+	}
+
+	f1 := vj * (1.0 - math.Pow(1.0-fc, 1.0-m)) / (1.0 - m)
+	f2 := math.Pow(1.0-fc, 1.0+m)
+	f3 := 1.0 - fc*(1.0+m)
+	vf := fc * vj
+	return cj*f1 + cj/f2*(f3*(v-vf)+m/(2.0*vj)*(v*v-vf*vf)), cj / f2 * (f3 + m*v/vj)
+}
+
+// limit limits a junction voltage against its last value and counts it in Limited
+func (ctx *Context) limit(v, last, vt, vcrit float64) float64 {
+	limited, ok := LimitJunction(v, last, vt, vcrit)
+	if ok {
+		ctx.Limited++
+	}
+	return limited
+}
+
+// currentConverged compares the current predicted by the last linearization with the actual one
+func (ctx *Context) currentConverged(predicted, actual float64) bool {
+	return math.Abs(predicted-actual) <= ctx.RelTol*max(math.Abs(predicted), math.Abs(actual))+ctx.AbsTol
+}
+
+// loadCurrent adds the rhs of a current i from pos to neg through the device, linearized as
+// i + Σ g·Δv with Σ g·v being linear
+func loadCurrent(rhs []float64, pos, neg int64, i, linear float64) {
+	addRHS(rhs, pos, -(i - linear))
+	addRHS(rhs, neg, i-linear)
+}
+
+// loadCharge adds a charge q of capacitance c at v between the nodes of template: its companion
+// model in transient, S·c in AC
+func loadCharge(template *sparse.Template, rhs []float64, pos, neg int64, state int, q, c, v float64, ctx *Context) {
+	switch ctx.Mode {
+	case TRANSIENT:
+		coeff, history := ctx.Integrate(state)
+		if coeff == 0.0 {
+			return
+		}
+		g := coeff * c
+		template.AddRealQuad(g)
+		loadCurrent(rhs, pos, neg, coeff*q+history, g*v)
+	case AC:
+		addQuad(template, ctx.S*complex(c, 0.0))
+	}
+}
+
+// resistance is a series resistance from an external node to an internal one, absent when zero
+type resistance struct {
+	node, internal int64
+	conductance    float64
+	template       sparse.Template
+}
+
+// set takes the internal node when r is positive
+func (r *resistance) set(node int64, r0 float64, internal func() int64) {
+	r.node, r.internal, r.conductance = node, node, 0.0
+	if r0 > 0.0 {
+		r.internal = internal()
+		r.conductance = 1.0 / r0
+	}
+}
+
+func (r *resistance) setup(m *sparse.Matrix) error {
+	if r.conductance == 0.0 {
+		return nil
+	}
+	return m.GetAdmittance(r.node, r.internal, &r.template)
+}
+
+func (r *resistance) load() {
+	if r.conductance != 0.0 {
+		r.template.AddRealQuad(r.conductance)
+	}
+}
@@ -0,0 +1,241 @@
+package mna
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/edp1096/sparse"
+)
+
+// Sensitivity analysis. The circuit is loaded and factored once, in DC at the operating point or in AC at
+// one frequency, and the derivatives of one output by the parameters of every Sensitive device come from
+// a single adjoint solve, see sparse.Matrix.Sensitivity. Each device has one parameter, named after it:
+// the resistance, capacitance, inductance, coupling, gain or ratio, or the DC value of a source in DC
+// and its AC magnitude in AC. Semiconductor model parameters are not included.
+
+// Sensitive is a device with stamp derivatives for the sensitivity analysis
+type Sensitive interface {
+	Device
+	Derivatives(ctx *Context) []sparse.Parameter // By each parameter, in DC or at Context.S in AC
+}
+
+// SensitivityResult holds the derivatives of the output by each parameter
+type SensitivityResult struct {
+	Output      Probe
+	Value       complex128 // Output, real in DC
+	Parameters  []string
+	Values      []complex128 // ∂output/∂parameter
+	Differences []complex128 // Central finite differences of the same, with check
+}
+
+// Sensitivity returns the derivatives of output, a probe without ratio, by the parameters of the devices
+// for ctx: DC, with Context.Solution at the operating point of a nonlinear circuit, on a real matrix,
+// or AC at Context.S on a complex one. With check the finite differences are computed as well.
+func (c *Circuit) Sensitivity(m *sparse.Matrix, ctx *Context, output string, check bool) (*SensitivityResult, error) {
+	if ctx.Mode == TRANSIENT || m.Complex != (ctx.Mode == AC) {
+		return nil, fmt.Errorf("sensitivity needs a real matrix in DC or a complex one in AC")
+	}
+	probe, err := c.Probe(output)
+	if err != nil {
+		return nil, err
+	}
+	if probe.Ratio {
+		return nil, fmt.Errorf("ratio output: %s", output)
+	}
+
+	rhs, err := c.Load(m, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Factor(); err != nil {
+		return nil, err
+	}
+	x, err := m.Solve(rhs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Output selector, in the layout of rhs
+	selector := make([]float64, len(rhs))
+	width := int64(1)
+	if m.Complex {
+		width = 2
+	}
+	if probe.Pos != 0 {
+		selector[width*probe.Pos] = 1.0
+	}
+	if probe.Neg != 0 {
+		selector[width*probe.Neg] = -1.0
+	}
+
+	result := &SensitivityResult{Output: probe, Value: complex(voltage(x, probe.Pos)-voltage(x, probe.Neg), 0.0)}
+	if m.Complex {
+		result.Value = probe.Value(x)
+	}
+
+	var parameters []sparse.Parameter
+	for _, device := range c.Devices {
+		if sensitive, ok := device.(Sensitive); ok {
+			parameters = append(parameters, sensitive.Derivatives(ctx)...)
+		}
+	}
+	for _, p := range parameters {
+		result.Parameters = append(result.Parameters, p.Name)
+	}
+
+	values, _, err := m.Sensitivity(x, nil, selector, nil, parameters)
+	if err != nil {
+		return nil, err
+	}
+	result.Values = parameterValues(values, m.Complex)
+
+	if check {
+		differences, _, err := m.SensitivityDifference(rhs, nil, selector, nil, parameters, 0.0)
+		if err != nil {
+			return nil, err
+		}
+		result.Differences = parameterValues(differences, m.Complex)
+	}
+
+	return result, nil
+}
+
+// MaxError returns the largest difference between the adjoint and finite difference derivatives,
+// relative to the larger of them or absolute below floor, 0 without the check
+func (r *SensitivityResult) MaxError(floor float64) float64 {
+	worst := 0.0
+	for i, difference := range r.Differences {
+		value := r.Values[i]
+		scale := max(cmplx.Abs(value), cmplx.Abs(difference), floor)
+		worst = max(worst, cmplx.Abs(value-difference)/scale)
+	}
+	return worst
+}
+
+// parameterValues converts a result of Sensitivity to complex values
+func parameterValues(values []float64, interleaved bool) []complex128 {
+	if !interleaved {
+		out := make([]complex128, len(values))
+		for i, value := range values {
+			out[i] = complex(value, 0.0)
+		}
+		return out
+	}
+	out := make([]complex128, len(values)/2)
+	for i := range out {
+		out[i] = complex(values[2*i], values[2*i+1])
+	}
+	return out
+}
+
+// admittanceDeltas returns the stamp of an admittance value between pos and neg
+func admittanceDeltas(pos, neg int64, value complex128) []sparse.ElementDelta {
+	return quadDeltas(pos, neg, pos, neg, value)
+}
+
+// quadDeltas returns the stamp of a quad of value, as GetQuad
+func quadDeltas(row1, row2, col1, col2 int64, value complex128) []sparse.ElementDelta {
+	re, im := real(value), imag(value)
+	return []sparse.ElementDelta{
+		{Row: row1, Col: col1, Real: re, Imag: im},
+		{Row: row2, Col: col2, Real: re, Imag: im},
+		{Row: row2, Col: col1, Real: -re, Imag: -im},
+		{Row: row1, Col: col2, Real: -re, Imag: -im},
+	}
+}
+
+// onesDeltas returns the stamp of the ones of a branch scaled by value, as GetOnes
+func onesDeltas(pos, neg, branch int64, value float64) []sparse.ElementDelta {
+	return []sparse.ElementDelta{
+		{Row: branch, Col: pos, Real: value},
+		{Row: pos, Col: branch, Real: value},
+		{Row: branch, Col: neg, Real: -value},
+		{Row: neg, Col: branch, Real: -value},
+	}
+}
+
+func (r *Resistor) Derivatives(ctx *Context) []sparse.Parameter {
+	return []sparse.Parameter{{Name: r.name, Matrix: admittanceDeltas(r.Pos, r.Neg, complex(-1.0/(r.Resistance*r.Resistance), 0.0))}}
+}
+
+func (c *Capacitor) Derivatives(ctx *Context) []sparse.Parameter {
+	p := sparse.Parameter{Name: c.name}
+	if ctx.Mode == AC {
+		p.Matrix = admittanceDeltas(c.Pos, c.Neg, ctx.S)
+	}
+	return []sparse.Parameter{p}
+}
+
+func (l *Inductor) Derivatives(ctx *Context) []sparse.Parameter {
+	p := sparse.Parameter{Name: l.name}
+	if ctx.Mode == AC {
+		p.Matrix = []sparse.ElementDelta{{Row: l.Branch, Col: l.Branch, Real: -real(ctx.S), Imag: -imag(ctx.S)}}
+	}
+	return []sparse.Parameter{p}
+}
+
+func (k *Mutual) Derivatives(ctx *Context) []sparse.Parameter {
+	p := sparse.Parameter{Name: k.name}
+	if ctx.Mode == AC {
+		value := -ctx.S * complex(math.Sqrt(k.Inductor1.Inductance*k.Inductor2.Inductance), 0.0)
+		b1, b2 := k.Inductor1.Branch, k.Inductor2.Branch
+		p.Matrix = []sparse.ElementDelta{
+			{Row: b1, Col: b2, Real: real(value), Imag: imag(value)},
+			{Row: b2, Col: b1, Real: real(value), Imag: imag(value)},
+		}
+	}
+	return []sparse.Parameter{p}
+}
+
+func (t *Transformer) Derivatives(ctx *Context) []sparse.Parameter {
+	return []sparse.Parameter{{Name: t.name, Matrix: onesDeltas(t.Pos2, t.Neg2, t.Branch, -1.0)}}
+}
+
+// unit returns the derivative of the value of the source named name by its DC value in DC, by its AC
+// magnitude in AC
+func (s *Source) unit(name string, ctx *Context) complex128 {
+	if ctx.Mode != AC {
+		return complex(ctx.SourceScale, 0.0)
+	}
+	if ctx.Inputs != nil {
+		value := ctx.Inputs[name]
+		if value == 0.0 {
+			return 0.0
+		}
+		return value / complex(cmplx.Abs(value), 0.0)
+	}
+	return cmplx.Rect(1.0, s.ACPhase*math.Pi/180.0)
+}
+
+func (v *VoltageSource) Derivatives(ctx *Context) []sparse.Parameter {
+	unit := v.unit(v.name, ctx)
+	return []sparse.Parameter{{Name: v.name, RHS: []sparse.ElementDelta{{Row: v.Branch, Real: real(unit), Imag: imag(unit)}}}}
+}
+
+func (i *CurrentSource) Derivatives(ctx *Context) []sparse.Parameter {
+	unit := i.unit(i.name, ctx)
+	return []sparse.Parameter{{Name: i.name, RHS: []sparse.ElementDelta{
+		{Row: i.Pos, Real: -real(unit), Imag: -imag(unit)},
+		{Row: i.Neg, Real: real(unit), Imag: imag(unit)},
+	}}}
+}
+
+func (e *VCVS) Derivatives(ctx *Context) []sparse.Parameter {
+	return []sparse.Parameter{{Name: e.name, Matrix: []sparse.ElementDelta{
+		{Row: e.Branch, Col: e.CtrlPos, Real: -1.0},
+		{Row: e.Branch, Col: e.CtrlNeg, Real: 1.0},
+	}}}
+}
+
+func (g *VCCS) Derivatives(ctx *Context) []sparse.Parameter {
+	return []sparse.Parameter{{Name: g.name, Matrix: quadDeltas(g.Pos, g.Neg, g.CtrlPos, g.CtrlNeg, 1.0)}}
+}
+
+func (h *CCVS) Derivatives(ctx *Context) []sparse.Parameter {
+	return []sparse.Parameter{{Name: h.name, Matrix: []sparse.ElementDelta{{Row: h.Branch, Col: h.Control, Real: -1.0}}}}
+}
+
+func (f *CCCS) Derivatives(ctx *Context) []sparse.Parameter {
+	return []sparse.Parameter{{Name: f.name, Matrix: quadDeltas(f.Pos, f.Neg, f.Control, 0, 1.0)}}
+}
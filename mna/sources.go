@@ -0,0 +1,358 @@
+package mna
+
+import (
+	"math"
+	"math/cmplx"
+	"sort"
+
+	"github.com/edp1096/sparse"
+)
+
+// Waveform is the value of an independent source over time in transient
+type Waveform interface {
+	Value(t float64) float64
+}
+
+// Sine is the SPICE SIN waveform, Offset + Amplitude·exp(-Damping·(t-Delay))·sin(2π·Frequency·(t-Delay) + Phase)
+// after Delay. Phase is in degrees.
+type Sine struct {
+	Offset, Amplitude float64
+	Frequency         float64
+	Delay, Damping    float64
+	Phase             float64
+}
+
+func (s *Sine) Value(t float64) float64 {
+	phase := s.Phase * math.Pi / 180.0
+	if t < s.Delay {
+		return s.Offset + s.Amplitude*math.Sin(phase)
+	}
+	t -= s.Delay
+	return s.Offset + s.Amplitude*math.Exp(-s.Damping*t)*math.Sin(2.0*math.Pi*s.Frequency*t+phase)
+}
+
+// Source holds the values of an independent source
+type Source struct {
+	DC      float64  // Value in DC, and in transient without Wave
+	ACMag   float64  // Magnitude in AC
+	ACPhase float64  // Phase in AC, degrees
+	Wave    Waveform // Value in transient, nil for DC
+}
+
+// value returns the real value of the source for a DC or transient load
+func (s *Source) value(ctx *Context) float64 {
+	if ctx.Mode == TRANSIENT && s.Wave != nil {
+		return s.Wave.Value(ctx.Time) * ctx.SourceScale
+	}
+	return s.DC * ctx.SourceScale
+}
+
+// waveform returns the transient function of the source
+func (s *Source) waveform() Waveform {
+	return s.Wave
+}
+
+// phasor returns the AC value of the source
+func (s *Source) phasor() complex128 {
+	return cmplx.Rect(s.ACMag, s.ACPhase*math.Pi/180.0)
+}
+
+// input returns the AC value of the source named name for an AC load, from Context.Inputs when set
+func (s *Source) input(name string, ctx *Context) complex128 {
+	if ctx.Inputs != nil {
+		return ctx.Inputs[name]
+	}
+	return s.phasor()
+}
+
+// VoltageSource forces V(Pos,Neg), its current from Pos through the source to Neg being the Branch unknown
+type VoltageSource struct {
+	name     string
+	Pos, Neg int64
+	Branch   int64
+	Source
+
+	ones sparse.Template
+}
+
+func NewVoltageSource(name string, pos, neg, branch int64, source Source) *VoltageSource {
+	return &VoltageSource{name: name, Pos: pos, Neg: neg, Branch: branch, Source: source}
+}
+
+func (v *VoltageSource) Name() string { return v.name }
+
+func (v *VoltageSource) Setup(m *sparse.Matrix) error {
+	return m.GetOnes(v.Pos, v.Neg, v.Branch, &v.ones)
+}
+
+func (v *VoltageSource) Load(m *sparse.Matrix, rhs []float64, ctx *Context) error {
+	v.ones.AddRealQuad(1.0)
+	if ctx.Mode == AC {
+		addComplexRHS(rhs, v.Branch, v.input(v.name, ctx))
+	} else {
+		addRHS(rhs, v.Branch, v.value(ctx))
+	}
+	return nil
+}
+
+// CurrentSource drives its current from Pos through the source to Neg
+type CurrentSource struct {
+	name     string
+	Pos, Neg int64
+	Source
+}
+
+func NewCurrentSource(name string, pos, neg int64, source Source) *CurrentSource {
+	return &CurrentSource{name: name, Pos: pos, Neg: neg, Source: source}
+}
+
+func (i *CurrentSource) Name() string { return i.name }
+
+func (i *CurrentSource) Setup(m *sparse.Matrix) error { return nil }
+
+func (i *CurrentSource) Load(m *sparse.Matrix, rhs []float64, ctx *Context) error {
+	if ctx.Mode == AC {
+		value := i.input(i.name, ctx)
+		addComplexRHS(rhs, i.Pos, -value)
+		addComplexRHS(rhs, i.Neg, value)
+	} else {
+		addRHS(rhs, i.Pos, -i.value(ctx))
+		addRHS(rhs, i.Neg, i.value(ctx))
+	}
+	return nil
+}
+
+// VCVS forces V(Pos,Neg) = Gain·V(CtrlPos,CtrlNeg), its current being the Branch unknown
+type VCVS struct {
+	name             string
+	Pos, Neg         int64
+	CtrlPos, CtrlNeg int64
+	Branch           int64
+	Gain             float64
+
+	ones             sparse.Template
+	ctrlPos, ctrlNeg *sparse.Element
+}
+
+func NewVCVS(name string, pos, neg, ctrlPos, ctrlNeg, branch int64, gain float64) *VCVS {
+	return &VCVS{name: name, Pos: pos, Neg: neg, CtrlPos: ctrlPos, CtrlNeg: ctrlNeg, Branch: branch, Gain: gain}
+}
+
+func (e *VCVS) Name() string { return e.name }
+
+func (e *VCVS) Setup(m *sparse.Matrix) error {
+	e.ctrlPos = m.GetElement(e.Branch, e.CtrlPos)
+	e.ctrlNeg = m.GetElement(e.Branch, e.CtrlNeg)
+	return m.GetOnes(e.Pos, e.Neg, e.Branch, &e.ones)
+}
+
+func (e *VCVS) Load(m *sparse.Matrix, rhs []float64, ctx *Context) error {
+	e.ones.AddRealQuad(1.0)
+	m.AddToElement(e.ctrlPos, -e.Gain, 0.0)
+	m.AddToElement(e.ctrlNeg, e.Gain, 0.0)
+	return nil
+}
+
+// VCCS drives Gain·V(CtrlPos,CtrlNeg) from Pos through the source to Neg
+type VCCS struct {
+	name             string
+	Pos, Neg         int64
+	CtrlPos, CtrlNeg int64
+	Gain             float64
+
+	quad sparse.Template
+}
+
+func NewVCCS(name string, pos, neg, ctrlPos, ctrlNeg int64, gain float64) *VCCS {
+	return &VCCS{name: name, Pos: pos, Neg: neg, CtrlPos: ctrlPos, CtrlNeg: ctrlNeg, Gain: gain}
+}
+
+func (g *VCCS) Name() string { return g.name }
+
+func (g *VCCS) Setup(m *sparse.Matrix) error {
+	return m.GetQuad(g.Pos, g.Neg, g.CtrlPos, g.CtrlNeg, &g.quad)
+}
+
+func (g *VCCS) Load(m *sparse.Matrix, rhs []float64, ctx *Context) error {
+	g.quad.AddRealQuad(g.Gain)
+	return nil
+}
+
+// CCVS forces V(Pos,Neg) = Gain·I(Control), Control being the branch of a voltage source or inductor,
+// its current being the Branch unknown
+type CCVS struct {
+	name     string
+	Pos, Neg int64
+	Branch   int64
+	Control  int64
+	Gain     float64
+
+	ones    sparse.Template
+	control *sparse.Element
+}
+
+func NewCCVS(name string, pos, neg, branch, control int64, gain float64) *CCVS {
+	return &CCVS{name: name, Pos: pos, Neg: neg, Branch: branch, Control: control, Gain: gain}
+}
+
+func (h *CCVS) Name() string { return h.name }
+
+func (h *CCVS) Setup(m *sparse.Matrix) error {
+	h.control = m.GetElement(h.Branch, h.Control)
+	return m.GetOnes(h.Pos, h.Neg, h.Branch, &h.ones)
+}
+
+func (h *CCVS) Load(m *sparse.Matrix, rhs []float64, ctx *Context) error {
+	h.ones.AddRealQuad(1.0)
+	m.AddToElement(h.control, -h.Gain, 0.0)
+	return nil
+}
+
+// CCCS drives Gain·I(Control) from Pos through the source to Neg, Control being the branch of a voltage source or inductor
+type CCCS struct {
+	name     string
+	Pos, Neg int64
+	Control  int64
+	Gain     float64
+
+	quad sparse.Template
+}
+
+func NewCCCS(name string, pos, neg, control int64, gain float64) *CCCS {
+	return &CCCS{name: name, Pos: pos, Neg: neg, Control: control, Gain: gain}
+}
+
+func (f *CCCS) Name() string { return f.name }
+
+func (f *CCCS) Setup(m *sparse.Matrix) error {
+	return m.GetQuad(f.Pos, f.Neg, f.Control, 0, &f.quad)
+}
+
+func (f *CCCS) Load(m *sparse.Matrix, rhs []float64, ctx *Context) error {
+	f.quad.AddRealQuad(f.Gain)
+	return nil
+}
+
+// Breakpoints is a waveform with corners, which transient steps land on
+type Breakpoints interface {
+	NextBreakpoint(t float64) float64 // First corner after t, +Inf if none
+}
+
+func (s *Sine) NextBreakpoint(t float64) float64 {
+	if s.Delay > t {
+		return s.Delay
+	}
+	return math.Inf(1)
+}
+
+// Pulse is the SPICE PULSE waveform, from V1 to V2 after Delay, repeated every Period when it is positive.
+// A zero Rise or Fall is a step.
+type Pulse struct {
+	V1, V2     float64
+	Delay      float64
+	Rise, Fall float64
+	Width      float64
+	Period     float64
+}
+
+func (p *Pulse) Value(t float64) float64 {
+	if t < p.Delay {
+		return p.V1
+	}
+	t -= p.Delay
+	if p.Period > 0.0 {
+		t = math.Mod(t, p.Period)
+	}
+
+	switch {
+	case t < p.Rise:
+		return p.V1 + (p.V2-p.V1)*t/p.Rise
+	case t < p.Rise+p.Width:
+		return p.V2
+	case t < p.Rise+p.Width+p.Fall:
+		return p.V2 + (p.V1-p.V2)*(t-p.Rise-p.Width)/p.Fall
+	}
+	return p.V1
+}
+
+func (p *Pulse) NextBreakpoint(t float64) float64 {
+	corners := [4]float64{0.0, p.Rise, p.Rise + p.Width, p.Rise + p.Width + p.Fall}
+
+	start := p.Delay
+	if p.Period > 0.0 && t > p.Delay {
+		start += math.Floor((t-p.Delay)/p.Period) * p.Period
+	}
+	for range 2 {
+		for _, corner := range corners {
+			if start+corner > t {
+				return start + corner
+			}
+		}
+		if p.Period <= 0.0 {
+			break
+		}
+		start += p.Period
+	}
+	return math.Inf(1)
+}
+
+// PWL is the SPICE piecewise linear waveform through the points (Times[i], Values[i]), constant outside them.
+// Times must be increasing.
+type PWL struct {
+	Times  []float64
+	Values []float64
+}
+
+func (p *PWL) Value(t float64) float64 {
+	n := len(p.Times)
+	if n == 0 {
+		return 0.0
+	}
+	if t <= p.Times[0] {
+		return p.Values[0]
+	}
+	if t >= p.Times[n-1] {
+		return p.Values[n-1]
+	}
+
+	i := sort.SearchFloat64s(p.Times, t) // Times[i-1] < t <= Times[i]
+	t0, t1 := p.Times[i-1], p.Times[i]
+	return p.Values[i-1] + (p.Values[i]-p.Values[i-1])*(t-t0)/(t1-t0)
+}
+
+func (p *PWL) NextBreakpoint(t float64) float64 {
+	i := sort.Search(len(p.Times), func(i int) bool { return p.Times[i] > t })
+	if i < len(p.Times) {
+		return p.Times[i]
+	}
+	return math.Inf(1)
+}
+
+// Exp is the SPICE EXP waveform, rising from V1 to V2 at RiseDelay with RiseTau and falling back at
+// FallDelay with FallTau
+type Exp struct {
+	V1, V2             float64
+	RiseDelay, RiseTau float64
+	FallDelay, FallTau float64
+}
+
+func (e *Exp) Value(t float64) float64 {
+	value := e.V1
+	if t > e.RiseDelay {
+		value += (e.V2 - e.V1) * (1.0 - math.Exp(-(t-e.RiseDelay)/e.RiseTau))
+	}
+	if t > e.FallDelay {
+		value += (e.V1 - e.V2) * (1.0 - math.Exp(-(t-e.FallDelay)/e.FallTau))
+	}
+	return value
+}
+
+func (e *Exp) NextBreakpoint(t float64) float64 {
+	switch {
+	case e.RiseDelay > t:
+		return e.RiseDelay
+	case e.FallDelay > t:
+		return e.FallDelay
+	}
+	return math.Inf(1)
+}
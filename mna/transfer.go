@@ -0,0 +1,113 @@
+package mna
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/edp1096/sparse"
+)
+
+// DC small-signal transfer function, as the .tf of SPICE. With A factored once at the operating point:
+//   x = A⁻¹ b, b the unit input source: the gain is cᵀx, c selecting the output, and the input
+//   resistance comes from the voltage or the current of the source in x
+//   λ = A⁻ᵀ c: the output resistance is cᵀA⁻¹c = λᵀc, the output seen from a unit test current between
+//   its nodes, or a unit test voltage in its source for a current output
+
+// TransferResult holds the small-signal transfer function from an input source to an output
+type TransferResult struct {
+	Input            string
+	Output           Probe
+	Gain             float64 // Output by input, V/V, A/V, V/A or A/A
+	InputResistance  float64 // Seen by the input source, +Inf when it drives no current
+	OutputResistance float64 // Between the output nodes, or in series with the source of a current output
+}
+
+// TransferFunction returns the gain from input, a voltage or current source, to output, a probe without
+// ratio as V(out), V(out,ref) or I(vsense), with the input and output resistances. m is real, ordered and
+// factored once for ctx in DC, with Context.Solution at the operating point of a nonlinear circuit.
+func (c *Circuit) TransferFunction(m *sparse.Matrix, ctx *Context, input, output string) (*TransferResult, error) {
+	if ctx.Mode != DC || m.Complex {
+		return nil, fmt.Errorf("transfer function needs a real matrix in DC")
+	}
+	probe, err := c.Probe(output)
+	if err != nil {
+		return nil, err
+	}
+	if probe.Ratio {
+		return nil, fmt.Errorf("ratio output: %s", output)
+	}
+
+	rhs, err := c.Load(m, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pos, neg, current, err := c.excitation(input)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]float64, len(rhs))
+	addRHS(b, pos, 1.0)
+	addRHS(b, neg, -1.0)
+
+	if err := m.OrderAndFactor(rhs, 0.0, -1.0, true); err != nil {
+		return nil, c.singular(m, err)
+	}
+
+	x, err := m.Solve(b)
+	if err != nil {
+		return nil, err
+	}
+	result := &TransferResult{Input: input, Output: probe, Gain: voltage(x, probe.Pos) - voltage(x, probe.Neg)}
+	if current {
+		result.InputResistance = voltage(x, pos) - voltage(x, neg)
+	} else {
+		result.InputResistance = sourceResistance(voltage(x, pos))
+	}
+
+	selector := make([]float64, len(rhs))
+	addRHS(selector, probe.Pos, 1.0)
+	addRHS(selector, probe.Neg, -1.0)
+	lambda, err := m.SolveTransposed(selector)
+	if err != nil {
+		return nil, err
+	}
+	if c.IsBranch(probe.Pos) {
+		result.OutputResistance = sourceResistance(voltage(lambda, probe.Pos))
+	} else {
+		result.OutputResistance = voltage(lambda, probe.Pos) - voltage(lambda, probe.Neg)
+	}
+
+	return result, nil
+}
+
+// excitation returns the rows of the unit input source, in the rhs at pos and with the opposite sign at
+// neg: the branch of a voltage source, or the nodes of a current source, which flows from Pos to Neg
+func (c *Circuit) excitation(input string) (pos, neg int64, current bool, err error) {
+	switch source := c.Device(input).(type) {
+	case *VoltageSource:
+		return source.Branch, 0, false, nil
+	case *CurrentSource:
+		return source.Neg, source.Pos, true, nil
+	case nil:
+		return 0, 0, false, fmt.Errorf("unknown source: %s", input)
+	}
+	return 0, 0, false, fmt.Errorf("not an independent source: %s", input)
+}
+
+// sourceResistance returns the resistance seen by a unit voltage source of branch current current, +Inf
+// without current
+func sourceResistance(current float64) float64 {
+	if current == 0.0 {
+		return math.Inf(1)
+	}
+	return -1.0 / current
+}
+
+// singular names the unknown of the zero pivot of a failed factorization of m
+func (c *Circuit) singular(m *sparse.Matrix, err error) error {
+	if m.SingularCol <= 0 || m.SingularCol >= int64(len(m.IntToExtColMap)) {
+		return err
+	}
+	return fmt.Errorf("singular matrix at %s, floating or undetermined: %v", c.Label(m.IntToExtColMap[m.SingularCol]), err)
+}
@@ -0,0 +1,364 @@
+package mna
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/edp1096/sparse"
+)
+
+// Transient analysis. The charges and fluxes of the reactive devices are integrated by variable step
+// BDF or trapezoidal formulas, the step being set by their local truncation error and landing on the
+// breakpoints of the sources. Every point loads, factors and solves the same matrix, so the pivot
+// order is only computed again when MonitorPivots finds it unstable. Nonlinear circuits are solved by
+// Newton iterations at each point, from the solution of the previous one.
+
+// TransientOptions are the settings of a transient analysis
+type TransientOptions struct {
+	Step    float64 // Initial output step
+	Stop    float64
+	Start   float64 // Points before Start are not kept
+	MaxStep float64 // Default: the lower of Step and (Stop-Start)/50
+	MinStep float64 // Default: 1e-9·Step
+
+	Method   Method
+	MaxOrder int // BDF 1 to 6, trapezoidal 1 or 2. Default: 2
+
+	RelTol float64 // Default: 1e-3
+	AbsTol float64 // Currents. Default: 1e-12
+	ChgTol float64 // Charges. Default: 1e-14
+	TrTol  float64 // Overestimation of the truncation error. Default: 7
+
+	Writer io.Writer // Waveforms as tab separated columns, nil for none
+	Probes []string  // Names of the written unknowns, all when empty
+
+	Newton NewtonOptions // Operating point and time points
+}
+
+// TransientResult holds the accepted points from Start on
+type TransientResult struct {
+	Times     []float64
+	Solutions [][]float64 // By external index
+	Accepted  int
+	Rejected  int
+
+	OperatingPoint *OperatingPoint // At time 0
+}
+
+// Error coefficients of the truncation error by order, as SPICE
+var (
+	trapezoidalErrorCoeffs = []float64{0.5, 1.0 / 12.0}
+	bdfErrorCoeffs         = []float64{0.5, 2.0 / 9.0, 3.0 / 22.0, 12.0 / 125.0, 10.0 / 137.0, 20.0 / 343.0}
+)
+
+// checkTransientOptions validates options and fills the defaults
+func checkTransientOptions(options TransientOptions) (TransientOptions, error) {
+	if options.Step <= 0.0 || options.Stop <= 0.0 || options.Start < 0.0 || options.Start >= options.Stop {
+		return options, fmt.Errorf("invalid transient times: step %g, start %g, stop %g", options.Step, options.Start, options.Stop)
+	}
+	if options.MaxStep <= 0.0 {
+		options.MaxStep = min(options.Step, (options.Stop-options.Start)/50.0)
+	}
+	if options.MinStep <= 0.0 {
+		options.MinStep = 1e-9 * options.Step
+	}
+
+	maxOrder := 6
+	if options.Method == TRAPEZOIDAL {
+		maxOrder = 2
+	}
+	if options.MaxOrder <= 0 {
+		options.MaxOrder = 2
+	}
+	if options.MaxOrder > maxOrder {
+		return options, fmt.Errorf("order %d above %d", options.MaxOrder, maxOrder)
+	}
+
+	if options.RelTol <= 0.0 {
+		options.RelTol = 1e-3
+	}
+	if options.AbsTol <= 0.0 {
+		options.AbsTol = 1e-12
+	}
+	if options.ChgTol <= 0.0 {
+		options.ChgTol = 1e-14
+	}
+	if options.TrTol <= 0.0 {
+		options.TrTol = 7.0
+	}
+
+	return options, nil
+}
+
+// Transient runs a transient analysis from the operating point at time 0 on m, a real matrix of
+// the circuit, or on a new one when m is nil
+func (c *Circuit) Transient(m *sparse.Matrix, options TransientOptions) (*TransientResult, error) {
+	o, err := checkTransientOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		if m, err = c.NewMatrix(false); err != nil {
+			return nil, err
+		}
+	}
+
+	probes, err := c.probes(o.Probes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Operating point with the sources at time 0, without integration
+	ctx := NewContext(TRANSIENT)
+	ctx.Method = o.Method
+	op, err := c.OperatingPoint(m, ctx, o.Newton)
+	if err != nil {
+		return nil, err
+	}
+	x := op.Solution
+
+	// Accepted points, newest first
+	keep := o.MaxOrder + 2
+	times := []float64{0.0}
+	states := [][]float64{c.StateVector(x)}
+	derivs := [][]float64{make([]float64, c.States)}
+
+	result := &TransientResult{OperatingPoint: op}
+	writeHeader(o.Writer, c, probes)
+	result.keep(o, 0.0, x, probes, c)
+
+	t := 0.0
+	h := min(o.Step, o.MaxStep) / 10.0
+	order := 1
+	smooth := 1 // Accepted points since the last corner, which integration does not reach over
+	breakpoint := c.nextBreakpoint(t)
+
+	for o.Stop-t > o.MinStep/2.0 {
+		// Land on the next breakpoint or the stop time
+		target := min(o.Stop, breakpoint)
+		landing := t+h >= target-o.MinStep/2.0
+		if landing {
+			h = target - t
+		}
+		tn := t + h
+		if landing {
+			tn = target
+		}
+
+		ctx.Time = tn
+		ctx.Order = order
+		ctx.Coeffs, ctx.DerivativeCoeff = integrationCoeffs(o.Method, order, tn, times)
+		ctx.States = append([][]float64{nil}, states...)
+		ctx.Derivs = append([][]float64{nil}, derivs...)
+
+		xn, _, err := c.Newton(m, ctx, x, o.Newton)
+		if err != nil {
+			result.Rejected++
+			if h /= 8.0; h < o.MinStep {
+				return result, fmt.Errorf("time %g: %v", tn, err)
+			}
+			order = 1
+			continue
+		}
+
+		qn := c.StateVector(xn)
+		dn := make([]float64, c.States)
+		for i := range dn {
+			coeff, history := ctx.Integrate(i)
+			dn[i] = coeff*qn[i] + history
+		}
+
+		// Truncation error, once there are enough points for a divided difference of order+1
+		next := math.Inf(1)
+		if len(times) >= order+1 {
+			next = o.truncationStep(h, order, tn, times, qn, dn, states, derivs)
+			if next < 0.9*h {
+				result.Rejected++
+				if h = next; h < o.MinStep {
+					return result, fmt.Errorf("time %g: step below %g", tn, o.MinStep)
+				}
+				continue
+			}
+		}
+
+		// Accept
+		t = tn
+		x = xn
+		times = append([]float64{t}, times[:min(len(times), keep-1)]...)
+		states = append([][]float64{qn}, states[:min(len(states), keep-1)]...)
+		derivs = append([][]float64{dn}, derivs[:min(len(derivs), keep-1)]...)
+		smooth++
+		result.Accepted++
+		result.keep(o, t, x, probes, c)
+
+		if landing && t == breakpoint {
+			// Restart with first order after a corner
+			order = 1
+			smooth = 1
+			breakpoint = c.nextBreakpoint(t)
+			h = min(h, next, 0.1*(min(o.Stop, breakpoint)-t))
+		} else {
+			order = min(order+1, o.MaxOrder, smooth)
+			h = min(growth(order)*h, next)
+		}
+		h = min(h, o.MaxStep)
+	}
+
+	return result, nil
+}
+
+// integrationCoeffs returns the weights of the derivative at tn from the state at tn and the accepted points
+func integrationCoeffs(method Method, order int, tn float64, times []float64) ([]float64, float64) {
+	h := tn - times[0]
+
+	if method == TRAPEZOIDAL {
+		if order == 1 {
+			return []float64{1.0 / h, -1.0 / h}, 0.0
+		}
+		return []float64{2.0 / h, -2.0 / h}, -1.0
+	}
+
+	// Derivative at tn of the polynomial through tn and the last order points
+	points := append([]float64{tn}, times[:order]...)
+	coeffs := make([]float64, order+1)
+	for k := 1; k <= order; k++ {
+		coeffs[0] += 1.0 / (points[0] - points[k])
+	}
+	for j := 1; j <= order; j++ {
+		numerator, denominator := 1.0, 1.0
+		for k := 0; k <= order; k++ {
+			if k != j {
+				denominator *= points[j] - points[k]
+				if k != 0 {
+					numerator *= points[0] - points[k]
+				}
+			}
+		}
+		coeffs[j] = numerator / denominator
+	}
+
+	return coeffs, 0.0
+}
+
+// truncationStep returns the step for which the truncation error of every state meets the tolerances
+func (o *TransientOptions) truncationStep(h float64, order int, tn float64, times, qn, dn []float64, states, derivs [][]float64) float64 {
+	factor := bdfErrorCoeffs[order-1]
+	if o.Method == TRAPEZOIDAL {
+		factor = trapezoidalErrorCoeffs[order-1]
+	}
+
+	points := append([]float64{tn}, times[:order+1]...)
+	diff := make([]float64, order+2)
+
+	step := math.Inf(1)
+	for i := range qn {
+		currentTol := o.AbsTol + o.RelTol*max(math.Abs(dn[i]), math.Abs(derivs[0][i]))
+		chargeTol := o.RelTol * max(math.Abs(qn[i]), math.Abs(states[0][i]), o.ChgTol) / h
+		tol := max(currentTol, chargeTol)
+
+		// Divided difference of order+1
+		diff[0] = qn[i]
+		for j := 1; j <= order+1; j++ {
+			diff[j] = states[j-1][i]
+		}
+		for level := 1; level <= order+1; level++ {
+			for j := 0; j+level <= order+1; j++ {
+				diff[j] = (diff[j] - diff[j+1]) / (points[j] - points[j+level])
+			}
+		}
+
+		del := o.TrTol * tol / max(o.AbsTol, factor*math.Abs(diff[0]))
+		switch {
+		case order == 2:
+			del = math.Sqrt(del)
+		case order > 2:
+			del = math.Exp(math.Log(del) / float64(order))
+		}
+		step = min(step, del)
+	}
+
+	return step
+}
+
+// nextBreakpoint returns the first corner of the source waveforms after t
+func (c *Circuit) nextBreakpoint(t float64) float64 {
+	next := math.Inf(1)
+	for _, device := range c.Devices {
+		source, ok := device.(interface{ waveform() Waveform })
+		if !ok {
+			continue
+		}
+		if wave, ok := source.waveform().(Breakpoints); ok {
+			next = min(next, wave.NextBreakpoint(t))
+		}
+	}
+	return next
+}
+
+// probes returns the indexes of names, every unknown when there are none
+func (c *Circuit) probes(names []string) ([]int64, error) {
+	if len(names) == 0 {
+		indexes := make([]int64, c.Nodes.Size())
+		for i := range indexes {
+			indexes[i] = int64(i + 1)
+		}
+		return indexes, nil
+	}
+
+	indexes := make([]int64, len(names))
+	for i, name := range names {
+		index, ok := c.Nodes.Index(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown probe: %s", name)
+		}
+		indexes[i] = index
+	}
+	return indexes, nil
+}
+
+// Label returns the name of an unknown as printed, V(node) or I(device)
+func (c *Circuit) Label(index int64) string {
+	if c.IsBranch(index) {
+		return c.Nodes.Name(index)
+	}
+	return "V(" + c.Nodes.Name(index) + ")"
+}
+
+func writeHeader(w io.Writer, c *Circuit, probes []int64) {
+	if w == nil {
+		return
+	}
+	fmt.Fprint(w, "time")
+	for _, index := range probes {
+		fmt.Fprintf(w, "\t%s", c.Label(index))
+	}
+	fmt.Fprintln(w)
+}
+
+// keep records and writes an accepted point from Start on
+func (r *TransientResult) keep(o TransientOptions, t float64, x []float64, probes []int64, c *Circuit) {
+	if t < o.Start {
+		return
+	}
+	r.Times = append(r.Times, t)
+	r.Solutions = append(r.Solutions, x)
+
+	if o.Writer == nil {
+		return
+	}
+	fmt.Fprintf(o.Writer, "%.9e", t)
+	for _, index := range probes {
+		fmt.Fprintf(o.Writer, "\t%.9e", voltage(x, index))
+	}
+	fmt.Fprintln(o.Writer)
+}
+
+// growth returns the largest ratio of consecutive steps, lower for the orders of BDF that are only
+// zero-stable under slowly varying steps
+func growth(order int) float64 {
+	if order <= 2 {
+		return 2.0
+	}
+	return 1.0 + 1.0/float64(order)
+}
@@ -24,7 +24,7 @@ type Configuration struct {
 	Delete            bool // Not use
 	Strip             bool // Not use
 	ModifiedNodal     bool
-	QuadElement       bool // Not use, regardless getAdmittance, getQuad using
+	QuadElement       bool // Not use, GetAdmittance, GetQuad and GetOnes are always available
 	Transpose         bool // Flag for transpose job
 	Scaling           bool // Not use
 	Documentation     bool // Not use. fortran
@@ -35,6 +35,10 @@ type Configuration struct {
 	Multiplication    bool
 	Fortran           bool // Not use. fortran
 	Debug             bool // Not use
+	PartialFactor     bool // Keep unfactored values so Factor recomputes only changed columns
+	MonitorPivots     bool // Check pivots and element growth in Factor, reorder when unstable
+	Compressed        bool // Refactor and solve on compressed columns frozen after OrderAndFactor
+	GroundRow         bool // Keep the stamps of row 0 for GroundRowProduct instead of discarding them
 
 	DefaultThreshold      float64 // For relative threshold
 	DiagPivotingAsDefault bool
@@ -44,7 +48,11 @@ type Configuration struct {
 	MinimumAllocatedSize  int
 	ExpansionFactor       float64
 	MaxMarkowitzTies      int64
+	UpdateRCondLimit      float64 // Low-rank updates with a worse conditioned capacitance matrix are rejected. Default: 1e-10
+	GrowthLimit           float64 // Element growth accepted by MonitorPivots. Default: 1e10
+	DenseThreshold        float64 // Density of the remaining submatrix that switches factorization to dense LU. 0: never
 	TiesMultiplier        int
+	Workers               int // Goroutines sharing Factor and wide enough solves. 0 or 1: serial
 	DefaultPartition      int
 	PrinterWidth          int // Default: 80
 	Annotate              int // 0: None, 1: OnStrangeBehavior , 2: Full
@@ -103,7 +111,32 @@ type Matrix struct {
 	ExtToIntRowMap []int64 // External->Internal rows map [1...Size]
 	ExtToIntColMap []int64 // External->Internal columns map [1...Size]
 
-	// TrashCan *Element // Not use yet. eat more memory
+	// Partial refactorization - PartialFactor config
+	ChangedCols    []bool          // Columns changed since last factorization [1...Size]
+	OriginalValues []ComplexNumber // Unfactored element values in column order, nil when not kept
+	OriginalStart  []int           // Index of each column in OriginalValues [1...Size+1]
+	PartialColumns int             // Columns recomputed by the last partial Factor
+	previousValues []ComplexNumber // OriginalValues before Clear, compared by Factor with the restamped ones
+	cleared        bool            // Cleared since the last factorization, restamped columns not compared yet
+	columnOps      []int64         // Multiplications of each column of the factors, their total at 0
+
+	// Pivot monitor - MonitorPivots config
+	FallbackReorders    int     // Factor calls that fell back to OrderAndFactor
+	LastFactorReordered bool    // Last Factor fell back to OrderAndFactor
+	growthLimit         float64 // Largest element magnitude accepted during factorization, 0 for no check
+
+	// Dense trailing submatrix - DenseThreshold config
+	DenseStep int64 // Step from which the factorization is dense, 0 when fully sparse
+
+	CSC *CompressedColumns // Frozen structure and factors - Compressed config, nil when not built
+
+	FactorLevels *LevelSchedule // Levels of the column dependencies of Factor - Workers config, nil when serial
+	SolveLevels  *SolveLevels   // Level sets of the substitutions - Workers config, nil until needed
+	pool         *workerPool    // Goroutines of the parallel factorization and solves, started on first use
+
+	// Ground - row or column 0
+	TrashCan  *Element   // Element given for row or column 0, its value is discarded
+	GroundRow []*Element // Row 0 kept outside the factorization - GroundRow config [1...ExtSize] by external column
 }
 
 type ComplexNumber struct {
@@ -119,6 +152,8 @@ type Element struct {
 	NextInRow *Element
 	NextInCol *Element
 	InitInfo  *ComplexNumber
+
+	index int // Position in OriginalValues plus one, 0 when not numbered
 }
 
 type Template struct {
@@ -126,4 +161,6 @@ type Template struct {
 	Element2        *Element
 	Element3Negated *Element
 	Element4Negated *Element
+
+	matrix *Matrix // Matrix that filled the template, stamped through AddToElement
 }
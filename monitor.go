@@ -0,0 +1,75 @@
+package sparse
+
+import (
+	"errors"
+	"math"
+)
+
+// Pivot monitor. Factor reuses the pivot order of the last OrderAndFactor, which can become unstable
+// when values change a lot. With Config.MonitorPivots each pivot is compared with the largest element
+// below it, as OrderAndFactor does, and the element growth is bounded as in LargestElement.
+
+var errUnstablePivot = errors.New("unstable pivot, matrix needs reordering")
+
+const MONITOR_THRESHOLD = 1.0e-3 // Relative pivot threshold of the monitor when RelThreshold is 0
+
+// startMonitor sets the growth bound from the largest unfactored element
+func (m *Matrix) startMonitor() {
+	largest := 0.0
+
+	if m.OriginalValues != nil {
+		for _, value := range m.OriginalValues {
+			magnitude := math.Abs(value.Real)
+			if m.Complex {
+				magnitude = complex1Norm(value.Real, value.Imag)
+			}
+			largest = max(largest, magnitude)
+		}
+	} else {
+		for col := int64(1); col <= m.Size; col++ {
+			for element := m.FirstInCol[col]; element != nil; element = element.NextInCol {
+				largest = max(largest, m.elementMag(element))
+			}
+		}
+	}
+
+	m.growthLimit = largest * m.Config.GrowthLimit
+}
+
+// pivotIsStable checks the pivot magnitude of step against the thresholds and the elements below it.
+// The elements below the pivot must already hold their values of L.
+func (m *Matrix) pivotIsStable(step int64, magnitude float64) bool {
+	return m.pivotWithinLimits(magnitude, m.FindBiggestInCol(m.Diags[step].NextInCol))
+}
+
+// pivotWithinLimits checks a pivot magnitude given the largest magnitude below it
+func (m *Matrix) pivotWithinLimits(magnitude, largestInCol float64) bool {
+	relThreshold := m.RelThreshold
+	if relThreshold <= 0.0 {
+		relThreshold = MONITOR_THRESHOLD
+	}
+	if magnitude <= m.AbsThreshold || magnitude <= relThreshold*largestInCol {
+		return false
+	}
+
+	return m.growthLimit == 0.0 || max(magnitude, largestInCol) <= m.growthLimit
+}
+
+// factorFailed falls back to OrderAndFactor when the pivot monitor rejected the factorization
+func (m *Matrix) factorFailed(err error) error {
+	if err == nil {
+		return nil
+	}
+	m.markSingular(err)
+
+	if err == errUnstablePivot && m.OriginalValues != nil {
+		m.restoreValues()
+		m.FallbackReorders++
+		m.LastFactorReordered = true
+		m.Factored = false
+		return m.OrderAndFactor(nil, 0.0, 0.0, true)
+	}
+
+	m.OriginalValues = nil
+	return err
+}
@@ -0,0 +1,119 @@
+package sparse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NodeMap names the external indexes of a matrix, for node voltages ("out", "x1.n3") as well as
+// branch currents ("I(V1)"). Names get indexes 1, 2, ... in order of first use, "0" and "gnd" are ground.
+// With Config.Translate the internal indexes stay compact whatever the order.
+type NodeMap struct {
+	Matrix  *Matrix
+	Indexes map[string]int64 // Name -> external index
+	Names   []string         // External index -> name [1...], Names[0] is ground
+}
+
+func NewNodeMap(m *Matrix) *NodeMap {
+	return &NodeMap{
+		Matrix:  m,
+		Indexes: make(map[string]int64),
+		Names:   []string{"0"},
+	}
+}
+
+// IsGround reports whether name is reserved for ground
+func IsGround(name string) bool {
+	return name == "0" || strings.EqualFold(name, "gnd")
+}
+
+// Node returns the external index of name, giving it the next index on first use
+func (n *NodeMap) Node(name string) int64 {
+	if IsGround(name) {
+		return 0
+	}
+	if index, ok := n.Indexes[name]; ok {
+		return index
+	}
+
+	index := int64(len(n.Names))
+	n.Indexes[name] = index
+	n.Names = append(n.Names, name)
+	return index
+}
+
+// Index returns the external index of name, without adding it
+func (n *NodeMap) Index(name string) (int64, bool) {
+	if IsGround(name) {
+		return 0, true
+	}
+	index, ok := n.Indexes[name]
+	return index, ok
+}
+
+// Name returns the name of an external index, "" if it has none
+func (n *NodeMap) Name(index int64) string {
+	if index < 0 || index >= int64(len(n.Names)) {
+		return ""
+	}
+	return n.Names[index]
+}
+
+// Size returns the number of named indexes, ground excluded
+func (n *NodeMap) Size() int64 {
+	return int64(len(n.Names)) - 1
+}
+
+// Element returns the element of the named row and column, as GetElement
+func (n *NodeMap) Element(row, col string) *Element {
+	return n.Matrix.GetElement(n.Node(row), n.Node(col))
+}
+
+// Admittance fills template for an admittance between two named nodes, as GetAdmittance
+func (n *NodeMap) Admittance(node1, node2 string, template *Template) error {
+	return n.Matrix.GetAdmittance(n.Node(node1), n.Node(node2), template)
+}
+
+// Quad fills template for named rows and columns, as GetQuad
+func (n *NodeMap) Quad(row1, row2, col1, col2 string, template *Template) error {
+	return n.Matrix.GetQuad(n.Node(row1), n.Node(row2), n.Node(col1), n.Node(col2), template)
+}
+
+// Ones fills template for a named branch between named nodes and adds the ones, as GetOnes
+func (n *NodeMap) Ones(pos, neg, branch string, template *Template) error {
+	return n.Matrix.GetOnes(n.Node(pos), n.Node(neg), n.Node(branch), template)
+}
+
+// Value returns the solution of name from a solution laid out as for Solve or SolveComplex
+func (n *NodeMap) Value(name string, solution, isolution []float64) (float64, float64, error) {
+	index, ok := n.Index(name)
+	if !ok {
+		return 0.0, 0.0, fmt.Errorf("unknown name: %s", name)
+	}
+	if index == 0 {
+		return 0.0, 0.0, nil
+	}
+
+	switch {
+	case !n.Matrix.Complex:
+		return solution[index], 0.0, nil
+	case n.Matrix.Config.SeparatedComplexVectors:
+		return solution[index], isolution[index], nil
+	default:
+		return solution[2*index], solution[2*index+1], nil
+	}
+}
+
+// Vector returns a vector for Solve or SolveComplex covering the named indexes, with isolution
+// when Config.SeparatedComplexVectors
+func (n *NodeMap) Vector() ([]float64, []float64) {
+	size := n.Size() + 1 // 1-based indexing
+	switch {
+	case !n.Matrix.Complex:
+		return make([]float64, size), nil
+	case n.Matrix.Config.SeparatedComplexVectors:
+		return make([]float64, size), make([]float64, size)
+	default:
+		return make([]float64, 2*size), nil
+	}
+}
@@ -99,6 +99,7 @@ func (m *Matrix) Print(printReordered bool, data bool, header bool) {
 	if m == nil {
 		return
 	}
+	m.syncFactors()
 
 	top := m.Size
 	if m.Config.Translate {
@@ -275,6 +276,9 @@ func (m *Matrix) Print(printReordered bool, data bool, header bool) {
 		fmt.Printf("\nDensity = %.2f%%.\n", density)
 		if !m.NeedsOrdering {
 			fmt.Printf("Number of fill-ins = %d.\n", m.Fillins)
+			if m.DenseStep > 0 {
+				fmt.Printf("Dense factorization from step %d.\n", m.DenseStep)
+			}
 		}
 		fmt.Println()
 	}
@@ -0,0 +1,475 @@
+package sparse
+
+import (
+	"math"
+	"runtime"
+	"sync"
+)
+
+// Parallel factorization. Column j of the factors is computed from the columns k with U(k,j) nonzero,
+// so the columns are grouped in levels of this dependency DAG, each level depending only on earlier ones.
+// With Config.Workers > 1 the columns of a level are shared among a pool of goroutines, each with its own
+// scratch vectors. The pool is started on first use and kept by the matrix for the next Factor and Solve.
+// Every column is computed by the serial code, so the factors are identical, and the error reported is
+// the one of the first failing column in pivot order, as in the serial path.
+
+const PARALLEL_MIN_WIDTH = 32 // Narrower levels are run on the calling goroutine
+
+// LevelSchedule groups columns or rows in levels that can be processed concurrently
+type LevelSchedule struct {
+	Start []int   // Start of each level in Items, one more than the number of levels
+	Items []int64 // Columns or rows by level, in increasing order inside a level
+	Width int     // Items in the widest level
+}
+
+func (s *LevelSchedule) Levels() int {
+	return len(s.Start) - 1
+}
+
+func (s *LevelSchedule) Level(l int) []int64 {
+	return s.Items[s.Start[l]:s.Start[l+1]]
+}
+
+// newLevelSchedule groups items 1...len(level)-1 by level, a counting sort keeping the pivot order
+func newLevelSchedule(level []int, levels int) *LevelSchedule {
+	s := &LevelSchedule{
+		Start: make([]int, levels+1),
+		Items: make([]int64, len(level)-1),
+	}
+
+	for item := 1; item < len(level); item++ {
+		s.Start[level[item]+1]++
+	}
+	for l := 0; l < levels; l++ {
+		s.Width = max(s.Width, s.Start[l+1])
+		s.Start[l+1] += s.Start[l]
+	}
+
+	next := make([]int, levels)
+	copy(next, s.Start)
+	for item := 1; item < len(level); item++ {
+		s.Items[next[level[item]]] = int64(item)
+		next[level[item]]++
+	}
+
+	return s
+}
+
+// buildFactorLevels computes the levels of the columns factored column by column
+func (m *Matrix) buildFactorLevels() {
+	if m.Config.Workers <= 1 {
+		m.FactorLevels = nil
+		return
+	}
+
+	last := m.lastSparseStep()
+	level := make([]int, last+1) // 1-based indexing
+	levels := 0
+	for col := int64(1); col <= last; col++ {
+		for element := m.FirstInCol[col]; element != nil && element.Row < col; element = element.NextInCol {
+			level[col] = max(level[col], level[element.Row]+1)
+		}
+		levels = max(levels, level[col]+1)
+	}
+
+	m.FactorLevels = newLevelSchedule(level, levels)
+}
+
+// workerPool runs tasks on a fixed set of goroutines. A task gets the index of its goroutine,
+// which selects the scratch it may use. The goroutines do not hold the pool, so a pool dropped
+// without close is closed by its finalizer.
+type workerPool struct {
+	workers int
+	tasks   chan func(worker int)
+	done    *sync.WaitGroup
+}
+
+func newWorkerPool(workers int) *workerPool {
+	p := &workerPool{workers: workers, tasks: make(chan func(worker int)), done: &sync.WaitGroup{}}
+	tasks, done := p.tasks, p.done
+	for w := 0; w < workers; w++ {
+		go func(worker int) {
+			for task := range tasks {
+				task(worker)
+				done.Done()
+			}
+		}(w)
+	}
+	runtime.SetFinalizer(p, (*workerPool).close)
+	return p
+}
+
+// parts returns the number of parts count items are split into
+func (p *workerPool) parts(count int) int {
+	return min(count, 4*p.workers)
+}
+
+// run splits count items in contiguous parts and waits until fn has processed all of them
+func (p *workerPool) run(count int, fn func(worker, part, from, to int)) {
+	parts := p.parts(count)
+	p.done.Add(parts)
+	for part := 0; part < parts; part++ {
+		from, to := count*part/parts, count*(part+1)/parts
+		p.tasks <- func(worker int) {
+			fn(worker, part, from, to)
+		}
+	}
+	p.done.Wait()
+}
+
+func (p *workerPool) close() {
+	runtime.SetFinalizer(p, nil)
+	close(p.tasks)
+}
+
+// sharedPool returns the pool of the matrix, started again when Config.Workers changed
+func (m *Matrix) sharedPool() *workerPool {
+	if m.pool == nil || m.pool.workers != m.Config.Workers {
+		m.closePool()
+		m.pool = newWorkerPool(m.Config.Workers)
+	}
+	return m.pool
+}
+
+// closePool stops the goroutines of the pool of the matrix
+func (m *Matrix) closePool() {
+	if m.pool != nil {
+		m.pool.close()
+		m.pool = nil
+	}
+}
+
+// factorLevels factors the columns before the dense block level by level. column computes one column
+// with the scratch of a worker.
+func (m *Matrix) factorLevels(column func(worker int, step int64) error) error {
+	levels := m.FactorLevels
+	pool := m.sharedPool()
+
+	failedStep := int64(math.MaxInt64)
+	var failure error
+	fail := func(step int64, err error) {
+		if step < failedStep {
+			failedStep = step
+			failure = err
+		}
+	}
+
+	partSteps := make([]int64, pool.parts(levels.Width))
+	partErrors := make([]error, len(partSteps))
+
+	for l := 0; l < levels.Levels(); l++ {
+		cols := levels.Level(l)
+
+		// After a failure only the columns the serial path would reach are computed
+		for len(cols) > 0 && cols[len(cols)-1] >= failedStep {
+			cols = cols[:len(cols)-1]
+		}
+
+		if len(cols) < PARALLEL_MIN_WIDTH {
+			for _, step := range cols {
+				if err := column(0, step); err != nil {
+					fail(step, err)
+					break
+				}
+			}
+			continue
+		}
+
+		clear(partErrors)
+		pool.run(len(cols), func(worker, part, from, to int) {
+			for _, step := range cols[from:to] {
+				if err := column(worker, step); err != nil {
+					partSteps[part] = step
+					partErrors[part] = err
+					return
+				}
+			}
+		})
+		for part, err := range partErrors {
+			if err != nil {
+				fail(partSteps[part], err)
+			}
+		}
+	}
+
+	return failure
+}
+
+// factorRealLevels is the parallel version of the column loop of Factor
+func (m *Matrix) factorRealLevels() error {
+	workers := m.Config.Workers
+	intermediates := make([][]float64, workers)
+	dests := make([][]*float64, workers)
+	for w := range workers {
+		intermediates[w] = make([]float64, m.Size+1) // 1-based indexing
+		dests[w] = make([]*float64, m.Size+1)
+	}
+
+	return m.factorLevels(func(worker int, step int64) error {
+		return m.factorRealColumn(step, intermediates[worker], dests[worker])
+	})
+}
+
+// factorComplexLevels is the parallel version of factorComplexColumns
+func (m *Matrix) factorComplexLevels() error {
+	workers := m.Config.Workers
+	works := make([][]Element, workers)
+	dests := make([][]*Element, workers)
+	for w := range workers {
+		works[w] = make([]Element, m.Size+1) // 1-based indexing
+		dests[w] = make([]*Element, m.Size+1)
+	}
+
+	return m.factorLevels(func(worker int, step int64) error {
+		return m.factorComplexColumn(step, works[worker], dests[worker])
+	})
+}
+
+// factorCompressedLevels is the parallel version of the column loop of factorCompressed
+func (m *Matrix) factorCompressedLevels(source []ComplexNumber) error {
+	workers := m.Config.Workers
+	if m.Complex {
+		works := make([][]ComplexNumber, workers)
+		for w := range workers {
+			works[w] = make([]ComplexNumber, m.Size+1) // 1-based indexing
+		}
+		return m.factorLevels(func(worker int, step int64) error {
+			return m.factorCompressedComplexColumn(step, source, works[worker])
+		})
+	}
+
+	works := make([][]float64, workers)
+	for w := range workers {
+		works[w] = make([]float64, m.Size+1) // 1-based indexing
+	}
+	return m.factorLevels(func(worker int, step int64) error {
+		return m.factorCompressedRealColumn(step, source, works[worker])
+	})
+}
+
+// Parallel triangular solves. The substitutions are run row by row in gather form: an entry of the
+// intermediate vector is computed from entries of earlier levels only, subtracting them in the order
+// of the serial loops, so the solutions are identical. Selected when Workers > 1 and the levels of
+// both substitutions are wide enough on average.
+
+const PARALLEL_SOLVE_MIN_WIDTH = 128 // Smallest average level width for parallel solves
+
+// SolveLevels holds the level sets of the substitutions
+type SolveLevels struct {
+	Lower           *LevelSchedule // Rows of L, forward substitution of Solve
+	Upper           *LevelSchedule // Rows of U from the last, backward substitution of Solve
+	UpperTransposed *LevelSchedule // Columns of U, forward substitution of SolveTransposed
+	LowerTransposed *LevelSchedule // Columns of L from the last, backward substitution of SolveTransposed
+}
+
+func (s *LevelSchedule) wideEnough() bool {
+	return len(s.Items) >= PARALLEL_SOLVE_MIN_WIDTH*s.Levels()
+}
+
+// buildSolveLevels computes the level sets of the factors
+func (m *Matrix) buildSolveLevels() {
+	size := m.Size
+	level := make([]int, size+1) // 1-based indexing
+	levels := &SolveLevels{}
+
+	// Lower: row i needs the rows of its entries in L
+	maxLevel := 0
+	for i := int64(1); i <= size; i++ {
+		level[i] = 0
+		for element := m.FirstInRow[i]; element != nil && element.Col < i; element = element.NextInRow {
+			level[i] = max(level[i], level[element.Col]+1)
+		}
+		maxLevel = max(maxLevel, level[i]+1)
+	}
+	levels.Lower = newLevelSchedule(level, maxLevel)
+
+	// Upper: row i needs the columns of its entries in U
+	maxLevel = 0
+	for i := size; i > 0; i-- {
+		level[i] = 0
+		for element := m.Diags[i].NextInRow; element != nil; element = element.NextInRow {
+			level[i] = max(level[i], level[element.Col]+1)
+		}
+		maxLevel = max(maxLevel, level[i]+1)
+	}
+	levels.Upper = newLevelSchedule(level, maxLevel)
+
+	// UpperTransposed: column j needs the rows of its entries in U
+	maxLevel = 0
+	for j := int64(1); j <= size; j++ {
+		level[j] = 0
+		for element := m.FirstInCol[j]; element != nil && element.Row < j; element = element.NextInCol {
+			level[j] = max(level[j], level[element.Row]+1)
+		}
+		maxLevel = max(maxLevel, level[j]+1)
+	}
+	levels.UpperTransposed = newLevelSchedule(level, maxLevel)
+
+	// LowerTransposed: column i needs the rows of its entries in L
+	maxLevel = 0
+	for i := size; i > 0; i-- {
+		level[i] = 0
+		for element := m.Diags[i].NextInCol; element != nil; element = element.NextInCol {
+			level[i] = max(level[i], level[element.Row]+1)
+		}
+		maxLevel = max(maxLevel, level[i]+1)
+	}
+	levels.LowerTransposed = newLevelSchedule(level, maxLevel)
+
+	m.SolveLevels = levels
+}
+
+// parallelSolve reports whether the substitutions of Solve, or SolveTransposed, are run in parallel
+func (m *Matrix) parallelSolve(transposed bool) bool {
+	if m.Config.Workers <= 1 {
+		return false
+	}
+	if m.SolveLevels == nil {
+		m.buildSolveLevels()
+	}
+
+	if transposed {
+		return m.SolveLevels.UpperTransposed.wideEnough() && m.SolveLevels.LowerTransposed.wideEnough()
+	}
+	return m.SolveLevels.Lower.wideEnough() && m.SolveLevels.Upper.wideEnough()
+}
+
+// runLevels calls fn for every item, level by level, sharing the wide levels among the pool
+func runLevels(pool *workerPool, levels *LevelSchedule, fn func(item int64)) {
+	for l := 0; l < levels.Levels(); l++ {
+		items := levels.Level(l)
+		if len(items) < PARALLEL_MIN_WIDTH {
+			for _, item := range items {
+				fn(item)
+			}
+			continue
+		}
+
+		pool.run(len(items), func(worker, part, from, to int) {
+			for _, item := range items[from:to] {
+				fn(item)
+			}
+		})
+	}
+}
+
+// solveLevels solves LUx = b in place on the internally ordered intermediate vector
+func (m *Matrix) solveLevels(intermediate []float64) {
+	m.syncFactors()
+	pool := m.sharedPool()
+
+	// Forward elimination - Solves Lc = b
+	runLevels(pool, m.SolveLevels.Lower, func(i int64) {
+		temp := intermediate[i]
+		for element := m.FirstInRow[i]; element.Col < i; element = element.NextInRow {
+			if c := intermediate[element.Col]; c != 0.0 {
+				temp -= c * element.Real
+			}
+		}
+		if temp != 0.0 {
+			temp *= m.Diags[i].Real
+		}
+		intermediate[i] = temp
+	})
+
+	// Backward Substitution - Solves Ux = c
+	runLevels(pool, m.SolveLevels.Upper, func(i int64) {
+		temp := intermediate[i]
+		for element := m.Diags[i].NextInRow; element != nil; element = element.NextInRow {
+			temp -= element.Real * intermediate[element.Col]
+		}
+		intermediate[i] = temp
+	})
+}
+
+// solveTransposedLevels solves (LU)^T x = b in place on the internally ordered intermediate vector
+func (m *Matrix) solveTransposedLevels(intermediate []float64) {
+	m.syncFactors()
+	pool := m.sharedPool()
+
+	// Forward elimination
+	runLevels(pool, m.SolveLevels.UpperTransposed, func(j int64) {
+		temp := intermediate[j]
+		for element := m.FirstInCol[j]; element.Row < j; element = element.NextInCol {
+			if c := intermediate[element.Row]; c != 0.0 {
+				temp -= c * element.Real
+			}
+		}
+		intermediate[j] = temp
+	})
+
+	// Backward Substitution
+	runLevels(pool, m.SolveLevels.LowerTransposed, func(i int64) {
+		pivot := m.Diags[i]
+		temp := intermediate[i]
+		for element := pivot.NextInCol; element != nil; element = element.NextInCol {
+			temp -= element.Real * intermediate[element.Row]
+		}
+		intermediate[i] = temp * pivot.Real
+	})
+}
+
+// solveComplexLevels is the complex version of solveLevels on the interleaved intermediate vector
+func (m *Matrix) solveComplexLevels(intermediate []float64) {
+	m.syncFactors()
+	pool := m.sharedPool()
+
+	// Forward substitution
+	runLevels(pool, m.SolveLevels.Lower, func(i int64) {
+		temp := ComplexNumber{Real: intermediate[i*2], Imag: intermediate[i*2+1]}
+		for element := m.FirstInRow[i]; element.Col < i; element = element.NextInRow {
+			c := ComplexNumber{Real: intermediate[element.Col*2], Imag: intermediate[element.Col*2+1]}
+			if c.Real != 0.0 || c.Imag != 0.0 {
+				temp.multSubtAssign(c, ComplexNumber{Real: element.Real, Imag: element.Imag})
+			}
+		}
+		if temp.Real != 0.0 || temp.Imag != 0.0 {
+			temp.multAssign(ComplexNumber{Real: m.Diags[i].Real, Imag: m.Diags[i].Imag})
+		}
+		intermediate[i*2] = temp.Real
+		intermediate[i*2+1] = temp.Imag
+	})
+
+	// Backward substitution
+	runLevels(pool, m.SolveLevels.Upper, func(i int64) {
+		temp := ComplexNumber{Real: intermediate[i*2], Imag: intermediate[i*2+1]}
+		for element := m.Diags[i].NextInRow; element != nil; element = element.NextInRow {
+			x := ComplexNumber{Real: intermediate[element.Col*2], Imag: intermediate[element.Col*2+1]}
+			temp.multSubtAssign(ComplexNumber{Real: element.Real, Imag: element.Imag}, x)
+		}
+		intermediate[i*2] = temp.Real
+		intermediate[i*2+1] = temp.Imag
+	})
+}
+
+// solveComplexTransposedLevels is the complex version of solveTransposedLevels
+func (m *Matrix) solveComplexTransposedLevels(intermediate []float64) {
+	m.syncFactors()
+	pool := m.sharedPool()
+
+	// Forward elimination
+	runLevels(pool, m.SolveLevels.UpperTransposed, func(j int64) {
+		temp := ComplexNumber{Real: intermediate[j*2], Imag: intermediate[j*2+1]}
+		for element := m.FirstInCol[j]; element.Row < j; element = element.NextInCol {
+			c := ComplexNumber{Real: intermediate[element.Row*2], Imag: intermediate[element.Row*2+1]}
+			if c.Real != 0.0 || c.Imag != 0.0 {
+				temp.multSubtAssign(c, ComplexNumber{Real: element.Real, Imag: element.Imag})
+			}
+		}
+		intermediate[j*2] = temp.Real
+		intermediate[j*2+1] = temp.Imag
+	})
+
+	// Backward substitution
+	runLevels(pool, m.SolveLevels.LowerTransposed, func(i int64) {
+		pivot := m.Diags[i]
+		temp := ComplexNumber{Real: intermediate[i*2], Imag: intermediate[i*2+1]}
+		for element := pivot.NextInCol; element != nil; element = element.NextInCol {
+			x := ComplexNumber{Real: intermediate[element.Row*2], Imag: intermediate[element.Row*2+1]}
+			temp.multSubtAssign(x, ComplexNumber{Real: element.Real, Imag: element.Imag})
+		}
+		temp.multAssign(ComplexNumber{Real: pivot.Real, Imag: pivot.Imag})
+		intermediate[i*2] = temp.Real
+		intermediate[i*2+1] = temp.Imag
+	})
+}
@@ -0,0 +1,88 @@
+package sparse
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// testBlockDeltas returns a matrix of 2 by 2 blocks coupled by a sparse last column, so that the levels
+// are wide enough for the parallel factorization and solves
+func testBlockDeltas(n int64, isComplex bool) []ElementDelta {
+	deltas := []ElementDelta{}
+	for row := int64(1); row <= n; row++ {
+		imag := 0.0
+		if isComplex {
+			imag = 0.1 * float64(row%5)
+		}
+		deltas = append(deltas, ElementDelta{Row: row, Col: row, Real: 4.0 + float64(row%3), Imag: imag})
+		if pair := (row - 1) ^ 1 + 1; pair <= n {
+			deltas = append(deltas, ElementDelta{Row: row, Col: pair, Real: -1.0, Imag: imag})
+		}
+		if row < n && row%64 == 0 {
+			deltas = append(deltas, ElementDelta{Row: row, Col: n, Real: 0.5}, ElementDelta{Row: n, Col: row, Real: 0.25})
+		}
+	}
+	return deltas
+}
+
+func TestParallelMatchesSerial(t *testing.T) {
+	const n = 4096
+	for _, isComplex := range []bool{false, true} {
+		deltas := testBlockDeltas(n, isComplex)
+		serial, _ := testMatrix(t, n, deltas, Configuration{Real: true, Complex: isComplex})
+		parallel, elements := testMatrix(t, n, deltas, Configuration{Real: true, Complex: isComplex, Workers: 4})
+		for _, m := range []*Matrix{serial, parallel} {
+			if err := m.Factor(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !parallel.parallelSolve(false) || !parallel.parallelSolve(true) {
+			t.Fatal("levels too narrow for parallel solves")
+		}
+
+		// Refactored twice with the same values, the second time on the pool of the first
+		var pool *workerPool
+		for i := 0; i < 2; i++ {
+			testStamp(parallel, elements, deltas)
+			if err := parallel.Factor(); err != nil {
+				t.Fatal(err)
+			}
+			if pool == nil {
+				pool = parallel.pool
+			}
+		}
+		if pool == nil || parallel.pool != pool {
+			t.Fatal("worker pool not kept by the matrix")
+		}
+
+		b := testRHS(n)
+		for _, transposed := range []bool{false, true} {
+			x, y := testSolution(t, serial, b, transposed), testSolution(t, parallel, b, transposed)
+			for i := range x {
+				if x[i] != y[i] {
+					t.Fatalf("transposed %v: parallel solution differs at %d: %v, want %v", transposed, i, y[i], x[i])
+				}
+			}
+		}
+	}
+}
+
+func TestParallelPoolClosed(t *testing.T) {
+	const n = 1024
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		m, _ := testMatrix(t, n, testBlockDeltas(n, false), Configuration{Real: true, Workers: 4})
+		if err := m.Factor(); err != nil {
+			t.Fatal(err)
+		}
+		testSolution(t, m, testRHS(n), false)
+		m.Destroy()
+	}
+	for wait := 0; runtime.NumGoroutine() > before; wait++ {
+		if wait == 100 {
+			t.Fatalf("%d goroutines left running after Destroy", runtime.NumGoroutine()-before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,332 @@
+package sparse
+
+import (
+	"errors"
+	"slices"
+)
+
+// Partial refactorization. When Config.PartialFactor is set, the first factorization keeps the
+// unfactored values in OriginalValues, and the elements hold the factors from then on. Stamps go
+// through AddToElement or the templates, which add to the unfactored values and flag the column at
+// stamp time, or MarkChanged after a direct assignment. Clear zeroes the unfactored values and
+// keeps the factors, and Factor compares the restamped columns with the previous values. Factor
+// then recomputes the columns of L and U that depend on the changed ones, or the whole matrix when
+// they carry more than PARTIAL_MAX_FRACTION of the operations of the factorization.
+
+const PARTIAL_MAX_FRACTION = 0.5 // Largest fraction of the factorization operations done by a partial Factor
+
+// errNoChange is the error of Factor on a factored matrix with no recorded change
+var errNoChange = errors.New("matrix already factored, no change recorded by Clear, AddToElement or MarkChanged")
+
+// AddToElement adds a value to element. Once PartialFactor has kept the unfactored values, the
+// elements hold the factors: the value is added to the unfactored one and the column is flagged
+// for the next Factor. Stamp with AddToElement or the templates then, not through the element fields.
+func (m *Matrix) AddToElement(element *Element, real, imag float64) {
+	m.Factored = false
+
+	if i := m.keptIndex(element); i >= 0 {
+		m.OriginalValues[i].Real += real
+		m.OriginalValues[i].Imag += imag
+		if !m.cleared {
+			m.ChangedCols[element.Col] = true
+		}
+		return
+	}
+
+	element.Real += real
+	element.Imag += imag
+}
+
+// MarkChanged records the current value of element as its new unfactored value and flags its column.
+// Assign the new value to the element returned by GetElement, then call MarkChanged before Factor.
+func (m *Matrix) MarkChanged(element *Element) {
+	if element == nil {
+		return
+	}
+
+	m.Factored = false
+
+	if i := m.keptIndex(element); i >= 0 {
+		m.OriginalValues[i] = ComplexNumber{Real: element.Real, Imag: element.Imag}
+		m.ChangedCols[element.Col] = true
+	}
+}
+
+// keptIndex returns the position of element in the kept unfactored values, or -1 when the element
+// holds its own value
+func (m *Matrix) keptIndex(element *Element) int {
+	if !m.Config.PartialFactor || m.OriginalValues == nil || element.index <= 0 {
+		return -1
+	}
+	return element.index - 1
+}
+
+// ChangedColumnCount returns the number of columns flagged by AddToElement or MarkChanged since the last factorization
+func (m *Matrix) ChangedColumnCount() int {
+	if m.OriginalValues == nil {
+		return 0
+	}
+
+	count := 0
+	for col := int64(1); col <= m.Size; col++ {
+		if m.ChangedCols[col] {
+			count++
+		}
+	}
+	return count
+}
+
+// captureValues returns the unfactored value of every element before a reordering, which moves
+// elements between columns, and numbers the elements by their position in it. Elements holding
+// factors get their kept unfactored value back.
+func (m *Matrix) captureValues() []ComplexNumber {
+	kept := m.Config.PartialFactor && m.OriginalValues != nil
+
+	values := make([]ComplexNumber, 0, m.Elements)
+	for col := int64(1); col <= m.Size; col++ {
+		for element := m.FirstInCol[col]; element != nil; element = element.NextInCol {
+			if kept && element.index > 0 {
+				element.Real = m.OriginalValues[element.index-1].Real
+				element.Imag = m.OriginalValues[element.index-1].Imag
+			}
+			values = append(values, ComplexNumber{Real: element.Real, Imag: element.Imag})
+			element.index = len(values)
+		}
+	}
+	return values
+}
+
+func (m *Matrix) saveOriginalValues() {
+	m.saveOriginalValuesFrom(nil)
+}
+
+// saveOriginalValuesFrom stores the unfactored values in column order and numbers the elements by
+// their position. Values are taken from the given ones captured before reordering when not nil,
+// elements added since (fill-ins) are zero.
+func (m *Matrix) saveOriginalValuesFrom(values []ComplexNumber) {
+	size := m.Size
+
+	if len(m.OriginalStart) != int(size+2) {
+		m.OriginalStart = make([]int, size+2)
+	}
+	if len(m.ChangedCols) != int(size+1) {
+		m.ChangedCols = make([]bool, size+1)
+	} else {
+		clear(m.ChangedCols)
+	}
+
+	original := make([]ComplexNumber, 0, m.Elements)
+	for col := int64(1); col <= size; col++ {
+		m.OriginalStart[col] = len(original)
+		for element := m.FirstInCol[col]; element != nil; element = element.NextInCol {
+			switch {
+			case values == nil:
+				original = append(original, ComplexNumber{Real: element.Real, Imag: element.Imag})
+			case element.index > 0:
+				original = append(original, values[element.index-1])
+			default:
+				original = append(original, ComplexNumber{})
+			}
+			element.index = len(original)
+		}
+	}
+	m.OriginalStart[size+1] = len(original)
+
+	m.OriginalValues = original
+	m.previousValues = nil
+	m.cleared = false
+	if m.Config.PartialFactor && m.columnOps == nil {
+		m.countColumnOps()
+	}
+}
+
+// clearKept clears the kept unfactored values for a restamp and tells whether they are kept. The
+// elements keep the factors, and the previous values are set aside for markRestamped. Otherwise,
+// or when elements were added since the last factorization, the unfactored values are dropped and
+// the caller clears the elements.
+func (m *Matrix) clearKept(initialize bool) bool {
+	if !m.Config.PartialFactor || m.OriginalValues == nil || m.NeedsOrdering {
+		m.OriginalValues = nil
+		m.previousValues = nil
+		m.cleared = false
+		return false
+	}
+
+	// Cleared again before Factor, the previous values still match the factors
+	if !m.cleared {
+		if len(m.previousValues) != len(m.OriginalValues) {
+			m.previousValues = make([]ComplexNumber, len(m.OriginalValues))
+		}
+		m.OriginalValues, m.previousValues = m.previousValues, m.OriginalValues
+		m.cleared = true
+	}
+	clear(m.OriginalValues)
+
+	if initialize {
+		for col := int64(1); col <= m.Size; col++ {
+			for element := m.FirstInCol[col]; element != nil; element = element.NextInCol {
+				if element.InitInfo != nil {
+					m.OriginalValues[element.index-1] = *element.InitInfo
+				}
+			}
+		}
+	}
+	return true
+}
+
+// markRestamped flags the columns whose values restamped since Clear differ from the previous ones
+func (m *Matrix) markRestamped() {
+	if !m.cleared {
+		return
+	}
+	m.cleared = false
+
+	for col := int64(1); col <= m.Size; col++ {
+		start, end := m.OriginalStart[col], m.OriginalStart[col+1]
+		if !m.ChangedCols[col] && !slices.Equal(m.OriginalValues[start:end], m.previousValues[start:end]) {
+			m.ChangedCols[col] = true
+		}
+	}
+}
+
+// restoreValues puts the unfactored values back into every column
+func (m *Matrix) restoreValues() {
+	for col := int64(1); col <= m.Size; col++ {
+		m.restoreColumn(col)
+	}
+}
+
+// restoreColumn puts the unfactored values back into a column
+func (m *Matrix) restoreColumn(col int64) {
+	i := m.OriginalStart[col]
+	for element := m.FirstInCol[col]; element != nil; element = element.NextInCol {
+		element.Real = m.OriginalValues[i].Real
+		element.Imag = m.OriginalValues[i].Imag
+		i++
+	}
+}
+
+// countColumnOps counts the multiplications of each column of the factorization, once per ordering.
+// Column j costs a multiplication per element of L below the pivot of each column k with U(k,j)
+// nonzero, and one per element of its own L.
+func (m *Matrix) countColumnOps() {
+	size := m.Size
+	lower := make([]int64, size+1)
+	for col := int64(1); col <= size; col++ {
+		for element := m.FirstInCol[col]; element != nil; element = element.NextInCol {
+			if element.Row > col {
+				lower[col]++
+			}
+		}
+	}
+
+	ops := make([]int64, size+1)
+	for col := int64(1); col <= size; col++ {
+		ops[col] = lower[col]
+		for element := m.FirstInCol[col]; element != nil && element.Row < col; element = element.NextInCol {
+			ops[col] += lower[element.Row]
+		}
+		ops[0] += ops[col]
+	}
+	m.columnOps = ops
+}
+
+// markReachable flags the columns reachable from the changed columns and returns their multiplications
+func (m *Matrix) markReachable() int64 {
+	if m.columnOps == nil {
+		m.countColumnOps()
+	}
+
+	size := m.Size
+	changed := m.ChangedCols
+
+	// Column j of the factors depends on column k whenever U(k,j) is nonzero
+	last := m.lastSparseStep()
+	ops := int64(0)
+	for step := int64(1); step <= last; step++ {
+		if !changed[step] {
+			continue
+		}
+		ops += m.columnOps[step]
+		for element := m.Diags[step].NextInRow; element != nil; element = element.NextInRow {
+			changed[element.Col] = true
+		}
+	}
+
+	// The dense block is factored as a whole
+	dense := false
+	for step := last + 1; step <= size; step++ {
+		dense = dense || changed[step]
+	}
+	if dense {
+		for step := last + 1; step <= size; step++ {
+			ops += m.columnOps[step]
+		}
+	}
+	return ops
+}
+
+// partialWorthwhile tells whether the reachable columns carry few enough operations for a partial Factor
+func (m *Matrix) partialWorthwhile(ops int64) bool {
+	return float64(ops) <= PARTIAL_MAX_FRACTION*float64(m.columnOps[0])
+}
+
+// factorChangedColumns refactors only the columns flagged by markReachable, in pivot order
+func (m *Matrix) factorChangedColumns() error {
+	size := m.Size
+	changed := m.ChangedCols
+
+	last := m.lastSparseStep()
+	dense := false
+	for step := last + 1; step <= size; step++ {
+		dense = dense || changed[step]
+	}
+
+	matrixSize := size + 1 // 1-based indexing
+
+	var dest []*float64
+	var work []Element
+	var complexDest []*Element
+	if m.Complex {
+		work = make([]Element, matrixSize)
+		complexDest = make([]*Element, matrixSize)
+	} else {
+		dest = make([]*float64, matrixSize)
+	}
+
+	m.PartialColumns = 0
+	for step := int64(1); step <= last; step++ {
+		if !changed[step] {
+			continue
+		}
+
+		m.restoreColumn(step)
+
+		var err error
+		if m.Complex {
+			err = m.factorComplexColumn(step, work, complexDest)
+		} else {
+			err = m.factorRealColumn(step, m.Intermediate, dest)
+		}
+		if err != nil {
+			return err
+		}
+
+		changed[step] = false
+		m.PartialColumns++
+	}
+
+	if dense {
+		for step := last + 1; step <= size; step++ {
+			m.restoreColumn(step)
+			changed[step] = false
+			m.PartialColumns++
+		}
+		if err := m.factorDenseBlock(dest, complexDest); err != nil {
+			return err
+		}
+	}
+
+	m.Factored = true
+	return nil
+}
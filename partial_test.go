@@ -0,0 +1,150 @@
+package sparse
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+// testLastPivots returns the indexes in elements of the pivots of the last count steps of m
+func testLastPivots(m *Matrix, elements []*Element, count int64) []int {
+	indexes := []int{}
+	for step := m.Size - count + 1; step <= m.Size; step++ {
+		indexes = append(indexes, slices.Index(elements, m.Diags[step]))
+	}
+	return indexes
+}
+
+func TestPartialFactor(t *testing.T) {
+	const n = 200
+	for _, isComplex := range []bool{false, true} {
+		for _, compressed := range []bool{false, true} {
+			for _, monitor := range []bool{false, true} {
+				config := Configuration{Real: true, Complex: isComplex, Compressed: compressed, MonitorPivots: monitor}
+				deltas := testDeltas(n, 3, isComplex, 2)
+				full, fullElements := testMatrix(t, n, deltas, config)
+				config.PartialFactor = true
+				m, elements := testMatrix(t, n, deltas, config)
+				for _, matrix := range []*Matrix{full, m} {
+					if err := matrix.Factor(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				b := testRHS(n)
+
+				// Changes added to the factored matrix, then restamped after Clear, then restamped unchanged
+				changed := deltas
+				for round, add := range []bool{true, false, false} {
+					pivots := testLastPivots(m, elements, 3)
+					if round < 2 {
+						changed = slices.Clone(changed)
+						for _, i := range pivots {
+							changed[i].Real += 0.5
+						}
+					}
+					if add {
+						for _, i := range pivots {
+							m.AddToElement(elements[i], 0.5, 0.0)
+						}
+						if count := m.ChangedColumnCount(); count != len(pivots) {
+							t.Fatalf("%d changed columns, want %d", count, len(pivots))
+						}
+					} else {
+						testStamp(m, elements, changed)
+					}
+					testStamp(full, fullElements, changed)
+
+					for _, matrix := range []*Matrix{full, m} {
+						if err := matrix.Factor(); err != nil {
+							t.Fatal(err)
+						}
+					}
+					if m.LastFactorReordered {
+						t.Fatalf("round %d reordered", round)
+					}
+					if round < 2 && (m.PartialColumns < len(pivots) || m.PartialColumns >= n/2) {
+						t.Fatalf("round %d: %d partial columns", round, m.PartialColumns)
+					}
+					if round == 2 && m.PartialColumns != 0 {
+						t.Fatalf("unchanged restamp: %d partial columns", m.PartialColumns)
+					}
+
+					x, want := testSolution(t, m, b, false), testSolution(t, full, b, false)
+					if !slices.Equal(x, want) {
+						t.Fatalf("complex %v compressed %v monitor %v round %d: partial differs from full", isComplex, compressed, monitor, round)
+					}
+					testClose(t, "partial", x, testReference(t, n, changed, isComplex, b, false), 1e-9)
+				}
+			}
+		}
+	}
+}
+
+func TestPartialNoChange(t *testing.T) {
+	const n = 40
+	deltas := testDeltas(n, 3, false, 3)
+	m, elements := testMatrix(t, n, deltas, Configuration{Real: true, PartialFactor: true})
+	if err := m.Factor(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Assigned without MarkChanged, the change is not seen
+	element := elements[testLastPivots(m, elements, 1)[0]]
+	element.Real = 2.0
+	if err := m.Factor(); err != errNoChange {
+		t.Fatalf("Factor with no recorded change returned %v", err)
+	}
+
+	m.MarkChanged(element)
+	if err := m.Factor(); err != nil {
+		t.Fatal(err)
+	}
+	if m.PartialColumns != 1 {
+		t.Fatalf("%d partial columns, want 1", m.PartialColumns)
+	}
+	changed := slices.Clone(deltas)
+	changed[slices.Index(elements, element)].Real = 2.0
+	b := testRHS(n)
+	testClose(t, "marked", testSolution(t, m, b, false), testReference(t, n, changed, false, b, false), 1e-9)
+}
+
+func TestPartialCheckpoint(t *testing.T) {
+	const n = 40
+	deltas := testDeltas(n, 3, true, 4)
+	b := testRHS(n)
+
+	// Saved with changes pending, restamped after Clear or added
+	for _, restamp := range []bool{true, false} {
+		m, elements := testMatrix(t, n, deltas, Configuration{Real: true, Complex: true, PartialFactor: true})
+		if err := m.Factor(); err != nil {
+			t.Fatal(err)
+		}
+		changed := slices.Clone(deltas)
+		pivot := testLastPivots(m, elements, 1)[0]
+		changed[pivot].Imag += 1.0
+		if restamp {
+			testStamp(m, elements, changed)
+		} else {
+			m.AddToElement(elements[pivot], 0.0, 1.0)
+		}
+
+		var buffer bytes.Buffer
+		if _, err := m.WriteTo(&buffer); err != nil {
+			t.Fatal(err)
+		}
+		loaded := &Matrix{}
+		if _, err := loaded.ReadFrom(&buffer); err != nil {
+			t.Fatal(err)
+		}
+		for _, matrix := range []*Matrix{m, loaded} {
+			if err := matrix.Factor(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if !slices.Equal(testSolution(t, loaded, b, false), testSolution(t, m, b, false)) {
+			t.Fatalf("restamp %v: checkpoint with pending changes differs", restamp)
+		}
+		testClose(t, "checkpoint", testSolution(t, loaded, b, false), testReference(t, n, changed, true, b, false), 1e-9)
+	}
+}
@@ -0,0 +1,191 @@
+package sparse
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// Adjoint sensitivity. For the solution x of A x = b and an output y = cᵀx, the derivative of y by a
+// parameter p of A and b is
+//   ∂y/∂p = λᵀ (∂b/∂p - ∂A/∂p x),  Aᵀ λ = c
+// so one transposed solve with the factors of A gives the sensitivities of y to every parameter, each
+// costing only a product with the derivatives of its stamps. For complex matrices cᵀ is not conjugated.
+
+// Parameter is the derivative of the stamps of A x = b by one parameter, in external indexing.
+// Row or column 0 is ground and is ignored.
+type Parameter struct {
+	Name   string
+	Matrix []ElementDelta // ∂A/∂p
+	RHS    []ElementDelta // ∂b/∂p at Row, Col being unused
+}
+
+// Sensitivity returns ∂y/∂p for every parameter of y = Σ output[i]·x[i], x being the solution of the
+// factored matrix. solution and output are in the layout of the vectors of Solve or SolveComplex, and so
+// are the results: one value per parameter, isolution, ioutput and the imaginary parts being nil unless
+// SeparatedComplexVectors, real and imaginary parts interleaved otherwise.
+func (m *Matrix) Sensitivity(solution, isolution, output, ioutput []float64, parameters []Parameter) ([]float64, []float64, error) {
+	if !m.Factored {
+		return nil, nil, fmt.Errorf("matrix is not factored")
+	}
+
+	size := m.GetSize(true)
+	x, err := m.sensitivityVector(solution, isolution, size)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := m.sensitivityVector(output, ioutput, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lambda, err := m.solveVector(c, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make([]complex128, len(parameters))
+	for i, p := range parameters {
+		if values[i], err = p.adjoint(lambda, x); err != nil {
+			return nil, nil, err
+		}
+	}
+	re, im := m.fromComplexVector(values, 0)
+	return re, im, nil
+}
+
+// SensitivityDifference returns the central finite differences of y = Σ output[i]·x[i] by every
+// parameter, to check Sensitivity. rhs is b, in the layout of Solve or SolveComplex. A ± step·∂A/∂p is
+// solved as a low-rank update of the factored matrix, which is left unchanged. step is relative to the
+// largest derivative of each parameter, 1e-6 when 0.
+func (m *Matrix) SensitivityDifference(rhs, irhs, output, ioutput []float64, parameters []Parameter, step float64) ([]float64, []float64, error) {
+	if !m.Factored {
+		return nil, nil, fmt.Errorf("matrix is not factored")
+	}
+	if step <= 0.0 {
+		step = 1e-6
+	}
+
+	size := m.GetSize(true)
+	b, err := m.sensitivityVector(rhs, irhs, size)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := m.sensitivityVector(output, ioutput, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// y at A + h·∂A/∂p, b + h·∂b/∂p
+	solve := func(p Parameter, h float64) (complex128, error) {
+		bh := make([]complex128, len(b))
+		copy(bh, b)
+		for _, delta := range p.RHS {
+			if delta.Row > 0 && delta.Row <= size {
+				bh[delta.Row] += complex(h*delta.Real, h*delta.Imag)
+			}
+		}
+
+		deltas := make([]ElementDelta, 0, len(p.Matrix))
+		for _, delta := range p.Matrix {
+			if delta.Row > 0 && delta.Col > 0 {
+				deltas = append(deltas, ElementDelta{Row: delta.Row, Col: delta.Col, Real: h * delta.Real, Imag: h * delta.Imag})
+			}
+		}
+		if len(deltas) == 0 {
+			return m.output(c, bh, nil)
+		}
+		u, err := m.Update(deltas)
+		if err != nil {
+			return 0.0, err
+		}
+		return m.output(c, bh, u)
+	}
+
+	values := make([]complex128, len(parameters))
+	for i, p := range parameters {
+		scale := 0.0
+		for _, deltas := range [][]ElementDelta{p.Matrix, p.RHS} {
+			for _, delta := range deltas {
+				scale = math.Max(scale, cmplx.Abs(complex(delta.Real, delta.Imag)))
+			}
+		}
+		if scale == 0.0 {
+			continue
+		}
+		h := step / scale
+
+		up, err := solve(p, h)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", p.Name, err)
+		}
+		down, err := solve(p, -h)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", p.Name, err)
+		}
+		values[i] = (up - down) / complex(2.0*h, 0.0)
+	}
+	re, im := m.fromComplexVector(values, 0)
+	return re, im, nil
+}
+
+// output returns cᵀx for the solution x of b, with the factors of the matrix or with update when it is not nil
+func (m *Matrix) output(c, b []complex128, update *LowRankUpdate) (complex128, error) {
+	var x []complex128
+	if update == nil {
+		var err error
+		if x, err = m.solveVector(b, false); err != nil {
+			return 0.0, err
+		}
+	} else {
+		rhs, irhs := m.fromComplexVector(b, 0)
+		xr, xi, err := update.solve(rhs, irhs, false)
+		if err != nil {
+			return 0.0, err
+		}
+		if x, err = m.toComplexVector(xr, xi); err != nil {
+			return 0.0, err
+		}
+	}
+
+	y := complex128(0.0)
+	for i := 1; i < len(c) && i < len(x); i++ {
+		y += c[i] * x[i]
+	}
+	return y, nil
+}
+
+// adjoint returns λᵀ (∂b/∂p - ∂A/∂p x)
+func (p *Parameter) adjoint(lambda, x []complex128) (complex128, error) {
+	size := int64(len(x) - 1)
+	value := complex128(0.0)
+	for _, delta := range p.RHS {
+		if delta.Row < 0 || delta.Row > size {
+			return 0.0, fmt.Errorf("%s: invalid row %d", p.Name, delta.Row)
+		}
+		if delta.Row > 0 {
+			value += lambda[delta.Row] * complex(delta.Real, delta.Imag)
+		}
+	}
+	for _, delta := range p.Matrix {
+		if delta.Row < 0 || delta.Col < 0 || delta.Row > size || delta.Col > size {
+			return 0.0, fmt.Errorf("%s: invalid index (%d,%d)", p.Name, delta.Row, delta.Col)
+		}
+		if delta.Row > 0 && delta.Col > 0 {
+			value -= lambda[delta.Row] * complex(delta.Real, delta.Imag) * x[delta.Col]
+		}
+	}
+	return value, nil
+}
+
+// sensitivityVector converts a vector in the layout of the matrix to complex values by external index,
+// padded to size
+func (m *Matrix) sensitivityVector(rhs, irhs []float64, size int64) ([]complex128, error) {
+	x, err := m.toComplexVector(rhs, irhs)
+	if err != nil {
+		return nil, err
+	}
+	v := make([]complex128, size+1) // 1-based indexing
+	copy(v, x)
+	return v, nil
+}
@@ -0,0 +1,571 @@
+package sparse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Binary checkpoint of a matrix. Little-endian, starting with SERIAL_MAGIC and SERIAL_VERSION,
+// then the configuration, the status, the vectors, the elements column by column, fill-ins included,
+// and the ground row. Version 1 has neither GroundRow config nor ground row.
+// A loaded matrix keeps its ordering and factors, so Solve gives the same results bit for bit.
+
+const (
+	SERIAL_MAGIC   string = "SPRS"
+	SERIAL_VERSION int64  = 2
+	SERIAL_CHUNK   int64  = 1 << 16 // Longest run of a vector read at once when loading
+)
+
+const (
+	serialInitInfo byte = 1 << iota // Element has InitInfo
+	serialDiag                      // Element is in Diags
+)
+
+// MarshalBinary implements encoding.BinaryMarshaler
+func (m *Matrix) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. m is left unchanged on error.
+func (m *Matrix) UnmarshalBinary(data []byte) error {
+	_, err := m.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+// WriteTo writes the checkpoint of the matrix to w
+func (m *Matrix) WriteTo(w io.Writer) (int64, error) {
+	m.syncFactors()
+	e := &encoder{w: w}
+
+	e.write([]byte(SERIAL_MAGIC))
+	e.write(SERIAL_VERSION)
+
+	bools, ints, int64s, floats := configFields(&m.Config, SERIAL_VERSION)
+	encodeFields(e, bools, ints, int64s, floats)
+	bools, ints, int64s, floats = matrixFields(m)
+	encodeFields(e, bools, ints, int64s, floats)
+	e.write(m.PivotSelectionMethod)
+	e.write(m.CSC != nil)
+	e.write(m.FactorLevels != nil)
+
+	encodeSlice(e, m.DoRealDirect)
+	encodeSlice(e, m.DoComplexDirect)
+	encodeSlice(e, m.MarkowitzRow)
+	encodeSlice(e, m.MarkowitzCol)
+	encodeSlice(e, m.MarkowitzProd)
+	encodeSlice(e, m.IntToExtRowMap)
+	encodeSlice(e, m.IntToExtColMap)
+	encodeSlice(e, m.ExtToIntRowMap)
+	encodeSlice(e, m.ExtToIntColMap)
+	encodeSlice(e, m.ChangedCols)
+	// Elements holding the factors of changed unfactored values are saved with their unfactored
+	// value, for a full Factor
+	original := m.OriginalValues
+	unfactored := m.Config.PartialFactor && original != nil && !m.Factored
+	if unfactored {
+		original = nil
+	}
+	encodeSlice(e, complexParts(original))
+	encodeSlice(e, int64Slice(m.OriginalStart))
+	e.length(len(m.Intermediate), m.Intermediate == nil)
+
+	// Elements
+	e.length(len(m.FirstInCol), m.FirstInCol == nil)
+	for col := int64(1); col <= m.Size; col++ {
+		count := int64(0)
+		for element := m.FirstInCol[col]; element != nil; element = element.NextInCol {
+			count++
+		}
+		e.write(count)
+
+		for element := m.FirstInCol[col]; element != nil; element = element.NextInCol {
+			flags := byte(0)
+			if m.Diags[element.Row] == element {
+				flags |= serialDiag
+			}
+			value := ComplexNumber{Real: element.Real, Imag: element.Imag}
+			if unfactored && element.index > 0 {
+				value = m.OriginalValues[element.index-1]
+			}
+			e.write(element.Row)
+			e.value(element, value, flags)
+		}
+	}
+
+	// Ground row
+	count := int64(0)
+	for _, element := range m.GroundRow {
+		if element != nil {
+			count++
+		}
+	}
+	e.length(len(m.GroundRow), m.GroundRow == nil)
+	e.write(count)
+	for col, element := range m.GroundRow {
+		if element != nil {
+			e.write(int64(col))
+			e.value(element, ComplexNumber{Real: element.Real, Imag: element.Imag}, 0)
+		}
+	}
+
+	return e.n, e.err
+}
+
+// ReadFrom replaces the matrix with the checkpoint read from r. m is left unchanged on error.
+func (m *Matrix) ReadFrom(r io.Reader) (int64, error) {
+	d := &decoder{r: r}
+
+	magic := make([]byte, len(SERIAL_MAGIC))
+	d.read(magic)
+	if d.err == nil && string(magic) != SERIAL_MAGIC {
+		return d.n, fmt.Errorf("not a matrix checkpoint")
+	}
+	var version int64
+	d.read(&version)
+	if d.err == nil && (version < 1 || version > SERIAL_VERSION) {
+		return d.n, fmt.Errorf("unsupported checkpoint version: %d", version)
+	}
+
+	c := &Matrix{}
+	bools, ints, int64s, floats := configFields(&c.Config, version)
+	decodeFields(d, bools, ints, int64s, floats)
+	bools, ints, int64s, floats = matrixFields(c)
+	decodeFields(d, bools, ints, int64s, floats)
+	d.read(&c.PivotSelectionMethod)
+	var compressed, factorLevels bool
+	d.read(&compressed)
+	d.read(&factorLevels)
+
+	c.DoRealDirect = decodeSlice[bool](d)
+	c.DoComplexDirect = decodeSlice[bool](d)
+	c.MarkowitzRow = decodeSlice[int64](d)
+	c.MarkowitzCol = decodeSlice[int64](d)
+	c.MarkowitzProd = decodeSlice[int64](d)
+	c.IntToExtRowMap = decodeSlice[int64](d)
+	c.IntToExtColMap = decodeSlice[int64](d)
+	c.ExtToIntRowMap = decodeSlice[int64](d)
+	c.ExtToIntColMap = decodeSlice[int64](d)
+	c.ChangedCols = decodeSlice[bool](d)
+	c.OriginalValues = complexNumbers(decodeSlice[float64](d))
+	c.OriginalStart = intSlice(decodeSlice[int64](d))
+	intermediate := d.length()
+	length := d.length()
+	if d.err != nil {
+		return d.n, fmt.Errorf("reading checkpoint: %v", d.err)
+	}
+	if err := checkVectors(c, intermediate, length); err != nil {
+		return d.n, err
+	}
+
+	// Elements. Columns are appended as they are read, so a corrupt size fails at the end of the input.
+	c.FirstInCol = make([]*Element, 1, min(c.Size+1, SERIAL_CHUNK))
+	diags := []*Element{}
+	count := 0
+	matching := c.OriginalStart != nil
+	for col := int64(1); col <= c.Size; col++ {
+		var elements int64
+		d.read(&elements)
+		if d.err != nil {
+			break
+		}
+
+		c.FirstInCol = append(c.FirstInCol, nil)
+		link := &c.FirstInCol[col]
+		row := int64(0)
+		start := count
+		for ; elements > 0 && d.err == nil; elements-- {
+			element := &Element{Col: col}
+			d.read(&element.Row)
+			flags := d.value(element)
+			if d.err != nil {
+				break
+			}
+			if element.Row <= row || element.Row > c.Size {
+				return d.n, fmt.Errorf("invalid checkpoint element row %d in column %d", element.Row, col)
+			}
+			if flags&serialDiag != 0 {
+				if element.Row != col {
+					return d.n, fmt.Errorf("invalid checkpoint diagonal at (%d,%d)", element.Row, col)
+				}
+				diags = append(diags, element)
+			}
+			row = element.Row
+			count++
+			element.index = count
+
+			*link = element
+			link = &element.NextInCol
+		}
+		if matching && (c.OriginalStart[col] != start || c.OriginalStart[col+1] != count) {
+			matching = false
+		}
+	}
+	if d.err != nil {
+		return d.n, fmt.Errorf("reading checkpoint: %v", d.err)
+	}
+	if length < 0 {
+		c.FirstInCol = nil
+	} else {
+		c.Diags = make([]*Element, length)
+		c.FirstInRow = make([]*Element, length)
+		for _, element := range diags {
+			c.Diags[element.Row] = element
+		}
+	}
+	if c.Elements != count || c.Fillins < 0 || c.Fillins > count {
+		return d.n, fmt.Errorf("invalid checkpoint element count %d, %d read", c.Elements, count)
+	}
+	if intermediate >= 0 {
+		c.Intermediate = make([]float64, intermediate)
+	}
+	if c.Factored {
+		for step := int64(1); step <= c.Size; step++ {
+			if c.Diags[step] == nil {
+				return d.n, fmt.Errorf("invalid checkpoint, factored matrix without diagonal %d", step)
+			}
+		}
+	}
+	if c.OriginalValues != nil && (!matching || len(c.OriginalValues) != count) {
+		// Saved for a structure changed since, and dropped by the reordering it needs
+		if !c.NeedsOrdering {
+			return d.n, fmt.Errorf("invalid checkpoint, unfactored values do not match the elements")
+		}
+		c.OriginalValues = nil
+	}
+	if c.OriginalValues != nil && c.ChangedCols == nil {
+		c.ChangedCols = make([]bool, c.Size+1)
+	}
+
+	// Ground row
+	if version >= 2 {
+		if length := d.length(); length > max(c.Size, c.ExtSize)+1 {
+			return d.n, fmt.Errorf("invalid checkpoint ground row length %d", length)
+		} else if length >= 0 {
+			c.GroundRow = make([]*Element, length)
+		}
+		var count int64
+		d.read(&count)
+		for ; count > 0 && d.err == nil; count-- {
+			element := &Element{}
+			d.read(&element.Col)
+			d.value(element)
+			if d.err != nil {
+				break
+			}
+			if element.Col < 1 || element.Col >= int64(len(c.GroundRow)) {
+				return d.n, fmt.Errorf("invalid checkpoint ground row column %d", element.Col)
+			}
+			c.GroundRow[element.Col] = element
+		}
+	}
+	if d.err != nil {
+		return d.n, fmt.Errorf("reading checkpoint: %v", d.err)
+	}
+	c.TrashCan = &Element{}
+
+	if c.RowsLinked {
+		c.LinkRows()
+	}
+	if compressed {
+		c.buildCompressed()
+	}
+	if factorLevels {
+		c.buildFactorLevels()
+	}
+
+	m.closePool()
+	*m = *c
+	return d.n, nil
+}
+
+// configFields returns pointers to the fields of a configuration, in checkpoint order
+func configFields(c *Configuration, version int64) ([]*bool, []*int, []*int64, []*float64) {
+	bools := []*bool{
+		&c.Real, &c.Complex, &c.SeparatedComplexVectors,
+		&c.Expandable, &c.Translate, &c.Initialize, &c.DiagonalPivoting, &c.ArrayOffset,
+		&c.ModifiedMarkowitz, &c.Delete, &c.Strip, &c.ModifiedNodal, &c.QuadElement, &c.Transpose,
+		&c.Scaling, &c.Documentation, &c.Stability, &c.Condition, &c.PseudoCondition, &c.Determinant,
+		&c.Multiplication, &c.Fortran, &c.Debug, &c.PartialFactor, &c.MonitorPivots, &c.Compressed,
+		&c.DiagPivotingAsDefault,
+	}
+	if version >= 2 {
+		bools = append(bools, &c.GroundRow)
+	}
+	ints := []*int{
+		&c.SpaceForElements, &c.SpaceForFillIns, &c.ElementsPerAllocation, &c.MinimumAllocatedSize,
+		&c.TiesMultiplier, &c.Workers, &c.DefaultPartition, &c.PrinterWidth, &c.Annotate,
+	}
+	int64s := []*int64{&c.MaxMarkowitzTies}
+	floats := []*float64{
+		&c.DefaultThreshold, &c.ExpansionFactor, &c.UpdateRCondLimit, &c.GrowthLimit, &c.DenseThreshold,
+	}
+
+	return bools, ints, int64s, floats
+}
+
+// matrixFields returns pointers to the status fields of a matrix, in checkpoint order
+func matrixFields(m *Matrix) ([]*bool, []*int, []*int64, []*float64) {
+	bools := []*bool{
+		&m.Complex, &m.NeedsOrdering, &m.NumberOfInterchangesIsOdd, &m.Partitioned, &m.Factored,
+		&m.Reordered, &m.RowsLinked, &m.InternalVectorsAllocated, &m.LastFactorReordered,
+	}
+	ints := []*int{
+		&m.OperationCount, &m.Elements, &m.Fillins, &m.Singletons, &m.PartialColumns, &m.FallbackReorders,
+	}
+	int64s := []*int64{
+		&m.Size, &m.ExtSize, &m.CurrentSize, &m.MaxRowCountInLowerTri, &m.SingularRow, &m.SingularCol,
+		&m.PivotsOriginalRow, &m.PivotsOriginalCol, &m.DenseStep,
+	}
+	floats := []*float64{&m.RelThreshold, &m.AbsThreshold}
+
+	return bools, ints, int64s, floats
+}
+
+type encoder struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (e *encoder) write(data any) {
+	if e.err != nil {
+		return
+	}
+	if e.err = binary.Write(e.w, binary.LittleEndian, data); e.err == nil {
+		e.n += int64(binary.Size(data))
+	}
+}
+
+// length writes the length of a vector, -1 for nil
+func (e *encoder) length(length int, isNil bool) {
+	if isNil {
+		e.write(int64(-1))
+	} else {
+		e.write(int64(length))
+	}
+}
+
+// value writes the value, flags and InitInfo of an element
+func (e *encoder) value(element *Element, value ComplexNumber, flags byte) {
+	if element.InitInfo != nil {
+		flags |= serialInitInfo
+	}
+	e.write(value.Real)
+	e.write(value.Imag)
+	e.write(flags)
+	if element.InitInfo != nil {
+		e.write(element.InitInfo.Real)
+		e.write(element.InitInfo.Imag)
+	}
+}
+
+func encodeFields(e *encoder, bools []*bool, ints []*int, int64s []*int64, floats []*float64) {
+	for _, b := range bools {
+		e.write(*b)
+	}
+	for _, i := range ints {
+		e.write(int64(*i))
+	}
+	for _, i := range int64s {
+		e.write(*i)
+	}
+	for _, f := range floats {
+		e.write(*f)
+	}
+}
+
+func encodeSlice[T bool | int64 | float64](e *encoder, s []T) {
+	e.length(len(s), s == nil)
+	if len(s) > 0 {
+		e.write(s)
+	}
+}
+
+type decoder struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (d *decoder) read(data any) {
+	if d.err != nil {
+		return
+	}
+	if d.err = binary.Read(d.r, binary.LittleEndian, data); d.err == nil {
+		d.n += int64(binary.Size(data))
+	}
+}
+
+// length reads the length of a vector, -1 for nil
+func (d *decoder) length() int64 {
+	var length int64
+	d.read(&length)
+	if d.err != nil {
+		return -1
+	}
+	if length < -1 {
+		d.err = fmt.Errorf("invalid vector length: %d", length)
+		return -1
+	}
+	return length
+}
+
+// value reads the value and InitInfo of an element and returns its flags
+func (d *decoder) value(element *Element) byte {
+	var flags byte
+	d.read(&element.Real)
+	d.read(&element.Imag)
+	d.read(&flags)
+	if flags&serialInitInfo != 0 {
+		element.InitInfo = &ComplexNumber{}
+		d.read(&element.InitInfo.Real)
+		d.read(&element.InitInfo.Imag)
+	}
+	return flags
+}
+
+func decodeFields(d *decoder, bools []*bool, ints []*int, int64s []*int64, floats []*float64) {
+	for _, b := range bools {
+		d.read(b)
+	}
+	for _, i := range ints {
+		var value int64
+		d.read(&value)
+		*i = int(value)
+	}
+	for _, i := range int64s {
+		d.read(i)
+	}
+	for _, f := range floats {
+		d.read(f)
+	}
+}
+
+// decodeSlice reads a vector in runs of at most SERIAL_CHUNK, so that a corrupt length fails at the end
+// of the input instead of allocating for it
+func decodeSlice[T bool | int64 | float64](d *decoder) []T {
+	length := d.length()
+	if length < 0 {
+		return nil
+	}
+	s := make([]T, 0, min(length, SERIAL_CHUNK))
+	for int64(len(s)) < length && d.err == nil {
+		chunk := make([]T, min(length-int64(len(s)), SERIAL_CHUNK))
+		d.read(chunk)
+		s = append(s, chunk...)
+	}
+	return s
+}
+
+// checkVectors fails when a loaded vector does not fit the size of the matrix. intermediate and
+// columns are the lengths of Intermediate and FirstInCol, -1 for nil.
+func checkVectors(c *Matrix, intermediate, columns int64) error {
+	size, extSize := c.Size, c.ExtSize
+	if size < 0 || extSize < 0 || c.DenseStep < 0 || c.DenseStep > size {
+		return fmt.Errorf("invalid checkpoint size: %d", size)
+	}
+
+	lengths := []struct {
+		name   string
+		length int
+		isNil  bool
+		want   int64
+	}{
+		{"DoRealDirect", len(c.DoRealDirect), c.DoRealDirect == nil, size + 1},
+		{"DoComplexDirect", len(c.DoComplexDirect), c.DoComplexDirect == nil, size + 1},
+		{"MarkowitzRow", len(c.MarkowitzRow), c.MarkowitzRow == nil, size + 1},
+		{"MarkowitzCol", len(c.MarkowitzCol), c.MarkowitzCol == nil, size + 1},
+		{"MarkowitzProd", len(c.MarkowitzProd), c.MarkowitzProd == nil, size + 2},
+		{"IntToExtRowMap", len(c.IntToExtRowMap), c.IntToExtRowMap == nil && size == 0, size + 1},
+		{"IntToExtColMap", len(c.IntToExtColMap), c.IntToExtColMap == nil && size == 0, size + 1},
+		{"ExtToIntRowMap", len(c.ExtToIntRowMap), c.ExtToIntRowMap == nil && size == 0, extSize + 1},
+		{"ExtToIntColMap", len(c.ExtToIntColMap), c.ExtToIntColMap == nil && size == 0, extSize + 1},
+		{"ChangedCols", len(c.ChangedCols), c.ChangedCols == nil, size + 1},
+		{"OriginalStart", len(c.OriginalStart), c.OriginalStart == nil, size + 2},
+		{"FirstInCol", int(columns), columns < 0 && size == 0, size + 1},
+	}
+	for _, vector := range lengths {
+		if !vector.isNil && int64(vector.length) != vector.want {
+			return fmt.Errorf("invalid checkpoint %s length %d for size %d", vector.name, vector.length, size)
+		}
+	}
+	if intermediate >= 0 && (intermediate < size+1 || intermediate > 2*(size+1)) {
+		return fmt.Errorf("invalid checkpoint Intermediate length %d for size %d", intermediate, size)
+	}
+
+	indexes := []struct {
+		name          string
+		vector        []int64
+		lowest, limit int64
+	}{
+		{"IntToExtRowMap", c.IntToExtRowMap, 0, max(size, extSize)},
+		{"IntToExtColMap", c.IntToExtColMap, 0, max(size, extSize)},
+		{"ExtToIntRowMap", c.ExtToIntRowMap, -1, size},
+		{"ExtToIntColMap", c.ExtToIntColMap, -1, size},
+	}
+	for _, vector := range indexes {
+		for i, index := range vector.vector {
+			if index < vector.lowest || index > vector.limit {
+				return fmt.Errorf("invalid checkpoint %s index %d at %d", vector.name, index, i)
+			}
+		}
+	}
+	for col := int64(1); col <= size && c.OriginalStart != nil; col++ {
+		if c.OriginalStart[col] < 0 || c.OriginalStart[col] > c.OriginalStart[col+1] {
+			return fmt.Errorf("invalid checkpoint OriginalStart of column %d", col)
+		}
+	}
+
+	return nil
+}
+
+// complexParts flattens complex numbers to real and imaginary parts, nil staying nil
+func complexParts(values []ComplexNumber) []float64 {
+	if values == nil {
+		return nil
+	}
+	parts := make([]float64, 0, 2*len(values))
+	for _, value := range values {
+		parts = append(parts, value.Real, value.Imag)
+	}
+	return parts
+}
+
+func complexNumbers(parts []float64) []ComplexNumber {
+	if parts == nil {
+		return nil
+	}
+	values := make([]ComplexNumber, len(parts)/2)
+	for i := range values {
+		values[i] = ComplexNumber{Real: parts[2*i], Imag: parts[2*i+1]}
+	}
+	return values
+}
+
+func int64Slice(s []int) []int64 {
+	if s == nil {
+		return nil
+	}
+	converted := make([]int64, len(s))
+	for i, value := range s {
+		converted[i] = int64(value)
+	}
+	return converted
+}
+
+func intSlice(s []int64) []int {
+	if s == nil {
+		return nil
+	}
+	converted := make([]int, len(s))
+	for i, value := range s {
+		converted[i] = int(value)
+	}
+	return converted
+}
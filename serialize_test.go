@@ -0,0 +1,96 @@
+package sparse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// testCheckpoint returns a factored matrix and its checkpoint
+func testCheckpoint(t *testing.T, n int64, isComplex bool, config Configuration) (*Matrix, []byte) {
+	t.Helper()
+
+	config.Real, config.Complex = true, isComplex
+	m, _ := testMatrix(t, n, testDeltas(n, 3, isComplex, 5), config)
+	if config.GroundRow {
+		m.GetElement(0, 1).Real += 1.0
+		m.GetElement(0, n).Real -= 1.0
+	}
+	if err := m.Factor(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, data
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	const n = 40
+	configs := []Configuration{{}, {GroundRow: true}, {PartialFactor: true, MonitorPivots: true}, {Compressed: true}, {Workers: 2}}
+	for _, isComplex := range []bool{false, true} {
+		for _, config := range configs {
+			m, data := testCheckpoint(t, n, isComplex, config)
+
+			loaded := &Matrix{}
+			if err := loaded.UnmarshalBinary(data); err != nil {
+				t.Fatal(err)
+			}
+			b := testRHS(n)
+			x, y := testSolution(t, m, b, false), testSolution(t, loaded, b, false)
+			for i := range x {
+				if x[i] != y[i] {
+					t.Fatalf("%+v: loaded solution differs at %d: %v, want %v", config, i, y[i], x[i])
+				}
+			}
+
+			again, err := loaded.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, data) {
+				t.Fatalf("%+v: checkpoint of the loaded matrix differs", config)
+			}
+		}
+	}
+}
+
+func TestCheckpointTruncated(t *testing.T) {
+	_, data := testCheckpoint(t, 10, true, Configuration{GroundRow: true, PartialFactor: true})
+	for length := 0; length < len(data); length++ {
+		m := &Matrix{}
+		if err := m.UnmarshalBinary(data[:length]); err == nil {
+			t.Fatalf("checkpoint truncated to %d of %d bytes loaded", length, len(data))
+		}
+		if m.Size != 0 || m.FirstInCol != nil {
+			t.Fatalf("matrix changed by a failed load")
+		}
+	}
+}
+
+func TestCheckpointCorrupt(t *testing.T) {
+	_, data := testCheckpoint(t, 10, false, Configuration{PartialFactor: true})
+
+	// Every int64 replaced by a huge length must fail without allocating for it
+	for offset := 0; offset+8 <= len(data); offset++ {
+		corrupt := bytes.Clone(data)
+		binary.LittleEndian.PutUint64(corrupt[offset:], 1<<50)
+		m := &Matrix{}
+		_ = m.UnmarshalBinary(corrupt)
+	}
+
+	// Random bytes must fail or load a matrix that solves
+	rnd := rand.New(rand.NewSource(6))
+	for i := 0; i < 2000; i++ {
+		corrupt := bytes.Clone(data)
+		for k := 0; k < 3; k++ {
+			corrupt[rnd.Intn(len(corrupt))] = byte(rnd.Intn(256))
+		}
+		m := &Matrix{}
+		if err := m.UnmarshalBinary(corrupt); err == nil && m.Factored {
+			_, _ = m.solveVector(testRHS(m.Size), false)
+		}
+	}
+}
@@ -35,31 +35,37 @@ func (m *Matrix) Solve(rhs []float64) (solution []float64, err error) {
 		intermediate[i] = rhs[intToExtRowMap[i]]
 	}
 
-	// Forward elimination - Solves Lc = b
-	for i := int64(1); i <= size; i++ {
-		temp := intermediate[i]
-		if temp != 0.0 {
-			pivot := diags[i]
-			if pivot == nil {
-				return nil, fmt.Errorf("nil diagonal element at %d", i)
-			}
-			temp *= pivot.Real
-			intermediate[i] = temp
+	if m.parallelSolve(false) {
+		m.solveLevels(intermediate)
+	} else if m.CSC != nil {
+		m.CSC.solve(intermediate, size)
+	} else {
+		// Forward elimination - Solves Lc = b
+		for i := int64(1); i <= size; i++ {
+			temp := intermediate[i]
+			if temp != 0.0 {
+				pivot := diags[i]
+				if pivot == nil {
+					return nil, fmt.Errorf("nil diagonal element at %d", i)
+				}
+				temp *= pivot.Real
+				intermediate[i] = temp
 
-			for element := pivot.NextInCol; element != nil; element = element.NextInCol {
-				intermediate[element.Row] -= temp * element.Real
+				for element := pivot.NextInCol; element != nil; element = element.NextInCol {
+					intermediate[element.Row] -= temp * element.Real
+				}
 			}
 		}
-	}
 
-	// Backward Substitution - Solves Ux = c
-	for i := size; i > 0; i-- {
-		temp := intermediate[i]
+		// Backward Substitution - Solves Ux = c
+		for i := size; i > 0; i-- {
+			temp := intermediate[i]
 
-		for element := diags[i].NextInRow; element != nil; element = element.NextInRow {
-			temp -= element.Real * intermediate[element.Col]
+			for element := diags[i].NextInRow; element != nil; element = element.NextInRow {
+				temp -= element.Real * intermediate[element.Col]
+			}
+			intermediate[i] = temp
 		}
-		intermediate[i] = temp
 	}
 
 	// Unscramble Intermediate vector - reorder from internal to external ordering
@@ -102,31 +108,37 @@ func (m *Matrix) SolveTransposed(rhs []float64) (solution []float64, err error)
 		intermediate[i] = rhs[intToExtColMap[i]]
 	}
 
-	// Forward elimination
-	for i := int64(1); i <= size; i++ {
-		temp := intermediate[i]
-		if temp != 0.0 {
-			pivot := diags[i]
-			if pivot == nil {
-				return nil, fmt.Errorf("nil diagonal element at %d", i)
-			}
+	if m.parallelSolve(true) {
+		m.solveTransposedLevels(intermediate)
+	} else if m.CSC != nil {
+		m.CSC.solveTransposed(intermediate, size)
+	} else {
+		// Forward elimination
+		for i := int64(1); i <= size; i++ {
+			temp := intermediate[i]
+			if temp != 0.0 {
+				pivot := diags[i]
+				if pivot == nil {
+					return nil, fmt.Errorf("nil diagonal element at %d", i)
+				}
 
-			for element := pivot.NextInRow; element != nil; element = element.NextInRow {
-				intermediate[element.Col] -= temp * element.Real
+				for element := pivot.NextInRow; element != nil; element = element.NextInRow {
+					intermediate[element.Col] -= temp * element.Real
+				}
 			}
 		}
-	}
 
-	// Backward Substitution
-	for i := size; i > 0; i-- {
-		pivot := diags[i]
-		temp := intermediate[i]
+		// Backward Substitution
+		for i := size; i > 0; i-- {
+			pivot := diags[i]
+			temp := intermediate[i]
 
-		for element := pivot.NextInCol; element != nil; element = element.NextInCol {
-			temp -= element.Real * intermediate[element.Row]
-		}
+			for element := pivot.NextInCol; element != nil; element = element.NextInCol {
+				temp -= element.Real * intermediate[element.Row]
+			}
 
-		intermediate[i] = temp * pivot.Real
+			intermediate[i] = temp * pivot.Real
+		}
 	}
 
 	for i := size; i > 0; i-- {
@@ -174,49 +186,55 @@ func (m *Matrix) SolveComplex(rhs, irhs []float64) ([]float64, []float64, error)
 		}
 	}
 
-	// Forward substitution
-	for i := int64(1); i <= size; i++ {
-		temp := &Element{
-			Real: m.Intermediate[i*2],
-			Imag: m.Intermediate[i*2+1],
-		}
-
-		if temp.Real != 0.0 || temp.Imag != 0.0 {
-			pivot := m.Diags[i]
-			m.complexMultAssign(temp, pivot)
-
-			m.Intermediate[i*2] = temp.Real
-			m.Intermediate[i*2+1] = temp.Imag
+	if m.parallelSolve(false) {
+		m.solveComplexLevels(m.Intermediate)
+	} else if m.CSC != nil {
+		m.CSC.solveComplex(m.Intermediate, size)
+	} else {
+		// Forward substitution
+		for i := int64(1); i <= size; i++ {
+			temp := &Element{
+				Real: m.Intermediate[i*2],
+				Imag: m.Intermediate[i*2+1],
+			}
 
-			for element := pivot.NextInCol; element != nil; element = element.NextInCol {
-				interm := &Element{
-					Real: m.Intermediate[element.Row*2],
-					Imag: m.Intermediate[element.Row*2+1],
+			if temp.Real != 0.0 || temp.Imag != 0.0 {
+				pivot := m.Diags[i]
+				m.complexMultAssign(temp, pivot)
+
+				m.Intermediate[i*2] = temp.Real
+				m.Intermediate[i*2+1] = temp.Imag
+
+				for element := pivot.NextInCol; element != nil; element = element.NextInCol {
+					interm := &Element{
+						Real: m.Intermediate[element.Row*2],
+						Imag: m.Intermediate[element.Row*2+1],
+					}
+					m.complexMultSubtAssign(interm, temp, element)
+					m.Intermediate[element.Row*2] = interm.Real
+					m.Intermediate[element.Row*2+1] = interm.Imag
 				}
-				m.complexMultSubtAssign(interm, temp, element)
-				m.Intermediate[element.Row*2] = interm.Real
-				m.Intermediate[element.Row*2+1] = interm.Imag
 			}
 		}
-	}
 
-	// Backward substitution
-	for i := size; i > 0; i-- {
-		temp := &Element{
-			Real: m.Intermediate[i*2],
-			Imag: m.Intermediate[i*2+1],
-		}
+		// Backward substitution
+		for i := size; i > 0; i-- {
+			temp := &Element{
+				Real: m.Intermediate[i*2],
+				Imag: m.Intermediate[i*2+1],
+			}
 
-		for element := m.Diags[i].NextInRow; element != nil; element = element.NextInRow {
-			interm := &Element{
-				Real: m.Intermediate[element.Col*2],
-				Imag: m.Intermediate[element.Col*2+1],
+			for element := m.Diags[i].NextInRow; element != nil; element = element.NextInRow {
+				interm := &Element{
+					Real: m.Intermediate[element.Col*2],
+					Imag: m.Intermediate[element.Col*2+1],
+				}
+				m.complexMultSubtAssign(temp, element, interm)
 			}
-			m.complexMultSubtAssign(temp, element, interm)
-		}
 
-		m.Intermediate[i*2] = temp.Real
-		m.Intermediate[i*2+1] = temp.Imag
+			m.Intermediate[i*2] = temp.Real
+			m.Intermediate[i*2+1] = temp.Imag
+		}
 	}
 
 	if m.Config.SeparatedComplexVectors {
@@ -279,45 +297,51 @@ func (m *Matrix) SolveComplexTransposed(rhs, irhs []float64) ([]float64, []float
 		}
 	}
 
-	// Forward elimination
-	for i := int64(1); i <= size; i++ {
-		temp := &Element{
-			Real: m.Intermediate[i*2],
-			Imag: m.Intermediate[i*2+1],
-		}
+	if m.parallelSolve(true) {
+		m.solveComplexTransposedLevels(m.Intermediate)
+	} else if m.CSC != nil {
+		m.CSC.solveComplexTransposed(m.Intermediate, size)
+	} else {
+		// Forward elimination
+		for i := int64(1); i <= size; i++ {
+			temp := &Element{
+				Real: m.Intermediate[i*2],
+				Imag: m.Intermediate[i*2+1],
+			}
 
-		if temp.Real != 0.0 || temp.Imag != 0.0 {
-			for element := m.Diags[i].NextInRow; element != nil; element = element.NextInRow {
-				interm := &Element{
-					Real: m.Intermediate[element.Col*2],
-					Imag: m.Intermediate[element.Col*2+1],
+			if temp.Real != 0.0 || temp.Imag != 0.0 {
+				for element := m.Diags[i].NextInRow; element != nil; element = element.NextInRow {
+					interm := &Element{
+						Real: m.Intermediate[element.Col*2],
+						Imag: m.Intermediate[element.Col*2+1],
+					}
+					m.complexMultSubtAssign(interm, temp, element)
+					m.Intermediate[element.Col*2] = interm.Real
+					m.Intermediate[element.Col*2+1] = interm.Imag
 				}
-				m.complexMultSubtAssign(interm, temp, element)
-				m.Intermediate[element.Col*2] = interm.Real
-				m.Intermediate[element.Col*2+1] = interm.Imag
 			}
 		}
-	}
 
-	// Backward substitution
-	for i := size; i > 0; i-- {
-		pivot := m.Diags[i]
-		temp := &Element{
-			Real: m.Intermediate[i*2],
-			Imag: m.Intermediate[i*2+1],
-		}
+		// Backward substitution
+		for i := size; i > 0; i-- {
+			pivot := m.Diags[i]
+			temp := &Element{
+				Real: m.Intermediate[i*2],
+				Imag: m.Intermediate[i*2+1],
+			}
 
-		for element := pivot.NextInCol; element != nil; element = element.NextInCol {
-			interm := &Element{
-				Real: m.Intermediate[element.Row*2],
-				Imag: m.Intermediate[element.Row*2+1],
+			for element := pivot.NextInCol; element != nil; element = element.NextInCol {
+				interm := &Element{
+					Real: m.Intermediate[element.Row*2],
+					Imag: m.Intermediate[element.Row*2+1],
+				}
+				m.complexMultSubtAssign(temp, interm, element)
 			}
-			m.complexMultSubtAssign(temp, interm, element)
-		}
 
-		m.complexMultAssign(temp, pivot)
-		m.Intermediate[i*2] = temp.Real
-		m.Intermediate[i*2+1] = temp.Imag
+			m.complexMultAssign(temp, pivot)
+			m.Intermediate[i*2] = temp.Real
+			m.Intermediate[i*2+1] = temp.Imag
+		}
 	}
 
 	if m.Config.SeparatedComplexVectors {
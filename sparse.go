@@ -14,6 +14,8 @@ func checkConfig(config *Configuration) *Configuration {
 		ModifiedNodal:           true,
 		DefaultThreshold:        1.0e-3,
 		TiesMultiplier:          5,
+		UpdateRCondLimit:        1.0e-10,
+		GrowthLimit:             1.0e10,
 		DefaultPartition:        AUTO_PARTITION,
 		PrinterWidth:            80,
 		Annotate:                0,
@@ -29,6 +31,12 @@ func checkConfig(config *Configuration) *Configuration {
 	if config.TiesMultiplier <= 0 {
 		config.TiesMultiplier = 5
 	}
+	if config.UpdateRCondLimit <= 0.0 {
+		config.UpdateRCondLimit = 1.0e-10
+	}
+	if config.GrowthLimit <= 0.0 {
+		config.GrowthLimit = 1.0e10
+	}
 	if config.DefaultPartition == DEFAULT_PARTITION {
 		config.DefaultPartition = AUTO_PARTITION
 	}
@@ -71,7 +79,7 @@ func Create(size int64, config *Configuration) (*Matrix, error) {
 		NeedsOrdering:   true,
 		RelThreshold:    config.DefaultThreshold,
 		AbsThreshold:    0.0,
-		// TrashCan:        &Element{NextInRow: nil, NextInCol: nil},
+		TrashCan:        &Element{NextInRow: nil, NextInCol: nil},
 	}
 
 	if err := m.CreateInternalVectors(); err != nil {
@@ -99,17 +107,20 @@ func (m *Matrix) SetInitInfo(element *Element, value *ComplexNumber) {
 }
 
 func (m *Matrix) Initialize() error {
-	for j := int64(1); j <= m.Size; j++ {
-		element := m.FirstInCol[j]
-		for element != nil {
-			if element.InitInfo == nil {
-				element.Real = 0.0
-				element.Imag = 0.0
-			} else {
-				element.Real = element.InitInfo.Real
-				element.Imag = element.InitInfo.Imag
+	// With kept unfactored values the elements keep the factors
+	if !m.clearKept(true) {
+		for j := int64(1); j <= m.Size; j++ {
+			element := m.FirstInCol[j]
+			for element != nil {
+				if element.InitInfo == nil {
+					element.Real = 0.0
+					element.Imag = 0.0
+				} else {
+					element.Real = element.InitInfo.Real
+					element.Imag = element.InitInfo.Imag
+				}
+				element = element.NextInCol
 			}
-			element = element.NextInCol
 		}
 	}
 
@@ -117,21 +128,36 @@ func (m *Matrix) Initialize() error {
 	m.SingularCol = 0
 	m.SingularRow = 0
 
-	// m.TrashCan.Real = 0.0
-	// m.TrashCan.Imag = 0.0
+	m.TrashCan.Real = 0.0
+	m.TrashCan.Imag = 0.0
+	for _, element := range m.GroundRow {
+		if element == nil {
+			continue
+		}
+		if element.InitInfo == nil {
+			element.Real = 0.0
+			element.Imag = 0.0
+		} else {
+			element.Real = element.InitInfo.Real
+			element.Imag = element.InitInfo.Imag
+		}
+	}
 
 	return nil
 }
 
 func (m *Matrix) Clear() {
-	for i := m.Size; i > 0; i-- {
-		element := m.FirstInCol[i]
-		for element != nil {
-			element.Real = 0.0
-			if m.Complex {
-				element.Imag = 0.0
+	// With kept unfactored values the elements keep the factors
+	if !m.clearKept(false) {
+		for i := m.Size; i > 0; i-- {
+			element := m.FirstInCol[i]
+			for element != nil {
+				element.Real = 0.0
+				if m.Complex {
+					element.Imag = 0.0
+				}
+				element = element.NextInCol
 			}
-			element = element.NextInCol
 		}
 	}
 
@@ -139,8 +165,14 @@ func (m *Matrix) Clear() {
 	m.SingularCol = 0
 	m.SingularRow = 0
 
-	// m.TrashCan.Real = 0.0
-	// m.TrashCan.Imag = 0.0
+	m.TrashCan.Real = 0.0
+	m.TrashCan.Imag = 0.0
+	for _, element := range m.GroundRow {
+		if element != nil {
+			element.Real = 0.0
+			element.Imag = 0.0
+		}
+	}
 }
 
 func (m *Matrix) Destroy() {
@@ -160,6 +192,16 @@ func (m *Matrix) Destroy() {
 	m.MarkowitzCol = nil
 	m.MarkowitzProd = nil
 
+	m.ChangedCols = nil
+	m.OriginalValues = nil
+	m.previousValues = nil
+	m.columnOps = nil
+	m.OriginalStart = nil
+	m.CSC = nil
+	m.FactorLevels = nil
+	m.SolveLevels = nil
+	m.closePool()
+
 	m.Elements = 0
 
 	m.Size = 0
@@ -174,6 +216,7 @@ func (m *Matrix) Destroy() {
 	m.SingularCol = 0
 
 	m.Fillins = 0
+	m.DenseStep = 0
 
 	m.PivotsOriginalRow = 0
 	m.PivotsOriginalCol = 0
@@ -181,13 +224,14 @@ func (m *Matrix) Destroy() {
 
 	m.Singletons = 0
 
-	// m.TrashCan.Row = 0
-	// m.TrashCan.Col = 0
-	// m.TrashCan.Real = 0.0
-	// m.TrashCan.Imag = 0.0
-	// m.TrashCan.NextInRow = nil
-	// m.TrashCan.NextInCol = nil
-	// m.TrashCan.InitInfo = nil
+	m.TrashCan.Row = 0
+	m.TrashCan.Col = 0
+	m.TrashCan.Real = 0.0
+	m.TrashCan.Imag = 0.0
+	m.TrashCan.NextInRow = nil
+	m.TrashCan.NextInCol = nil
+	m.TrashCan.InitInfo = nil
+	m.GroundRow = nil
 }
 
 func (m *Matrix) createElement(row, col int64, firstInRow, firstInCol **Element, fillin bool) *Element {
@@ -291,8 +335,7 @@ func (m *Matrix) GetElement(row, col int64) *Element {
 		return nil
 	}
 	if row == 0 || col == 0 {
-		return &Element{}
-		// return m.TrashCan
+		return m.groundElement(row, col)
 	}
 
 	internalRow, internalCol := row, col
@@ -338,7 +381,56 @@ func (m *Matrix) GetElement(row, col int64) *Element {
 	return m.createElement(internalRow, internalCol, &m.FirstInRow[internalRow], &m.FirstInCol[internalCol], false)
 }
 
+// findElement returns the element at internal row and col, or nil when it does not exist
+func (m *Matrix) findElement(row, col int64) *Element {
+	if row < 1 || col < 1 || row > m.Size || col > m.Size {
+		return nil
+	}
+
+	element := m.FirstInCol[col]
+	for element != nil && element.Row < row {
+		element = element.NextInCol
+	}
+	if element != nil && element.Row == row {
+		return element
+	}
+	return nil
+}
+
+// internalIndex converts external row and col to internal ones without creating translations
+func (m *Matrix) internalIndex(extRow, extCol int64) (int64, int64, error) {
+	if extRow < 1 || extCol < 1 {
+		return 0, 0, fmt.Errorf("invalid index (%d,%d)", extRow, extCol)
+	}
+
+	if m.Config.Translate {
+		if extRow > m.ExtSize || extCol > m.ExtSize || m.ExtToIntRowMap[extRow] <= 0 || m.ExtToIntColMap[extCol] <= 0 {
+			return 0, 0, fmt.Errorf("index (%d,%d) is not in the matrix", extRow, extCol)
+		}
+		return m.ExtToIntRowMap[extRow], m.ExtToIntColMap[extCol], nil
+	}
+
+	if extRow > m.Size || extCol > m.Size {
+		return 0, 0, fmt.Errorf("index (%d,%d) is not in the matrix", extRow, extCol)
+	}
+	if !m.Reordered {
+		return extRow, extCol, nil
+	}
+
+	var row, col int64
+	for i := int64(1); i <= m.Size; i++ {
+		if m.IntToExtRowMap[i] == extRow {
+			row = i
+		}
+		if m.IntToExtColMap[i] == extCol {
+			col = i
+		}
+	}
+	return row, col, nil
+}
+
 func (m *Matrix) GetAdmittance(node1, node2 int64, template *Template) error {
+	template.matrix = m
 	template.Element1 = m.GetElement(node1, node1)
 	template.Element2 = m.GetElement(node2, node2)
 	template.Element3Negated = m.GetElement(node2, node1)
@@ -355,6 +447,46 @@ func (m *Matrix) GetAdmittance(node1, node2 int64, template *Template) error {
 	return nil
 }
 
+// GetQuad fills template with the elements of a quad, (row1,col1) and (row2,col2) being added to
+// and (row2,col1) and (row1,col2) subtracted from by the Add*Quad functions, as for a controlled source.
+// Row or column 0 is ground.
+func (m *Matrix) GetQuad(row1, row2, col1, col2 int64, template *Template) error {
+	template.matrix = m
+	template.Element1 = m.GetElement(row1, col1)
+	template.Element2 = m.GetElement(row2, col2)
+	template.Element3Negated = m.GetElement(row2, col1)
+	template.Element4Negated = m.GetElement(row1, col2)
+
+	if template.Element1 == nil || template.Element2 == nil || template.Element3Negated == nil || template.Element4Negated == nil {
+		return fmt.Errorf("memory allocation failed")
+	}
+
+	if row1 == 0 || col1 == 0 {
+		template.Element1, template.Element2 = template.Element2, template.Element1
+	}
+
+	return nil
+}
+
+// GetOnes fills template with the elements of the branch equation of a voltage source or inductor
+// between nodes pos and neg, and adds the ones: +1 at (branch,pos) and (pos,branch), -1 at (branch,neg)
+// and (neg,branch). Clear removes them, add them again with AddRealQuad(1.0). Node 0 is ground.
+func (m *Matrix) GetOnes(pos, neg, branch int64, template *Template) error {
+	template.matrix = m
+	template.Element4Negated = m.GetElement(neg, branch)
+	template.Element3Negated = m.GetElement(branch, neg)
+	template.Element2 = m.GetElement(pos, branch)
+	template.Element1 = m.GetElement(branch, pos)
+
+	if template.Element1 == nil || template.Element2 == nil || template.Element3Negated == nil || template.Element4Negated == nil {
+		return fmt.Errorf("memory allocation failed")
+	}
+
+	template.AddRealQuad(1.0)
+
+	return nil
+}
+
 func (m *Matrix) LinkRows() {
 	for col := m.Size; col >= 1; col-- {
 		m.FirstInRow[col] = nil
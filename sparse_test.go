@@ -0,0 +1,113 @@
+package sparse
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+)
+
+// testDeltas returns the entries of a random n by n matrix with a strong diagonal and about perRow
+// off-diagonal entries per row, complex when imag
+func testDeltas(n int64, perRow int, imag bool, seed int64) []ElementDelta {
+	rnd := rand.New(rand.NewSource(seed))
+	value := func() (float64, float64) {
+		re, im := rnd.Float64()*2.0-1.0, 0.0
+		if imag {
+			im = rnd.Float64()*2.0 - 1.0
+		}
+		return re, im
+	}
+
+	deltas := []ElementDelta{}
+	seen := map[[2]int64]bool{}
+	for row := int64(1); row <= n; row++ {
+		re, im := value()
+		deltas = append(deltas, ElementDelta{Row: row, Col: row, Real: re + float64(perRow+1), Imag: im})
+		seen[[2]int64{row, row}] = true
+		for k := 0; k < perRow; k++ {
+			col := rnd.Int63n(n) + 1
+			if seen[[2]int64{row, col}] {
+				continue
+			}
+			seen[[2]int64{row, col}] = true
+			re, im := value()
+			deltas = append(deltas, ElementDelta{Row: row, Col: col, Real: re, Imag: im})
+		}
+	}
+	return deltas
+}
+
+// testMatrix creates a matrix of size n with the elements of deltas, stamped with their values
+func testMatrix(t *testing.T, n int64, deltas []ElementDelta, config Configuration) (*Matrix, []*Element) {
+	t.Helper()
+
+	m, err := Create(n, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elements := make([]*Element, len(deltas))
+	for i, delta := range deltas {
+		if elements[i] = m.GetElement(delta.Row, delta.Col); elements[i] == nil {
+			t.Fatalf("failed to get element (%d,%d)", delta.Row, delta.Col)
+		}
+	}
+	testStamp(m, elements, deltas)
+	return m, elements
+}
+
+// testStamp clears m and stamps the values of deltas into elements
+func testStamp(m *Matrix, elements []*Element, deltas []ElementDelta) {
+	m.Clear()
+	for i, delta := range deltas {
+		m.AddToElement(elements[i], delta.Real, delta.Imag)
+	}
+}
+
+// testRHS returns a right hand side of size n in external indexing
+func testRHS(n int64) []complex128 {
+	b := make([]complex128, n+1) // 1-based indexing
+	for i := int64(1); i <= n; i++ {
+		b[i] = complex(float64(i%7)+1.0, float64(i%3))
+	}
+	return b
+}
+
+// testSolution solves b with the factors of m, the imaginary parts being dropped for a real matrix
+func testSolution(t *testing.T, m *Matrix, b []complex128, transposed bool) []complex128 {
+	t.Helper()
+
+	x, err := m.solveVector(b, transposed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x[:len(b)]
+}
+
+// testReference factors a fresh matrix of deltas by OrderAndFactor and solves b with it
+func testReference(t *testing.T, n int64, deltas []ElementDelta, isComplex bool, b []complex128, transposed bool) []complex128 {
+	t.Helper()
+
+	m, _ := testMatrix(t, n, deltas, Configuration{Real: true, Complex: isComplex})
+	if err := m.OrderAndFactor(nil, 0.0, -1.0, true); err != nil {
+		t.Fatal(err)
+	}
+	return testSolution(t, m, b, transposed)
+}
+
+// testClose fails when x differs from want by more than tol relative to the largest magnitude of want
+func testClose(t *testing.T, name string, x, want []complex128, tol float64) {
+	t.Helper()
+
+	if len(x) != len(want) {
+		t.Fatalf("%s: length %d, want %d", name, len(x), len(want))
+	}
+	norm, diff := 0.0, 0.0
+	for i := range want {
+		norm = math.Max(norm, cmplx.Abs(want[i]))
+		diff = math.Max(diff, cmplx.Abs(x[i]-want[i]))
+	}
+	if diff > tol*norm {
+		t.Fatalf("%s: relative difference %g exceeds %g", name, diff/norm, tol)
+	}
+}
@@ -0,0 +1,337 @@
+package spice
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/edp1096/sparse/mna"
+)
+
+// Analysis commands to mna options
+
+// Command returns the last dot command of a name, nil if there is none
+func (n *Netlist) Command(name string) *Command {
+	for i := len(n.Commands) - 1; i >= 0; i-- {
+		if n.Commands[i].Name == name {
+			return &n.Commands[i]
+		}
+	}
+	return nil
+}
+
+// Options returns the key = value pairs of the .options commands, flags without a value being 1
+func (n *Netlist) Options() (map[string]string, error) {
+	options := make(map[string]string)
+	for _, command := range n.Commands {
+		if command.Name != "options" && command.Name != "option" {
+			continue
+		}
+		args := command.Args
+		for i := 0; i < len(args); i++ {
+			if i+2 < len(args) && args[i+1] == "=" {
+				options[args[i]] = args[i+2]
+				i += 2
+				continue
+			}
+			if args[i] == "=" {
+				return nil, fmt.Errorf("line %d: misplaced =", command.Line)
+			}
+			options[args[i]] = "1"
+		}
+	}
+	return options, nil
+}
+
+// TransientOptions reads .tran tstep tstop [tstart [tmax]] and the integration settings of .options:
+// method=trap|gear, maxord, reltol, abstol, chgtol and trtol, besides those of NewtonOptions.
+// ok is false without .tran.
+func (n *Netlist) TransientOptions() (options mna.TransientOptions, ok bool, err error) {
+	command := n.Command("tran")
+	if command == nil {
+		return options, false, nil
+	}
+
+	var times []float64
+	for _, arg := range command.Args {
+		if arg == "uic" {
+			continue
+		}
+		value, err := evaluateToken(arg, n.Params)
+		if err != nil {
+			return options, false, fmt.Errorf("line %d: %v", command.Line, err)
+		}
+		times = append(times, value)
+	}
+	if len(times) < 2 || len(times) > 4 {
+		return options, false, fmt.Errorf("line %d: .tran tstep tstop [tstart [tmax]] expected", command.Line)
+	}
+	options.Step, options.Stop = times[0], times[1]
+	if len(times) > 2 {
+		options.Start = times[2]
+	}
+	if len(times) > 3 {
+		options.MaxStep = times[3]
+	}
+
+	settings, err := n.Options()
+	if err != nil {
+		return options, false, err
+	}
+	if method, ok := settings["method"]; ok {
+		switch strings.Trim(method, "{}") {
+		case "trap", "trapezoidal":
+			options.Method = mna.TRAPEZOIDAL
+		case "gear", "bdf":
+			options.Method = mna.BDF
+		default:
+			return options, false, fmt.Errorf("unknown integration method: %s", method)
+		}
+	}
+
+	maxOrder := float64(options.MaxOrder)
+	numbers := map[string]*float64{
+		"maxord": &maxOrder,
+		"reltol": &options.RelTol,
+		"abstol": &options.AbsTol,
+		"chgtol": &options.ChgTol,
+		"trtol":  &options.TrTol,
+	}
+	if err := n.numberOptions(settings, numbers); err != nil {
+		return options, false, err
+	}
+	options.MaxOrder = int(maxOrder)
+
+	if options.Newton, err = n.NewtonOptions(); err != nil {
+		return options, false, err
+	}
+
+	return options, true, nil
+}
+
+// ACOptions reads .ac lin|dec|oct points fstart fstop, besides the settings of NewtonOptions.
+// ok is false without .ac.
+func (n *Netlist) ACOptions() (options mna.ACOptions, ok bool, err error) {
+	command := n.Command("ac")
+	if command == nil {
+		return options, false, nil
+	}
+	if options, err = n.sweep(command.Args, command.Line); err != nil {
+		return options, false, err
+	}
+	return options, true, nil
+}
+
+// sweep reads the AC sweep lin|dec|oct points fstart fstop of args and the settings of NewtonOptions
+func (n *Netlist) sweep(args []string, line int) (options mna.ACOptions, err error) {
+	if len(args) != 4 {
+		return options, fmt.Errorf("line %d: lin|dec|oct points fstart fstop expected", line)
+	}
+
+	switch args[0] {
+	case "lin":
+		options.Sweep = mna.LINEAR
+	case "dec":
+		options.Sweep = mna.DECADE
+	case "oct":
+		options.Sweep = mna.OCTAVE
+	default:
+		return options, fmt.Errorf("line %d: unknown sweep: %s", line, args[0])
+	}
+
+	var values [3]float64
+	for i, arg := range args[1:] {
+		if values[i], err = evaluateToken(arg, n.Params); err != nil {
+			return options, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	options.Points = int(values[0])
+	options.Start, options.Stop = values[1], values[2]
+	if _, err := options.Frequencies(); err != nil {
+		return options, fmt.Errorf("line %d: %v", line, err)
+	}
+
+	options.Newton, err = n.NewtonOptions()
+	return options, err
+}
+
+// SensitivityOptions reads .sens v(node[,node])|i(source) [ac lin|dec|oct points fstart fstop]: the
+// output as a probe, and the AC sweep, with 0 Points without one. ok is false without .sens.
+func (n *Netlist) SensitivityOptions() (output string, ac mna.ACOptions, ok bool, err error) {
+	command := n.Command("sens")
+	if command == nil {
+		return "", ac, false, nil
+	}
+
+	args := command.Args
+	end := len(args)
+	for i, arg := range args {
+		if arg == "ac" {
+			end = i
+			break
+		}
+	}
+	if output = probe(args[:end]); output == "" {
+		return "", ac, false, fmt.Errorf("line %d: .sens v(node[,node])|i(source) [ac sweep] expected", command.Line)
+	}
+
+	if end < len(args) {
+		if ac, err = n.sweep(args[end+1:], command.Line); err != nil {
+			return "", ac, false, err
+		}
+	}
+	return output, ac, true, nil
+}
+
+// TransferOptions reads .tf v(node[,node])|i(source) source: the output as a probe and the input
+// source. ok is false without .tf.
+func (n *Netlist) TransferOptions() (output, input string, ok bool, err error) {
+	command := n.Command("tf")
+	if command == nil {
+		return "", "", false, nil
+	}
+
+	args := command.Args
+	if len(args) < 3 {
+		return "", "", false, fmt.Errorf("line %d: .tf v(node[,node])|i(source) source expected", command.Line)
+	}
+	if output = probe(args[:len(args)-1]); output == "" {
+		return "", "", false, fmt.Errorf("line %d: .tf v(node[,node])|i(source) source expected", command.Line)
+	}
+	return output, args[len(args)-1], true, nil
+}
+
+// PoleZeroOptions reads .pz v(node[,node])|i(source) source: the output as a probe and the input source,
+// besides the settings of NewtonOptions. ok is false without .pz.
+func (n *Netlist) PoleZeroOptions() (options mna.PoleZeroOptions, ok bool, err error) {
+	command := n.Command("pz")
+	if command == nil {
+		return options, false, nil
+	}
+
+	args := command.Args
+	if len(args) < 3 {
+		return options, false, fmt.Errorf("line %d: .pz v(node[,node])|i(source) source expected", command.Line)
+	}
+	if options.Output = probe(args[:len(args)-1]); options.Output == "" {
+		return options, false, fmt.Errorf("line %d: .pz v(node[,node])|i(source) source expected", command.Line)
+	}
+	options.Input = args[len(args)-1]
+
+	options.Newton, err = n.NewtonOptions()
+	return options, err == nil, err
+}
+
+// NoiseOptions reads .noise v(node[,node]) source lin|dec|oct points fstart fstop [points per summary],
+// besides the settings of NewtonOptions. ok is false without .noise.
+func (n *Netlist) NoiseOptions() (options mna.NoiseOptions, ok bool, err error) {
+	command := n.Command("noise")
+	if command == nil {
+		return options, false, nil
+	}
+
+	args := command.Args
+	usage := fmt.Errorf("line %d: .noise v(node[,node]) source lin|dec|oct points fstart fstop expected", command.Line)
+	end := 0
+	for i := 3; i < len(args) && end == 0; i++ {
+		if args[i] == "lin" || args[i] == "dec" || args[i] == "oct" {
+			end = i
+		}
+	}
+	if end == 0 || len(args) < end+4 || len(args) > end+5 {
+		return options, false, usage
+	}
+	output := probe(args[:end-1])
+	if output == "" || args[0] != "v" {
+		return options, false, usage
+	}
+
+	// The summary interval is accepted and ignored
+	if options.ACOptions, err = n.sweep(args[end:end+4], command.Line); err != nil {
+		return options, false, err
+	}
+	options.Output, options.Input = output, args[end-1]
+	return options, true, nil
+}
+
+// probe rebuilds the output v(node[,node]) or i(source) from its tokens, parentheses and commas being
+// separators, as v out [ref] or i source. It is empty if they are not an output.
+func probe(args []string) string {
+	switch {
+	case len(args) == 2 && (args[0] == "v" || args[0] == "i"):
+		return args[0] + "(" + args[1] + ")"
+	case len(args) == 3 && args[0] == "v":
+		return "v(" + args[1] + "," + args[2] + ")"
+	}
+	return ""
+}
+
+// NewtonOptions reads the settings of .options for the operating point: reltol, abstol, vntol, gmin
+// and itl1, the iteration limit
+func (n *Netlist) NewtonOptions() (mna.NewtonOptions, error) {
+	var options mna.NewtonOptions
+
+	settings, err := n.Options()
+	if err != nil {
+		return options, err
+	}
+
+	iterations := float64(options.MaxIterations)
+	numbers := map[string]*float64{
+		"itl1":   &iterations,
+		"reltol": &options.RelTol,
+		"abstol": &options.AbsTol,
+		"vntol":  &options.VoltTol,
+		"gmin":   &options.Gmin,
+	}
+	if err := n.numberOptions(settings, numbers); err != nil {
+		return options, err
+	}
+	options.MaxIterations = int(iterations)
+
+	return options, nil
+}
+
+// numberOptions evaluates the settings of numbers
+func (n *Netlist) numberOptions(settings map[string]string, numbers map[string]*float64) error {
+	for key, number := range numbers {
+		value, ok := settings[key]
+		if !ok {
+			continue
+		}
+		var err error
+		if *number, err = evaluateToken(value, n.Params); err != nil {
+			return fmt.Errorf("option %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// defaultEdges gives the pulses without rise or fall time the step of .tran, as SPICE
+func (n *Netlist) defaultEdges() {
+	command := n.Command("tran")
+	if command == nil || len(command.Args) == 0 {
+		return
+	}
+	step, err := evaluateToken(command.Args[0], n.Params)
+	if err != nil {
+		return
+	}
+
+	for _, device := range n.Circuit.Devices {
+		var wave mna.Waveform
+		switch device := device.(type) {
+		case *mna.VoltageSource:
+			wave = device.Wave
+		case *mna.CurrentSource:
+			wave = device.Wave
+		}
+		if pulse, ok := wave.(*mna.Pulse); ok {
+			if pulse.Rise == 0.0 {
+				pulse.Rise = step
+			}
+			if pulse.Fall == 0.0 {
+				pulse.Fall = step
+			}
+		}
+	}
+}
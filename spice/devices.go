@@ -0,0 +1,252 @@
+package spice
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/edp1096/sparse/mna"
+)
+
+// Element lines to devices
+
+// sourceKeywords start the parts of a V or I source
+var sourceKeywords = map[string]bool{"dc": true, "ac": true, "sin": true, "pulse": true, "pwl": true, "exp": true, "sffm": true}
+
+// add creates the device of an element line
+func (p *parser) add(e element) error {
+	device, err := p.device(e)
+	if err != nil {
+		return fmt.Errorf("line %d: %s: %v", e.number, e.name, err)
+	}
+	if err := p.netlist.Circuit.Add(device); err != nil {
+		return fmt.Errorf("line %d: %v", e.number, err)
+	}
+	return nil
+}
+
+func (p *parser) device(e element) (mna.Device, error) {
+	c := p.netlist.Circuit
+
+	count := map[byte]int{'r': 2, 'c': 2, 'l': 2, 'v': 2, 'i': 2, 'e': 4, 'g': 4, 'f': 2, 'h': 2, 'k': 0, 'd': 2, 'q': 3, 'm': 4}
+	kind := e.name[len(e.prefix)]
+	nodeCount, ok := count[kind]
+	if !ok {
+		return nil, fmt.Errorf("unsupported element")
+	}
+	if len(e.tokens) < nodeCount+1 {
+		return nil, fmt.Errorf("%d nodes and a value expected", nodeCount)
+	}
+
+	nodes := make([]int64, nodeCount)
+	for i := range nodes {
+		nodes[i] = c.Node(e.nodes(e.tokens[i]))
+	}
+	args := e.tokens[nodeCount:]
+
+	switch kind {
+	case 'd', 'q', 'm':
+		return p.semiconductor(e, kind, nodes, args)
+
+	case 'v', 'i':
+		source, err := parseSource(args, e.params)
+		if err != nil {
+			return nil, err
+		}
+		if kind == 'v' {
+			return mna.NewVoltageSource(e.name, nodes[0], nodes[1], c.Branch(e.name), source), nil
+		}
+		return mna.NewCurrentSource(e.name, nodes[0], nodes[1], source), nil
+
+	case 'f', 'h':
+		if len(args) < 2 {
+			return nil, fmt.Errorf("controlling source and gain expected")
+		}
+		gain, err := evaluateToken(args[1], e.params)
+		if err != nil {
+			return nil, err
+		}
+		control := c.Branch(e.prefix + args[0])
+		if kind == 'f' {
+			return mna.NewCCCS(e.name, nodes[0], nodes[1], control, gain), nil
+		}
+		return mna.NewCCVS(e.name, nodes[0], nodes[1], c.Branch(e.name), control, gain), nil
+
+	case 'k':
+		if len(args) < 3 {
+			return nil, fmt.Errorf("two inductors and a coupling expected")
+		}
+		var inductors [2]*mna.Inductor
+		for i := range inductors {
+			inductor, ok := c.Device(e.prefix + args[i]).(*mna.Inductor)
+			if !ok {
+				return nil, fmt.Errorf("%s is not an inductor", e.prefix+args[i])
+			}
+			inductors[i] = inductor
+		}
+		coupling, err := evaluateToken(args[2], e.params)
+		if err != nil {
+			return nil, err
+		}
+		return mna.NewMutual(e.name, inductors[0], inductors[1], coupling), nil
+	}
+
+	value, err := evaluateToken(args[0], e.params)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case 'r':
+		r := mna.NewResistor(e.name, nodes[0], nodes[1], value)
+		r.Temp = p.temp
+		return r, nil
+	case 'c':
+		return mna.NewCapacitor(e.name, nodes[0], nodes[1], value), nil
+	case 'l':
+		return mna.NewInductor(e.name, nodes[0], nodes[1], c.Branch(e.name), value), nil
+	case 'e':
+		return mna.NewVCVS(e.name, nodes[0], nodes[1], nodes[2], nodes[3], c.Branch(e.name), value), nil
+	default: // 'g'
+		return mna.NewVCCS(e.name, nodes[0], nodes[1], nodes[2], nodes[3], value), nil
+	}
+}
+
+// parseSource reads the values of a V or I source: [[dc] value] [ac mag [phase]] [function args...]
+func parseSource(args []string, params map[string]float64) (mna.Source, error) {
+	var source mna.Source
+	hasDC := false
+
+	// values reads the numbers up to the next keyword
+	values := func(i int) ([]float64, int, error) {
+		var list []float64
+		for ; i < len(args) && !sourceKeywords[args[i]]; i++ {
+			value, err := evaluateToken(args[i], params)
+			if err != nil {
+				return nil, i, err
+			}
+			list = append(list, value)
+		}
+		return list, i, nil
+	}
+
+	for i := 0; i < len(args); {
+		keyword := args[i]
+		if !sourceKeywords[keyword] {
+			keyword = "dc"
+		} else {
+			i++
+		}
+
+		list, next, err := values(i)
+		if err != nil {
+			return source, err
+		}
+		i = next
+
+		switch keyword {
+		case "dc":
+			if len(list) != 1 {
+				return source, fmt.Errorf("one DC value expected")
+			}
+			source.DC = list[0]
+			hasDC = true
+		case "ac":
+			if len(list) < 1 || len(list) > 2 {
+				return source, fmt.Errorf("AC magnitude and optional phase expected")
+			}
+			source.ACMag = list[0]
+			if len(list) == 2 {
+				source.ACPhase = list[1]
+			}
+		default:
+			wave, err := waveform(keyword, list)
+			if err != nil {
+				return source, err
+			}
+			source.Wave = wave
+		}
+	}
+
+	if !hasDC && source.Wave != nil {
+		source.DC = source.Wave.Value(0.0)
+	}
+
+	return source, nil
+}
+
+// waveform returns the transient function of a source
+func waveform(name string, args []float64) (mna.Waveform, error) {
+	switch name {
+	case "sin":
+		if len(args) < 2 || len(args) > 6 {
+			return nil, fmt.Errorf("sin: 2 to 6 arguments expected")
+		}
+		args = append(args, make([]float64, 6-len(args))...)
+		return &mna.Sine{Offset: args[0], Amplitude: args[1], Frequency: args[2], Delay: args[3], Damping: args[4], Phase: args[5]}, nil
+
+	case "pulse":
+		if len(args) < 2 || len(args) > 7 {
+			return nil, fmt.Errorf("pulse: 2 to 7 arguments expected")
+		}
+		pulse := &mna.Pulse{V1: args[0], V2: args[1], Width: math.Inf(1)}
+		for i, field := range []*float64{&pulse.Delay, &pulse.Rise, &pulse.Fall, &pulse.Width, &pulse.Period} {
+			if i+2 < len(args) {
+				*field = args[i+2]
+			}
+		}
+		return pulse, nil
+
+	case "pwl":
+		if len(args) < 2 || len(args)%2 != 0 {
+			return nil, fmt.Errorf("pwl: time and value pairs expected")
+		}
+		pwl := &mna.PWL{}
+		for i := 0; i < len(args); i += 2 {
+			if i > 0 && args[i] <= args[i-2] {
+				return nil, fmt.Errorf("pwl: times must be increasing")
+			}
+			pwl.Times = append(pwl.Times, args[i])
+			pwl.Values = append(pwl.Values, args[i+1])
+		}
+		return pwl, nil
+
+	case "exp":
+		if len(args) < 4 || len(args) > 6 {
+			return nil, fmt.Errorf("exp: 4 to 6 arguments expected")
+		}
+		exp := &mna.Exp{V1: args[0], V2: args[1], RiseDelay: args[2], RiseTau: args[3], FallDelay: math.Inf(1), FallTau: args[3]}
+		if len(args) > 4 {
+			exp.FallDelay = args[4]
+		}
+		if len(args) > 5 {
+			exp.FallTau = args[5]
+		}
+		return exp, nil
+	}
+	return nil, fmt.Errorf("unsupported source function: %s", name)
+}
+
+// checkControls checks that the F and H elements are controlled by the branch of a device
+func (p *parser) checkControls() error {
+	c := p.netlist.Circuit
+	for _, device := range c.Devices {
+		var control int64
+		switch device := device.(type) {
+		case *mna.CCCS:
+			control = device.Control
+		case *mna.CCVS:
+			control = device.Control
+		default:
+			continue
+		}
+
+		name := c.Nodes.Name(control)
+		owner := c.Device(name[len("I(") : len(name)-1])
+		switch owner.(type) {
+		case *mna.VoltageSource, *mna.Inductor, *mna.VCVS, *mna.CCVS:
+		default:
+			return fmt.Errorf("%s: %s is not the current of a voltage source or inductor", device.Name(), name)
+		}
+	}
+	return nil
+}
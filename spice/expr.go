@@ -0,0 +1,306 @@
+package spice
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Values and .param expressions
+
+// suffixes of numbers, longest first so that "meg" is not read as "m"
+var suffixes = []struct {
+	suffix string
+	scale  float64
+}{
+	{"meg", 1e6}, {"mil", 25.4e-6},
+	{"t", 1e12}, {"g", 1e9}, {"k", 1e3}, {"m", 1e-3}, {"u", 1e-6}, {"µ", 1e-6}, {"n", 1e-9}, {"p", 1e-12}, {"f", 1e-15},
+}
+
+// ParseNumber reads a number with an optional engineering suffix, further letters being units: "10k", "1.5meg", "47uF"
+func ParseNumber(s string) (float64, error) {
+	value, n := scanNumber(s)
+	if n == 0 || !isUnit(s[n:]) {
+		return 0.0, fmt.Errorf("invalid number: %s", s)
+	}
+	return value, nil
+}
+
+// scanNumber reads the number with suffix and units at the start of s and returns it with the length read, 0 if none
+func scanNumber(s string) (float64, int) {
+	i := 0
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	digits := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+		digits++
+	}
+	if i < len(s) && s[i] == '.' {
+		i++
+		for i < len(s) && isDigit(s[i]) {
+			i++
+			digits++
+		}
+	}
+	if digits == 0 {
+		return 0.0, 0
+	}
+	if i+1 < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if s[j] == '+' || s[j] == '-' {
+			j++
+		}
+		if j < len(s) && isDigit(s[j]) {
+			for j < len(s) && isDigit(s[j]) {
+				j++
+			}
+			i = j
+		}
+	}
+
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0.0, 0
+	}
+
+	rest := strings.ToLower(s[i:])
+	for _, suffix := range suffixes {
+		if strings.HasPrefix(rest, suffix.suffix) {
+			value *= suffix.scale
+			i += len(suffix.suffix)
+			break
+		}
+	}
+	for i < len(s) && isLetter(s[i]) {
+		i++
+	}
+
+	return value, i
+}
+
+func isUnit(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isLetter(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80 }
+
+func isIdentifier(c byte) bool { return isLetter(c) || isDigit(c) || c == '_' || c == '.' }
+
+// functions of expressions
+var functions = map[string]func(args []float64) (float64, error){
+	"sqrt":  unary(math.Sqrt),
+	"exp":   unary(math.Exp),
+	"log":   unary(math.Log),
+	"ln":    unary(math.Log),
+	"log10": unary(math.Log10),
+	"sin":   unary(math.Sin),
+	"cos":   unary(math.Cos),
+	"tan":   unary(math.Tan),
+	"atan":  unary(math.Atan),
+	"abs":   unary(math.Abs),
+	"pow":   binary(math.Pow),
+	"min":   binary(math.Min),
+	"max":   binary(math.Max),
+}
+
+func unary(fn func(float64) float64) func([]float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0.0, fmt.Errorf("1 argument expected, got %d", len(args))
+		}
+		return fn(args[0]), nil
+	}
+}
+
+func binary(fn func(float64, float64) float64) func([]float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0.0, fmt.Errorf("2 arguments expected, got %d", len(args))
+		}
+		return fn(args[0], args[1]), nil
+	}
+}
+
+// Evaluate computes an expression of numbers, parameters, + - * / ^ (or **), parentheses and functions
+func Evaluate(expr string, params map[string]float64) (float64, error) {
+	p := &exprParser{s: strings.ToLower(expr), params: params}
+	value, err := p.sum()
+	if err != nil {
+		return 0.0, fmt.Errorf("%s: %v", expr, err)
+	}
+	p.space()
+	if p.i < len(p.s) {
+		return 0.0, fmt.Errorf("%s: unexpected %q", expr, p.s[p.i:])
+	}
+	return value, nil
+}
+
+type exprParser struct {
+	s      string
+	i      int
+	params map[string]float64
+}
+
+func (p *exprParser) space() {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// accept consumes op if it is next
+func (p *exprParser) accept(op string) bool {
+	p.space()
+	if strings.HasPrefix(p.s[p.i:], op) {
+		p.i += len(op)
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) sum() (float64, error) {
+	value, err := p.product()
+	for err == nil {
+		switch {
+		case p.accept("+"):
+			var term float64
+			term, err = p.product()
+			value += term
+		case p.accept("-"):
+			var term float64
+			term, err = p.product()
+			value -= term
+		default:
+			return value, nil
+		}
+	}
+	return 0.0, err
+}
+
+func (p *exprParser) product() (float64, error) {
+	value, err := p.unary()
+	for err == nil {
+		p.space()
+		if strings.HasPrefix(p.s[p.i:], "**") {
+			return value, nil
+		}
+		switch {
+		case p.accept("*"):
+			var factor float64
+			factor, err = p.unary()
+			value *= factor
+		case p.accept("/"):
+			var factor float64
+			factor, err = p.unary()
+			value /= factor
+		default:
+			return value, nil
+		}
+	}
+	return 0.0, err
+}
+
+func (p *exprParser) unary() (float64, error) {
+	switch {
+	case p.accept("-"):
+		value, err := p.unary()
+		return -value, err
+	case p.accept("+"):
+		return p.unary()
+	}
+	return p.power()
+}
+
+func (p *exprParser) power() (float64, error) {
+	base, err := p.primary()
+	if err != nil {
+		return 0.0, err
+	}
+	if p.accept("**") || p.accept("^") {
+		exponent, err := p.unary()
+		return math.Pow(base, exponent), err
+	}
+	return base, nil
+}
+
+func (p *exprParser) primary() (float64, error) {
+	p.space()
+	if p.i >= len(p.s) {
+		return 0.0, fmt.Errorf("unexpected end")
+	}
+
+	c := p.s[p.i]
+	switch {
+	case c == '(':
+		p.i++
+		value, err := p.sum()
+		if err != nil {
+			return 0.0, err
+		}
+		if !p.accept(")") {
+			return 0.0, fmt.Errorf("missing )")
+		}
+		return value, nil
+
+	case isDigit(c) || c == '.':
+		value, n := scanNumber(p.s[p.i:])
+		if n == 0 {
+			return 0.0, fmt.Errorf("invalid number at %q", p.s[p.i:])
+		}
+		p.i += n
+		return value, nil
+
+	case isLetter(c) || c == '_':
+		start := p.i
+		for p.i < len(p.s) && isIdentifier(p.s[p.i]) {
+			p.i++
+		}
+		name := p.s[start:p.i]
+
+		if p.accept("(") {
+			fn, ok := functions[name]
+			if !ok {
+				return 0.0, fmt.Errorf("unknown function: %s", name)
+			}
+			var args []float64
+			if !p.accept(")") {
+				for {
+					arg, err := p.sum()
+					if err != nil {
+						return 0.0, err
+					}
+					args = append(args, arg)
+					if p.accept(")") {
+						break
+					}
+					if !p.accept(",") {
+						return 0.0, fmt.Errorf("missing ) of %s", name)
+					}
+				}
+			}
+			return fn(args)
+		}
+
+		if value, ok := p.params[name]; ok {
+			return value, nil
+		}
+		switch name {
+		case "pi":
+			return math.Pi, nil
+		case "e":
+			return math.E, nil
+		}
+		return 0.0, fmt.Errorf("unknown parameter: %s", name)
+	}
+
+	return 0.0, fmt.Errorf("unexpected %q", p.s[p.i:])
+}
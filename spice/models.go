@@ -0,0 +1,143 @@
+package spice
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/edp1096/sparse/mna"
+)
+
+// Model cards and semiconductor elements
+
+// model reads a .model line: .model name type [(] key = value ... [)]
+func (p *parser) model(l line) error {
+	if len(l.tokens) < 3 {
+		return fmt.Errorf("line %d: .model name type expected", l.number)
+	}
+	name, kind := l.tokens[1], l.tokens[2]
+	if _, ok := p.netlist.Models[name]; ok {
+		return fmt.Errorf("line %d: duplicate model: %s", l.number, name)
+	}
+
+	var card mna.Parameters
+	switch kind {
+	case "d":
+		card = mna.NewDiodeModel()
+	case "npn", "pnp":
+		card = mna.NewBJTModel(kind == "pnp")
+	case "nmos", "pmos":
+		card = mna.NewMOSFETModel(kind == "pmos")
+	default:
+		return fmt.Errorf("line %d: unsupported model type: %s", l.number, kind)
+	}
+
+	values := make(map[string]float64)
+	if err := assign(l.tokens[3:], p.netlist.Params, values); err != nil {
+		return fmt.Errorf("line %d: %v", l.number, err)
+	}
+
+	parameters := card.Parameters()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := values[key]
+		switch {
+		case key == "level":
+			if value != 1.0 {
+				return fmt.Errorf("line %d: %s: level %g unsupported", l.number, name, value)
+			}
+		case parameters[key] != nil:
+			*parameters[key] = value
+		default:
+			p.netlist.Warnings = append(p.netlist.Warnings, fmt.Sprintf("line %d: %s: parameter %s ignored", l.number, name, key))
+		}
+	}
+
+	p.netlist.Models[name] = card
+	return nil
+}
+
+// semiconductor creates a D, Q or M device, args being [substrate] model [area] [key = value ...] with
+// the keys area, temp, and l, w, ad and as for M
+func (p *parser) semiconductor(e element, kind byte, nodes []int64, args []string) (mna.Device, error) {
+	models := p.netlist.Models
+	if kind == 'q' && len(args) > 1 && models[args[0]] == nil && models[args[1]] != nil {
+		args = args[1:] // Substrate node, without a substrate junction in the model
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("model expected")
+	}
+	card, ok := models[args[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown model: %s", args[0])
+	}
+
+	// Optional area, then instance parameters
+	name := args[0]
+	args = args[1:]
+	values := map[string]float64{"area": 1.0, "l": 100e-6, "w": 100e-6, "temp": p.temp}
+	first := paramStart(args)
+	for _, arg := range args[:first] {
+		if arg == "off" {
+			continue
+		}
+		value, err := evaluateToken(arg, e.params)
+		if err != nil {
+			return nil, err
+		}
+		values["area"] = value
+	}
+	if err := assign(args[first:], e.params, values); err != nil {
+		return nil, err
+	}
+
+	var device mna.Device
+	switch card := card.(type) {
+	case *mna.DiodeModel:
+		if kind != 'd' {
+			break
+		}
+		d := mna.NewDiode(e.name, nodes[0], nodes[1], card, values["area"])
+		d.Temp = values["temp"]
+		device = d
+	case *mna.BJTModel:
+		if kind != 'q' {
+			break
+		}
+		q := mna.NewBJT(e.name, nodes[0], nodes[1], nodes[2], card, values["area"])
+		q.Temp = values["temp"]
+		device = q
+	case *mna.MOSFETModel:
+		if kind != 'm' {
+			break
+		}
+		f := mna.NewMOSFET(e.name, nodes[0], nodes[1], nodes[2], nodes[3], card, values["l"], values["w"])
+		f.AD, f.AS = values["ad"], values["as"]
+		f.Temp = values["temp"]
+		device = f
+	}
+	if device == nil {
+		return nil, fmt.Errorf("model %s is not for %s elements", name, strings.ToUpper(string(kind)))
+	}
+	return device, nil
+}
+
+// temperature returns the temperature of .temp, TNOM without it
+func (n *Netlist) temperature() (float64, error) {
+	command := n.Command("temp")
+	if command == nil {
+		return mna.TNOM, nil
+	}
+	if len(command.Args) != 1 {
+		return 0.0, fmt.Errorf("line %d: .temp with one temperature expected", command.Line)
+	}
+	temp, err := evaluateToken(command.Args[0], n.Params)
+	if err != nil {
+		return 0.0, fmt.Errorf("line %d: %v", command.Line, err)
+	}
+	return temp, nil
+}
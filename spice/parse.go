@@ -0,0 +1,427 @@
+// Package spice reads a SPICE netlist subset into an mna Circuit.
+//
+// Supported are the R, C, L, V, I, E, F, G, H, K, D, Q and M elements, .model cards (d, npn, pnp, nmos
+// and pmos level 1), .temp, subcircuits (.subckt, .ends and X instances with parameters), .param
+// expressions in braces or quotes, engineering suffixes,
+// comments (*, ; and $) and continuation lines (+). Names are case insensitive and kept in lower case.
+// Nodes and devices inside an instance are prefixed by the instance name, as "x1.n3".
+// Other dot commands are kept in Commands for the analyses. As in SPICE, pulses without rise or
+// fall time take the step of .tran.
+package spice
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/edp1096/sparse"
+	"github.com/edp1096/sparse/mna"
+)
+
+const MAX_SUBCKT_DEPTH = 100 // Deepest nesting of subcircuit instances
+
+// Netlist is a parsed netlist
+type Netlist struct {
+	Title    string
+	Circuit  *mna.Circuit
+	Params   map[string]float64        // Global .param values
+	Models   map[string]mna.Parameters // .model cards by name
+	Commands []Command                 // Dot commands other than .param, .model, .subckt, .ends and .end
+	Warnings []string                  // Unsupported model parameters
+}
+
+// Command is a dot command, as ".tran 1n 1u" with Name "tran"
+type Command struct {
+	Name string
+	Args []string
+	Line int
+}
+
+type line struct {
+	tokens []string
+	number int
+}
+
+type subckt struct {
+	name   string
+	ports  []string
+	params []string // Default parameters, as key = value tokens
+	body   []line
+}
+
+// element is an element line of the flattened netlist
+type element struct {
+	name   string // With the instance prefixes
+	tokens []string
+	nodes  func(string) string // Maps a local node name to the flattened one
+	prefix string
+	params map[string]float64
+	number int
+}
+
+type parser struct {
+	netlist  *Netlist
+	subckts  map[string]*subckt
+	elements []element
+	mutuals  []element
+	models   []line
+	temp     float64 // Of .temp, for the semiconductor devices
+}
+
+// ParseFile reads a netlist file
+func ParseFile(path string) (*Netlist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Parse reads a netlist, the first line being the title
+func Parse(r io.Reader) (*Netlist, error) {
+	lines, title, err := readLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{
+		netlist: &Netlist{Title: title, Circuit: mna.NewCircuit(), Params: make(map[string]float64), Models: make(map[string]mna.Parameters)},
+		subckts: make(map[string]*subckt),
+	}
+
+	top, err := p.collect(lines)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range p.models {
+		if err := p.model(l); err != nil {
+			return nil, err
+		}
+	}
+	if p.temp, err = p.netlist.temperature(); err != nil {
+		return nil, err
+	}
+	if err := p.flatten(top, "", nil, p.netlist.Params, 0); err != nil {
+		return nil, err
+	}
+
+	for _, e := range p.elements {
+		if err := p.add(e); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range p.mutuals {
+		if err := p.add(e); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.checkControls(); err != nil {
+		return nil, err
+	}
+	p.netlist.defaultEdges()
+
+	return p.netlist, nil
+}
+
+// readLines joins continuation lines, drops comments and splits the lines in tokens
+func readLines(r io.Reader) ([]line, string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var lines []line
+	title := ""
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := scanner.Text()
+		if number == 1 {
+			title = strings.TrimSpace(text)
+			continue
+		}
+
+		text = stripComment(text)
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || trimmed[0] == '*' {
+			continue
+		}
+
+		if trimmed[0] == '+' {
+			if len(lines) == 0 {
+				return nil, "", fmt.Errorf("line %d: continuation without a line", number)
+			}
+			last := &lines[len(lines)-1]
+			last.tokens = append(last.tokens, tokenize(trimmed[1:])...)
+			continue
+		}
+
+		if tokens := tokenize(trimmed); len(tokens) > 0 {
+			lines = append(lines, line{tokens: tokens, number: number})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, "", err
+	}
+
+	return lines, title, nil
+}
+
+// stripComment removes a ; or $ comment, outside expressions
+func stripComment(text string) string {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ';':
+			if depth == 0 {
+				return text[:i]
+			}
+		case '$':
+			if depth == 0 && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t') {
+				return text[:i]
+			}
+		}
+	}
+	return text
+}
+
+// tokenize splits a line in lower case tokens at spaces, commas and parentheses. "=" is a token,
+// expressions in braces or quotes are kept whole, as "{...}".
+func tokenize(text string) []string {
+	var tokens []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, strings.ToLower(current.String()))
+			current.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch c {
+		case ' ', '\t', ',', '(', ')':
+			flush()
+		case '=':
+			flush()
+			tokens = append(tokens, "=")
+		case '{', '\'':
+			flush()
+			end := byte('}')
+			if c == '\'' {
+				end = '\''
+			}
+			depth := 0
+			j := i + 1
+			for ; j < len(text); j++ {
+				if text[j] == '{' && c == '{' {
+					depth++
+				} else if text[j] == end {
+					if depth == 0 {
+						break
+					}
+					depth--
+				}
+			}
+			tokens = append(tokens, "{"+strings.ToLower(text[i+1:min(j, len(text))])+"}")
+			i = j
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+// collect registers the subcircuit definitions and global parameters and returns the top level element lines
+func (p *parser) collect(lines []line) ([]line, error) {
+	var top []line
+	var open []*subckt
+
+	for _, l := range lines {
+		keyword := l.tokens[0]
+		switch {
+		case keyword == ".subckt":
+			if len(l.tokens) < 2 {
+				return nil, fmt.Errorf("line %d: .subckt without a name", l.number)
+			}
+			s := &subckt{name: l.tokens[1]}
+			args := l.tokens[2:]
+			first := paramStart(args)
+			s.ports = args[:first]
+			s.params = args[first:]
+			if len(s.params) > 0 && s.params[0] == "params:" {
+				s.params = s.params[1:]
+			}
+			if _, ok := p.subckts[s.name]; ok {
+				return nil, fmt.Errorf("line %d: duplicate subcircuit: %s", l.number, s.name)
+			}
+			p.subckts[s.name] = s
+			open = append(open, s)
+
+		case keyword == ".model":
+			p.models = append(p.models, l)
+
+		case keyword == ".ends":
+			if len(open) == 0 {
+				return nil, fmt.Errorf("line %d: .ends without .subckt", l.number)
+			}
+			open = open[:len(open)-1]
+
+		case len(open) > 0:
+			s := open[len(open)-1]
+			s.body = append(s.body, l)
+
+		case keyword == ".param":
+			if err := assign(l.tokens[1:], p.netlist.Params, p.netlist.Params); err != nil {
+				return nil, fmt.Errorf("line %d: %v", l.number, err)
+			}
+
+		case keyword == ".end":
+			if len(open) > 0 {
+				return nil, fmt.Errorf("line %d: .end inside subcircuit %s", l.number, open[len(open)-1].name)
+			}
+			return top, nil
+
+		case keyword[0] == '.':
+			p.netlist.Commands = append(p.netlist.Commands, Command{Name: keyword[1:], Args: l.tokens[1:], Line: l.number})
+
+		default:
+			top = append(top, l)
+		}
+	}
+	if len(open) > 0 {
+		return nil, fmt.Errorf("subcircuit %s without .ends", open[len(open)-1].name)
+	}
+
+	return top, nil
+}
+
+// paramStart returns the index of the first key = value or params: token
+func paramStart(tokens []string) int {
+	for i, token := range tokens {
+		if token == "params:" {
+			return i
+		}
+		if token == "=" {
+			return max(i-1, 0)
+		}
+	}
+	return len(tokens)
+}
+
+// assign evaluates key = value tokens in scope and stores them in params
+func assign(tokens []string, scope, params map[string]float64) error {
+	for i := 0; i < len(tokens); i += 3 {
+		if i+2 >= len(tokens) || tokens[i+1] != "=" {
+			return fmt.Errorf("expected name = value at %s", strings.Join(tokens[i:], " "))
+		}
+		value, err := evaluateToken(tokens[i+2], scope)
+		if err != nil {
+			return err
+		}
+		params[tokens[i]] = value
+	}
+	return nil
+}
+
+// evaluateToken returns the value of a number, an expression in braces or a parameter
+func evaluateToken(token string, params map[string]float64) (float64, error) {
+	if strings.HasPrefix(token, "{") {
+		return Evaluate(strings.TrimSuffix(token[1:], "}"), params)
+	}
+	if value, err := ParseNumber(token); err == nil {
+		return value, nil
+	}
+	return Evaluate(token, params)
+}
+
+// flatten expands the element lines of a level, prefix and nodes being those of the instance
+func (p *parser) flatten(lines []line, prefix string, nodes map[string]string, params map[string]float64, depth int) error {
+	if depth > MAX_SUBCKT_DEPTH {
+		return fmt.Errorf("subcircuits nested deeper than %d", MAX_SUBCKT_DEPTH)
+	}
+
+	mapNode := func(node string) string {
+		if sparse.IsGround(node) {
+			return "0"
+		}
+		if outer, ok := nodes[node]; ok {
+			return outer
+		}
+		return prefix + node
+	}
+
+	for _, l := range lines {
+		name := l.tokens[0]
+		e := element{name: prefix + name, tokens: l.tokens[1:], nodes: mapNode, prefix: prefix, params: params, number: l.number}
+
+		switch name[0] {
+		case '.':
+			if name != ".param" {
+				return fmt.Errorf("line %d: %s inside a subcircuit", l.number, name)
+			}
+			if err := assign(l.tokens[1:], params, params); err != nil {
+				return fmt.Errorf("line %d: %v", l.number, err)
+			}
+		case 'x':
+			if err := p.instance(e); err != nil {
+				return err
+			}
+		case 'k':
+			p.mutuals = append(p.mutuals, e)
+		default:
+			p.elements = append(p.elements, e)
+		}
+	}
+
+	return nil
+}
+
+// instance expands an X line
+func (p *parser) instance(e element) error {
+	first := paramStart(e.tokens)
+	if first == 0 {
+		return fmt.Errorf("line %d: %s: missing subcircuit name", e.number, e.name)
+	}
+	name := e.tokens[first-1]
+	ports := e.tokens[:first-1]
+	args := e.tokens[first:]
+	if len(args) > 0 && args[0] == "params:" {
+		args = args[1:]
+	}
+
+	s, ok := p.subckts[name]
+	if !ok {
+		return fmt.Errorf("line %d: %s: unknown subcircuit: %s", e.number, e.name, name)
+	}
+	if len(ports) != len(s.ports) {
+		return fmt.Errorf("line %d: %s: %d nodes for subcircuit %s of %d ports", e.number, e.name, len(ports), name, len(s.ports))
+	}
+
+	nodes := make(map[string]string, len(ports))
+	for i, port := range s.ports {
+		nodes[port] = e.nodes(ports[i])
+	}
+
+	// Defaults in the global scope, then the values of the instance in the scope of the caller
+	params := make(map[string]float64, len(p.netlist.Params)+len(s.params)/3)
+	for key, value := range p.netlist.Params {
+		params[key] = value
+	}
+	if err := assign(s.params, params, params); err != nil {
+		return fmt.Errorf("line %d: %s: %v", e.number, e.name, err)
+	}
+	if err := assign(args, e.params, params); err != nil {
+		return fmt.Errorf("line %d: %s: %v", e.number, e.name, err)
+	}
+
+	return p.flatten(s.body, e.name+".", nodes, params, strings.Count(e.name, ".")+1)
+}
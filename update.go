@@ -0,0 +1,484 @@
+package sparse
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// Low-rank update of a factored matrix with the Sherman-Morrison-Woodbury identity.
+// For A' = A + U C W, where U selects the changed rows and W the changed columns,
+//   inv(A') = inv(A) - inv(A) U inv(I + C W inv(A) U) C W inv(A)
+// so a few element changes cost one extra solve per changed row instead of a refactorization.
+
+// ElementDelta is a change of one element in external indexing
+type ElementDelta struct {
+	Row  int64
+	Col  int64
+	Real float64
+	Imag float64
+}
+
+type LowRankUpdate struct {
+	Matrix *Matrix
+	Deltas []ElementDelta // Accumulated changes, not yet folded into the factors
+	RCond  float64        // Reciprocal condition of the capacitance matrix
+
+	rows []int64        // Distinct external rows of the deltas
+	cols []int64        // Distinct external columns of the deltas
+	c    [][]complex128 // Delta block [len(rows)][len(cols)]
+
+	z  [][]complex128 // inv(A) e_row for each row
+	s  denseLU        // Capacitance matrix I + C W inv(A) U
+	zt [][]complex128 // inv(A^T) e_col for each column, on first transposed solve
+	st *denseLU       // Capacitance matrix of the transposed system
+}
+
+// Update returns a solver for the factored matrix with the element changes applied.
+// It fails when the capacitance matrix is ill-conditioned; the matrix then needs refactorization.
+func (m *Matrix) Update(deltas []ElementDelta) (*LowRankUpdate, error) {
+	if !m.Factored {
+		return nil, fmt.Errorf("matrix is not factored")
+	}
+
+	u := &LowRankUpdate{Matrix: m}
+	if err := u.Add(deltas...); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Add accumulates more element changes. The update is left unchanged when the result is rejected.
+func (u *LowRankUpdate) Add(deltas ...ElementDelta) error {
+	previous := u.Deltas
+
+	merged := make([]ElementDelta, len(previous), len(previous)+len(deltas))
+	copy(merged, previous)
+	for _, delta := range deltas {
+		if delta.Row < 0 || delta.Col < 0 {
+			return fmt.Errorf("invalid index (%d,%d)", delta.Row, delta.Col)
+		}
+		if delta.Imag != 0.0 && !u.Matrix.Complex {
+			return fmt.Errorf("imaginary change at (%d,%d) of a real matrix", delta.Row, delta.Col)
+		}
+		if delta.Row == 0 || delta.Col == 0 {
+			continue // ground
+		}
+		if _, _, err := u.Matrix.internalIndex(delta.Row, delta.Col); err != nil {
+			return err
+		}
+
+		found := false
+		for i := range merged {
+			if merged[i].Row == delta.Row && merged[i].Col == delta.Col {
+				merged[i].Real += delta.Real
+				merged[i].Imag += delta.Imag
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, delta)
+		}
+	}
+
+	u.Deltas = merged
+	if err := u.compute(); err != nil {
+		u.Deltas = previous
+		if cerr := u.compute(); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+	return nil
+}
+
+func (u *LowRankUpdate) compute() error {
+	u.rows, u.cols = u.rows[:0], u.cols[:0]
+	rowIndex := map[int64]int{}
+	colIndex := map[int64]int{}
+	for _, delta := range u.Deltas {
+		if _, ok := rowIndex[delta.Row]; !ok {
+			rowIndex[delta.Row] = len(u.rows)
+			u.rows = append(u.rows, delta.Row)
+		}
+		if _, ok := colIndex[delta.Col]; !ok {
+			colIndex[delta.Col] = len(u.cols)
+			u.cols = append(u.cols, delta.Col)
+		}
+	}
+
+	u.c = make([][]complex128, len(u.rows))
+	for i := range u.c {
+		u.c[i] = make([]complex128, len(u.cols))
+	}
+	for _, delta := range u.Deltas {
+		u.c[rowIndex[delta.Row]][colIndex[delta.Col]] += complex(delta.Real, delta.Imag)
+	}
+
+	u.z = make([][]complex128, len(u.rows))
+	for i, row := range u.rows {
+		z, err := u.Matrix.solveUnit(row, false)
+		if err != nil {
+			return err
+		}
+		u.z[i] = z
+	}
+
+	// S = I + C * (inv(A) U)[cols]
+	p := len(u.rows)
+	s := make([][]complex128, p)
+	for i := 0; i < p; i++ {
+		s[i] = make([]complex128, p)
+		s[i][i] = 1.0
+		for k := 0; k < p; k++ {
+			for j, col := range u.cols {
+				s[i][k] += u.c[i][j] * u.z[k][col]
+			}
+		}
+	}
+
+	lu, err := factorCapacitance(s, u.Matrix.Config.UpdateRCondLimit)
+	if err != nil {
+		return err
+	}
+	u.s = *lu
+	u.RCond = lu.rcond
+	u.zt = nil
+	u.st = nil
+
+	return nil
+}
+
+// Solve solves (A + deltas) x = b. Vector layout follows Matrix.Solve.
+func (u *LowRankUpdate) Solve(rhs []float64) ([]float64, error) {
+	x, _, err := u.solve(rhs, nil, false)
+	return x, err
+}
+
+// SolveTransposed solves (A + deltas)^T x = b. Vector layout follows Matrix.SolveTransposed.
+func (u *LowRankUpdate) SolveTransposed(rhs []float64) ([]float64, error) {
+	x, _, err := u.solve(rhs, nil, true)
+	return x, err
+}
+
+// SolveComplex solves the complex system. Vector layout follows Matrix.SolveComplex.
+func (u *LowRankUpdate) SolveComplex(rhs, irhs []float64) ([]float64, []float64, error) {
+	return u.solve(rhs, irhs, false)
+}
+
+// SolveComplexTransposed solves the transposed complex system. Vector layout follows Matrix.SolveComplexTransposed.
+func (u *LowRankUpdate) SolveComplexTransposed(rhs, irhs []float64) ([]float64, []float64, error) {
+	return u.solve(rhs, irhs, true)
+}
+
+func (u *LowRankUpdate) solve(rhs, irhs []float64, transposed bool) ([]float64, []float64, error) {
+	m := u.Matrix
+	if !m.Factored {
+		return nil, nil, fmt.Errorf("matrix is not factored")
+	}
+
+	b, err := m.toComplexVector(rhs, irhs)
+	if err != nil {
+		return nil, nil, err
+	}
+	y, err := m.solveVector(b, transposed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Columns of the correction and rows/columns of C, swapped for the transposed system
+	z, lu, from, to := u.z, &u.s, u.cols, u.rows
+	if transposed {
+		if err := u.computeTransposed(); err != nil {
+			return nil, nil, err
+		}
+		z, lu, from, to = u.zt, u.st, u.rows, u.cols
+	}
+
+	t := make([]complex128, len(to))
+	for i := range to {
+		for j, index := range from {
+			if transposed {
+				t[i] += u.c[j][i] * y[index]
+			} else {
+				t[i] += u.c[i][j] * y[index]
+			}
+		}
+	}
+	lu.solve(t)
+
+	for k := range t {
+		if t[k] == 0 {
+			continue
+		}
+		// z has GetSize(true)+1 entries, rhs may be longer
+		for i := range min(len(y), len(z[k])) {
+			y[i] -= z[k][i] * t[k]
+		}
+	}
+
+	re, im := m.fromComplexVector(y, len(rhs))
+	return re, im, nil
+}
+
+func (u *LowRankUpdate) computeTransposed() error {
+	if u.st != nil {
+		return nil
+	}
+
+	u.zt = make([][]complex128, len(u.cols))
+	for j, col := range u.cols {
+		z, err := u.Matrix.solveUnit(col, true)
+		if err != nil {
+			return err
+		}
+		u.zt[j] = z
+	}
+
+	// S' = I + C^T * (inv(A^T) W^T)[rows]
+	q := len(u.cols)
+	s := make([][]complex128, q)
+	for j := 0; j < q; j++ {
+		s[j] = make([]complex128, q)
+		s[j][j] = 1.0
+		for l := 0; l < q; l++ {
+			for i, row := range u.rows {
+				s[j][l] += u.c[i][j] * u.zt[l][row]
+			}
+		}
+	}
+
+	lu, err := factorCapacitance(s, u.Matrix.Config.UpdateRCondLimit)
+	if err != nil {
+		return err
+	}
+	u.st = lu
+	return nil
+}
+
+// Fold adds the accumulated changes to the matrix and refactors it. Needs Config.PartialFactor,
+// which keeps the unfactored values, so that only the columns reached by the changes are recomputed.
+func (u *LowRankUpdate) Fold() error {
+	m := u.Matrix
+	if !m.Config.PartialFactor {
+		return fmt.Errorf("unfactored values not kept, set PartialFactor or clear and restamp the matrix")
+	}
+	if m.OriginalValues == nil {
+		return fmt.Errorf("unfactored values not saved, factor the matrix before folding")
+	}
+
+	elements := make([]*Element, len(u.Deltas))
+	missing := false
+	for i, delta := range u.Deltas {
+		row, col, err := m.internalIndex(delta.Row, delta.Col)
+		if err != nil {
+			return err
+		}
+		elements[i] = m.findElement(row, col)
+		if elements[i] == nil {
+			missing = true
+		}
+	}
+
+	// New elements change the structure, so the whole matrix is reordered
+	if missing && !m.Config.Translate {
+		return fmt.Errorf("set Translate to add elements to a reordered matrix")
+	}
+	for i, delta := range u.Deltas {
+		element := elements[i]
+		if element == nil {
+			if element = m.GetElement(delta.Row, delta.Col); element == nil {
+				return fmt.Errorf("failed to get element (%d,%d)", delta.Row, delta.Col)
+			}
+		}
+		m.AddToElement(element, delta.Real, delta.Imag)
+	}
+
+	m.Factored = false
+	if err := m.Factor(); err != nil {
+		return err
+	}
+
+	u.Deltas = nil
+	return u.compute()
+}
+
+// solveUnit returns column index of inv(A), or of inv(A^T) when transposed, in external indexing
+func (m *Matrix) solveUnit(index int64, transposed bool) ([]complex128, error) {
+	b := make([]complex128, m.GetSize(true)+1)
+	b[index] = 1.0
+	return m.solveVector(b, transposed)
+}
+
+// solveVector solves with the current factors for a complex vector in external indexing
+func (m *Matrix) solveVector(b []complex128, transposed bool) ([]complex128, error) {
+	rhs, irhs := m.fromComplexVector(b, 0)
+
+	var x, ix []float64
+	var err error
+	switch {
+	case !m.Complex && transposed:
+		x, err = m.SolveTransposed(rhs)
+	case !m.Complex:
+		x, err = m.Solve(rhs)
+	case transposed:
+		if irhs == nil {
+			irhs = make([]float64, len(rhs))
+		}
+		x, ix, err = m.SolveComplexTransposed(rhs, irhs)
+	default:
+		x, ix, err = m.SolveComplex(rhs, irhs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := m.toComplexVector(x, ix)
+	if err != nil {
+		return nil, err
+	}
+	return y[:len(b)], nil
+}
+
+// toComplexVector converts a solve vector in the layout of the matrix into complex values
+func (m *Matrix) toComplexVector(rhs, irhs []float64) ([]complex128, error) {
+	switch {
+	case !m.Complex:
+		v := make([]complex128, len(rhs))
+		for i, value := range rhs {
+			v[i] = complex(value, 0)
+		}
+		return v, nil
+	case m.Config.SeparatedComplexVectors:
+		if len(irhs) < len(rhs) {
+			return nil, fmt.Errorf("irhs array size(%d) is smaller than rhs array size(%d)", len(irhs), len(rhs))
+		}
+		v := make([]complex128, len(rhs))
+		for i := range rhs {
+			v[i] = complex(rhs[i], irhs[i])
+		}
+		return v, nil
+	default:
+		v := make([]complex128, len(rhs)/2)
+		for i := range v {
+			v[i] = complex(rhs[2*i], rhs[2*i+1])
+		}
+		return v, nil
+	}
+}
+
+// fromComplexVector converts complex values into the layout of the matrix.
+// length is the wanted length of the first returned slice, 0 for the default.
+func (m *Matrix) fromComplexVector(v []complex128, length int) ([]float64, []float64) {
+	switch {
+	case !m.Complex:
+		rhs := make([]float64, max(length, len(v)))
+		for i, value := range v {
+			rhs[i] = real(value)
+		}
+		return rhs, nil
+	case m.Config.SeparatedComplexVectors:
+		rhs := make([]float64, max(length, len(v)))
+		irhs := make([]float64, max(length, len(v)))
+		for i, value := range v {
+			rhs[i] = real(value)
+			irhs[i] = imag(value)
+		}
+		return rhs, irhs
+	default:
+		rhs := make([]float64, max(length, 2*len(v)))
+		for i, value := range v {
+			rhs[2*i] = real(value)
+			rhs[2*i+1] = imag(value)
+		}
+		return rhs, nil
+	}
+}
+
+// denseLU is the LU factorization with partial pivoting of a small dense complex matrix
+type denseLU struct {
+	a     [][]complex128
+	perm  []int
+	rcond float64
+}
+
+// factorCapacitance factors the capacitance matrix a and fails when its reciprocal condition number is below limit
+func factorCapacitance(a [][]complex128, limit float64) (*denseLU, error) {
+	n := len(a)
+	lu := &denseLU{a: a, perm: make([]int, n)}
+
+	norm := 0.0
+	for j := 0; j < n; j++ {
+		sum := 0.0
+		for i := 0; i < n; i++ {
+			sum += cmplx.Abs(a[i][j])
+		}
+		norm = math.Max(norm, sum)
+	}
+
+	for k := 0; k < n; k++ {
+		p := k
+		for i := k + 1; i < n; i++ {
+			if cmplx.Abs(a[i][k]) > cmplx.Abs(a[p][k]) {
+				p = i
+			}
+		}
+		lu.perm[k] = p
+		if a[p][k] == 0 {
+			return nil, fmt.Errorf("capacitance matrix is singular, refactor instead")
+		}
+		a[k], a[p] = a[p], a[k]
+
+		for i := k + 1; i < n; i++ {
+			a[i][k] /= a[k][k]
+			for j := k + 1; j < n; j++ {
+				a[i][j] -= a[i][k] * a[k][j]
+			}
+		}
+	}
+
+	// 1-norm of the inverse, column by column
+	inverseNorm := 0.0
+	for j := 0; j < n; j++ {
+		e := make([]complex128, n)
+		e[j] = 1.0
+		lu.solve(e)
+		sum := 0.0
+		for i := range e {
+			sum += cmplx.Abs(e[i])
+		}
+		inverseNorm = math.Max(inverseNorm, sum)
+	}
+
+	if n > 0 {
+		lu.rcond = 1.0 / (norm * inverseNorm)
+	} else {
+		lu.rcond = 1.0
+	}
+	if lu.rcond < limit {
+		return nil, fmt.Errorf("capacitance matrix is ill-conditioned (rcond %.3g), refactor instead", lu.rcond)
+	}
+
+	return lu, nil
+}
+
+// solve overwrites b with inv(A) b
+func (lu *denseLU) solve(b []complex128) {
+	n := len(lu.a)
+	for k := 0; k < n; k++ {
+		p := lu.perm[k]
+		b[k], b[p] = b[p], b[k]
+	}
+	for i := 0; i < n; i++ {
+		for j := 0; j < i; j++ {
+			b[i] -= lu.a[i][j] * b[j]
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := i + 1; j < n; j++ {
+			b[i] -= lu.a[i][j] * b[j]
+		}
+		b[i] /= lu.a[i][i]
+	}
+}
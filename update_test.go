@@ -0,0 +1,115 @@
+package sparse
+
+import "testing"
+
+// testApply returns deltas with changes added to the matching entries
+func testApply(deltas, changes []ElementDelta) []ElementDelta {
+	changed := append([]ElementDelta(nil), deltas...)
+	for _, change := range changes {
+		for i := range changed {
+			if changed[i].Row == change.Row && changed[i].Col == change.Col {
+				changed[i].Real += change.Real
+				changed[i].Imag += change.Imag
+			}
+		}
+	}
+	return changed
+}
+
+func TestUpdateAndFold(t *testing.T) {
+	const n = 60
+	for _, isComplex := range []bool{false, true} {
+		for _, monitor := range []bool{false, true} {
+			deltas := testDeltas(n, 4, isComplex, 1)
+			m, _ := testMatrix(t, n, deltas, Configuration{Real: true, Complex: isComplex, PartialFactor: true, MonitorPivots: monitor})
+			if err := m.Factor(); err != nil {
+				t.Fatal(err)
+			}
+
+			changes := []ElementDelta{}
+			for _, i := range []int{3, 50, 120} {
+				change := ElementDelta{Row: deltas[i].Row, Col: deltas[i].Col, Real: 0.5}
+				if isComplex {
+					change.Imag = -0.25
+				}
+				changes = append(changes, change)
+			}
+			changed := testApply(deltas, changes)
+			b := testRHS(n)
+
+			u, err := m.Update(changes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, transposed := range []bool{false, true} {
+				rhs, irhs := m.fromComplexVector(b, 0)
+				var x, ix []float64
+				if transposed {
+					x, ix, err = u.SolveComplexTransposed(rhs, irhs)
+				} else {
+					x, ix, err = u.SolveComplex(rhs, irhs)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				y, err := m.toComplexVector(x, ix)
+				if err != nil {
+					t.Fatal(err)
+				}
+				testClose(t, "update", y[:len(b)], testReference(t, n, changed, isComplex, b, transposed), 1e-9)
+			}
+
+			if err := u.Fold(); err != nil {
+				t.Fatal(err)
+			}
+			testClose(t, "fold", testSolution(t, m, b, false), testReference(t, n, changed, isComplex, b, false), 1e-9)
+		}
+	}
+}
+
+func TestFoldNeedsPartialFactor(t *testing.T) {
+	const n = 20
+	deltas := testDeltas(n, 3, false, 2)
+	m, _ := testMatrix(t, n, deltas, Configuration{Real: true, MonitorPivots: true})
+	if err := m.Factor(); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := m.Update([]ElementDelta{{Row: deltas[0].Row, Col: deltas[0].Col, Real: 1.0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Fold(); err == nil {
+		t.Fatal("Fold without PartialFactor succeeded")
+	}
+}
+
+func TestUpdateRejectsImagOfReal(t *testing.T) {
+	const n = 20
+	deltas := testDeltas(n, 3, false, 3)
+	m, _ := testMatrix(t, n, deltas, Configuration{Real: true, PartialFactor: true})
+	if err := m.Factor(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Update([]ElementDelta{{Row: deltas[0].Row, Col: deltas[0].Col, Imag: 1.0}}); err == nil {
+		t.Fatal("imaginary change of a real matrix accepted")
+	}
+}
+
+func TestUpdateSeparatedLength(t *testing.T) {
+	const n = 20
+	deltas := testDeltas(n, 3, true, 4)
+	m, _ := testMatrix(t, n, deltas, Configuration{Real: true, Complex: true, SeparatedComplexVectors: true})
+	if err := m.Factor(); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := m.Update([]ElementDelta{{Row: deltas[0].Row, Col: deltas[0].Col, Real: 1.0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := u.SolveComplex(make([]float64, n+1), make([]float64, n/2)); err == nil {
+		t.Fatal("short irhs accepted")
+	}
+}
@@ -25,6 +25,7 @@ func (m *Matrix) Determinant() (determinant float64, exponent int, imagDetermina
 	if m == nil || !m.Factored {
 		return 0.0, 0, nil
 	}
+	m.syncFactors()
 
 	if m.SingularRow > 0 || m.SingularCol > 0 {
 		var singularZero float64 = 0.0
@@ -131,6 +132,7 @@ func (m *Matrix) LargestElement() float64 {
 	if m == nil {
 		return 0.0
 	}
+	m.syncFactors()
 
 	if !m.Factored {
 		max := 0.0
@@ -224,6 +226,7 @@ func (m *Matrix) Roundoff(rho float64) float64 {
 	if m == nil || !m.Factored {
 		return 0.0
 	}
+	m.syncFactors()
 
 	// Compute Barlow's bound if not given
 	if rho < 0.0 {
@@ -301,6 +304,7 @@ func (m *Matrix) Condition(normOfMatrix float64) (float64, error) {
 	if m == nil || !m.Factored {
 		return 0.0, fmt.Errorf("matrix not valid or not factored")
 	}
+	m.syncFactors()
 	if normOfMatrix == 0.0 {
 		return 0.0, fmt.Errorf("singular")
 	}
@@ -679,6 +683,7 @@ func (m *Matrix) PseudoCondition() float64 {
 	if m == nil || !m.Factored || m.SingularRow > 0 || m.SingularCol > 0 {
 		return 0.0
 	}
+	m.syncFactors()
 
 	var mag float64
 	if m.Complex {